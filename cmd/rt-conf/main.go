@@ -5,16 +5,33 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/canonical/go-snapctl/env"
+	"github.com/canonical/rt-conf/src/cpulists"
 	"github.com/canonical/rt-conf/src/debug"
 	"github.com/canonical/rt-conf/src/irq"
 	"github.com/canonical/rt-conf/src/kcmd"
 	"github.com/canonical/rt-conf/src/model"
 	pwrmgmt "github.com/canonical/rt-conf/src/pwr_mgmt"
+	"github.com/canonical/rt-conf/src/sysfs"
+	"github.com/canonical/rt-conf/src/system"
 )
 
+// commands maps the subcommands to their handlers.
+// Running without a subcommand applies the configuration.
+var commands = map[string]func(args []string) error{
+	"apply":   runApply,
+	"capture": runCapture,
+	"commit":  runCommit,
+	"detect":  runDetect,
+	"reset":   runReset,
+	"status":  runStatus,
+}
+
 func main() {
 	if err := run(os.Args); err != nil {
 		log.Fatal("Error: ", err)
@@ -22,6 +39,35 @@ func main() {
 }
 
 func run(args []string) error {
+	command := "apply"
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		command = args[1]
+		args = append([]string{args[0] + " " + command}, args[2:]...)
+	}
+
+	runCommand, ok := commands[command]
+	if !ok {
+		return fmt.Errorf("unknown command: %q", command)
+	}
+	return runCommand(args)
+}
+
+// options holds the flags shared by all commands
+type options struct {
+	configPath        *string
+	grubCfgPath       *string
+	extlinuxPath      *string
+	kernelCmdlinePath *string
+	verbose           *bool
+	dryRun            *bool
+	snapdTimeout      *time.Duration
+	arch              *string
+	bootloader        *string
+	root              *string
+	cpus              *int
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *options, error) {
 	envConfigFile := os.Getenv("CONFIG_FILE")
 	verboseDefaultCfg := false
 	var err error
@@ -29,54 +75,165 @@ func run(args []string) error {
 	if ok {
 		verboseDefaultCfg, err = strconv.ParseBool(envVerbose)
 		if err != nil {
-			return fmt.Errorf("failed to parse verbose configuration: %v", err)
+			return nil, nil, fmt.Errorf("failed to parse verbose configuration: %v", err)
 		}
 	}
 
-	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
-	configPath := flags.String("file",
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	opts := &options{}
+	opts.configPath = flags.String("file",
 		envConfigFile,
 		"Path to the configuration file")
-	grubCfgPath := flags.String("grub-custom-file",
+	opts.grubCfgPath = flags.String("grub-custom-file",
 		"/etc/default/grub.d/60_rt-conf.cfg",
 		"Path to the output drop-in grub configuration file, relevant only for GRUB bootloader")
-	verbose := flags.Bool("verbose",
+	opts.extlinuxPath = flags.String("extlinux-file",
+		"/boot/extlinux/extlinux.conf",
+		"Path to the extlinux configuration file, relevant only for U-Boot bootloader")
+	opts.kernelCmdlinePath = flags.String("kernel-cmdline-file",
+		"/etc/kernel/cmdline",
+		"Path to the kernel-install command line file, relevant only for systemd-boot bootloader")
+	opts.verbose = flags.Bool("verbose",
 		verboseDefaultCfg,
 		"Verbose mode, prints more information to the console")
+	opts.dryRun = flags.Bool("dry-run",
+		false,
+		"Print the changes that would be applied, without applying them")
+	opts.snapdTimeout = flags.Duration("snapd-timeout",
+		5*time.Minute,
+		"Maximum time to wait for snapd to apply the changes, relevant only for Ubuntu Core")
+	opts.bootloader = flags.String("bootloader",
+		"",
+		"Bootloader to use instead of the detected one, one of "+
+			strings.Join(system.SystemNames(), ", "))
+	opts.root = flags.String("root",
+		"",
+		"Root filesystem to configure instead of the running system, e.g. a mounted image")
+	opts.cpus = flags.Int("cpus",
+		0,
+		"Number of CPUs to validate the CPU lists for, instead of the ones of the running system")
+	opts.arch = flags.String("arch",
+		"",
+		"Architecture to validate the kernel command line for, one of "+
+			strings.Join(model.SupportedArchs(), ", ")+" (default: the running one)")
 
-	if err := flags.Parse(args[1:]); err != nil {
+	return flags, opts, nil
+}
+
+// parse parses the command line flags and sets up logging accordingly
+func (o *options) parse(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
 		return fmt.Errorf("failed to parse flags: %v", err)
 	}
 
 	log.SetFlags(0)
 
-	if *verbose {
+	if *o.bootloader != "" {
+		if _, err := system.ParseSystemType(*o.bootloader); err != nil {
+			return err
+		}
+	}
+
+	if *o.cpus != 0 {
+		if err := cpulists.SetTotalCPUs(*o.cpus); err != nil {
+			return err
+		}
+	}
+	if *o.root != "" {
+		kcmd.SetRoot(*o.root)
+		system.SetRoot(*o.root)
+		if *o.cpus == 0 {
+			log.Println("Warning: --cpus is not set, the CPU lists are validated for the CPUs of this system")
+		}
+	}
+
+	if *o.arch != "" {
+		if err := model.SetTargetArch(*o.arch); err != nil {
+			return err
+		}
+	}
+
+	if *o.verbose {
 		fmt.Println("Verbose mode enabled")
 		debug.Enable()
 	}
 
-	if *configPath == "" {
-		flag.PrintDefaults()
-		return fmt.Errorf("failed to load config file: path not set")
+	if *o.dryRun {
+		fmt.Println("Dry-run mode enabled, no changes will be applied")
 	}
+	return nil
+}
 
-	var conf model.InternalConfig
+// internalConfig returns the configuration set by the command line flags
+func (o *options) internalConfig() model.InternalConfig {
+	return model.InternalConfig{
+		GrubCfg: model.Grub{
+			GrubDropInFile: filepath.Join(*o.root, *o.grubCfgPath),
+		},
+		UbootCfg: model.Uboot{
+			ExtlinuxFile: filepath.Join(*o.root, *o.extlinuxPath),
+		},
+		SystemdBootCfg: model.SystemdBoot{
+			KernelCmdlineFile: filepath.Join(*o.root, *o.kernelCmdlinePath),
+		},
+		DryRun:       *o.dryRun,
+		SnapdTimeout: *o.snapdTimeout,
+		Bootloader:   *o.bootloader,
+	}
+}
 
-	if err := conf.Data.LoadFromFile(*configPath); err != nil {
-		return fmt.Errorf("failed to load config file: %w", err)
+// loadConfig returns the configuration set by the configuration file and
+// the command line flags
+func (o *options) loadConfig(flags *flag.FlagSet) (model.InternalConfig, error) {
+	if *o.configPath == "" {
+		flags.PrintDefaults()
+		return model.InternalConfig{}, fmt.Errorf("failed to load config file: path not set")
+	}
+
+	conf := o.internalConfig()
+
+	if err := conf.Data.LoadFromFile(*o.configPath); err != nil {
+		return model.InternalConfig{}, fmt.Errorf("failed to load config file: %w", err)
 	}
 
 	// If running as a snap, override config with snap options
 	if env.Snap() != "" {
 		if err := conf.Data.LoadSnapOptions(); err != nil {
-			return fmt.Errorf("failed to load config from snap options: %v", err)
+			return model.InternalConfig{}, fmt.Errorf("failed to load config from snap options: %v", err)
 		}
 	}
+	return conf, nil
+}
+
+func runApply(args []string) error {
+	flags, opts, err := newFlagSet(args[0])
+	if err != nil {
+		return err
+	}
+	tryNextBoot := flags.Bool("try-next-boot",
+		false,
+		"Use the kernel command line for the next boot only, until 'rt-conf commit', relevant only for GRUB bootloader")
+	replay := flags.String("replay",
+		"",
+		"Capture to apply the configuration to instead of the running system, see 'rt-conf capture'")
 
-	conf.GrubCfg = model.Grub{
-		GrubDropInFile: *grubCfgPath,
+	if err := opts.parse(flags, args[1:]); err != nil {
+		return err
 	}
 
+	if *replay != "" {
+		if *opts.root != "" || *tryNextBoot {
+			return fmt.Errorf("--replay can't be used with --root or --try-next-boot")
+		}
+		return runReplay(flags, opts, *replay)
+	}
+
+	conf, err := opts.loadConfig(flags)
+	if err != nil {
+		return err
+	}
+	conf.TryNextBoot = *tryNextBoot
+
 	if msgs, err := kcmd.ProcessKcmdArgs(&conf); err != nil {
 		return fmt.Errorf("failed to process kernel cmdline args: %v", err)
 	} else {
@@ -85,6 +242,12 @@ func run(args []string) error {
 		}
 	}
 
+	if *opts.root != "" {
+		log.Println("IRQ tuning and CPU governance only apply to the running system, " +
+			"skipped with --root")
+		return nil
+	}
+
 	if err := irq.ApplyIRQConfig(&conf); err != nil {
 		return fmt.Errorf("failed to process interrupts: %v", err)
 	}
@@ -95,3 +258,228 @@ func run(args []string) error {
 
 	return nil
 }
+
+// runReplay applies the configuration to a copy of a capture made by
+// 'rt-conf capture', and reports the changes. The kernel command line is
+// compared with the captured one, the bootloader is left untouched.
+func runReplay(flags *flag.FlagSet, opts *options, capturePath string) error {
+	f, err := os.Open(capturePath)
+	if err != nil {
+		return fmt.Errorf("failed to open the capture: %v", err)
+	}
+	defer f.Close()
+	snapshot, err := sysfs.ReadTar(f)
+	if err != nil {
+		return err
+	}
+
+	overlay, err := os.MkdirTemp("", "rt-conf-replay-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(overlay)
+	if err := snapshot.Extract(overlay); err != nil {
+		return fmt.Errorf("failed to extract the capture: %v", err)
+	}
+	sysfs.SetRoot(overlay)
+	defer sysfs.SetRoot("")
+
+	// The CPU lists and IRQs are validated against the captured system
+	conf, err := opts.loadConfig(flags)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range kcmd.ReplayStatus(&conf, string(snapshot["/proc/cmdline"])) {
+		fmt.Print(msg)
+	}
+
+	if err := irq.ApplyIRQConfig(&conf); err != nil {
+		return fmt.Errorf("failed to process interrupts: %v", err)
+	}
+
+	if err := pwrmgmt.ApplyPwrConfig(&conf); err != nil {
+		return fmt.Errorf("failed to process power management config: %v", err)
+	}
+
+	changes, err := snapshot.Diff(sysfs.Root)
+	if err != nil {
+		return fmt.Errorf("failed to compare with the capture: %v", err)
+	}
+	fmt.Printf("Changes to %s:\n", capturePath)
+	if len(changes) == 0 {
+		fmt.Println("\tnone")
+	}
+	for _, c := range changes {
+		fmt.Printf("\t%s: %q -> %q\n", c.Path, c.Old, c.New)
+	}
+
+	return nil
+}
+
+// runCapture snapshots the files of /proc and /sys read by rt-conf, to be
+// replayed with 'rt-conf apply --replay'.
+func runCapture(args []string) error {
+	flags, opts, err := newFlagSet(args[0])
+	if err != nil {
+		return err
+	}
+	output := flags.String("o",
+		"",
+		"Path to the output tar archive")
+
+	if err := opts.parse(flags, args[1:]); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		flags.PrintDefaults()
+		return fmt.Errorf("no output set: set -o")
+	}
+	if *opts.root != "" {
+		return fmt.Errorf("capture reads the running system, it can't be used with --root")
+	}
+
+	snapshot, err := sysfs.Capture(sysfs.Root)
+	if err != nil {
+		return fmt.Errorf("failed to capture the system: %v", err)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("failed to create the capture: %v", err)
+	}
+	if err := snapshot.WriteTar(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write the capture: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write the capture: %v", err)
+	}
+	fmt.Printf("Captured %d files to %s\n", len(snapshot), *output)
+
+	return nil
+}
+
+func runReset(args []string) error {
+	flags, opts, err := newFlagSet(args[0])
+	if err != nil {
+		return err
+	}
+	kernelCmdline := flags.Bool("kernel-cmdline",
+		false,
+		"Remove the kernel command line parameters set by rt-conf from the bootloader configuration")
+
+	if err := opts.parse(flags, args[1:]); err != nil {
+		return err
+	}
+
+	if !*kernelCmdline {
+		flags.PrintDefaults()
+		return fmt.Errorf("nothing to reset: set --kernel-cmdline")
+	}
+
+	conf := opts.internalConfig()
+
+	msgs, err := kcmd.ResetKcmdArgs(&conf)
+	if err != nil {
+		return fmt.Errorf("failed to reset kernel cmdline args: %v", err)
+	}
+	for _, msg := range msgs {
+		fmt.Print(msg)
+	}
+
+	return nil
+}
+
+// runCommit makes the kernel command line tried with 'apply --try-next-boot'
+// permanent, once the running kernel uses it.
+func runCommit(args []string) error {
+	flags, opts, err := newFlagSet(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := opts.parse(flags, args[1:]); err != nil {
+		return err
+	}
+
+	if *opts.root != "" {
+		return fmt.Errorf("commit checks the running kernel, it can't be used with --root")
+	}
+
+	conf := opts.internalConfig()
+
+	msgs, err := kcmd.CommitKcmdArgs(&conf)
+	if err != nil {
+		return fmt.Errorf("failed to commit kernel cmdline args: %v", err)
+	}
+	for _, msg := range msgs {
+		fmt.Print(msg)
+	}
+
+	return nil
+}
+
+// runStatus reports whether the kernel command line parameters of the
+// configuration are active on the running kernel.
+func runStatus(args []string) error {
+	flags, opts, err := newFlagSet(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := opts.parse(flags, args[1:]); err != nil {
+		return err
+	}
+
+	if *opts.root != "" {
+		return fmt.Errorf("status checks the running kernel, it can't be used with --root")
+	}
+
+	conf, err := opts.loadConfig(flags)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := kcmd.KcmdStatus(&conf)
+	if err != nil {
+		return fmt.Errorf("failed to get kernel cmdline status: %v", err)
+	}
+	for _, msg := range msgs {
+		fmt.Print(msg)
+	}
+
+	return nil
+}
+
+// runDetect reports the bootloaders found on the system, and the one used
+// by rt-conf.
+func runDetect(args []string) error {
+	flags, opts, err := newFlagSet(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := opts.parse(flags, args[1:]); err != nil {
+		return err
+	}
+
+	conf := opts.internalConfig()
+	// The configuration file is optional, it may set the bootloader
+	if *opts.configPath != "" {
+		if conf, err = opts.loadConfig(flags); err != nil {
+			return err
+		}
+	}
+
+	msgs, err := kcmd.DetectBootloader(&conf)
+	if err != nil {
+		return fmt.Errorf("failed to detect the bootloader: %v", err)
+	}
+	for _, msg := range msgs {
+		fmt.Print(msg)
+	}
+
+	return nil
+}
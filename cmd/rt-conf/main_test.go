@@ -5,6 +5,10 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/canonical/rt-conf/src/kcmd"
+	"github.com/canonical/rt-conf/src/sysfs"
+	"github.com/canonical/rt-conf/src/system"
 )
 
 func TestRunHappy(t *testing.T) {
@@ -25,6 +29,24 @@ func TestRunHappy(t *testing.T) {
 kernel-cmdline:
 cpu-governance:
 irq-tuning:
+`,
+		},
+		{
+			name: "Dry-run with empty config",
+			args: []string{"rt-conf", "--dry-run", "-file", configPath},
+			yaml: `
+kernel-cmdline:
+cpu-governance:
+irq-tuning:
+`,
+		},
+		{
+			name: "Explicit apply command",
+			args: []string{"rt-conf", "apply", "-file", configPath},
+			yaml: `
+kernel-cmdline:
+cpu-governance:
+irq-tuning:
 `,
 		},
 	}
@@ -71,6 +93,39 @@ func TestRunUnhappy(t *testing.T) {
 kernel-cmdline:
 cpu-governance:
 irq-tuning:
+`,
+		},
+		{
+			name: "Unknown command",
+			args: []string{"rt-conf", "foo"},
+			err:  "unknown command",
+		},
+		{
+			name: "Reset without target",
+			args: []string{"rt-conf", "reset"},
+			err:  "nothing to reset",
+		},
+		{
+			name: "Unsupported architecture",
+			args: []string{"rt-conf", "--arch", "mips", "-file", configPath},
+			err:  `unsupported architecture "mips"`,
+		},
+		{
+			name: "Unknown bootloader",
+			args: []string{"rt-conf", "detect", "--bootloader", "lilo"},
+			err:  `unknown bootloader "lilo"`,
+		},
+		{
+			name: "Commit without a try",
+			args: []string{"rt-conf", "commit", "--grub-custom-file", filepath.Join(tmpdir, "60_rt-conf.cfg")},
+			err:  "failed to commit kernel cmdline args",
+		},
+		{
+			name: "Status without kernel cmdline parameters",
+			args: []string{"rt-conf", "status", "-file", configPath},
+			err:  "no kernel command line parameters in the configuration",
+			yaml: `
+irq-tuning:
 `,
 		},
 		{
@@ -168,3 +223,111 @@ cpu-governance:
 		})
 	}
 }
+
+func TestRunOffline(t *testing.T) {
+	t.Cleanup(func() {
+		kcmd.SetRoot("")
+		system.SetRoot("")
+	})
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc", "default", "grub.d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "etc", "default", "grub"),
+		[]byte("GRUB_CMDLINE_LINUX_DEFAULT=\"quiet splash\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(`
+kernel-cmdline:
+  parameters:
+    - isolcpus=2-3
+irq-tuning:
+  "foo":
+    cpus: "0"
+    filter:
+      actions: "xxxxxx"
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"rt-conf", "--root", root, "--cpus", "4", "-file", configPath}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	dropIn, err := os.ReadFile(filepath.Join(root, "etc", "default", "grub.d", "60_rt-conf.cfg"))
+	if err != nil {
+		t.Fatalf("expected the drop-in in the root filesystem: %v", err)
+	}
+	if !strings.Contains(string(dropIn), "isolcpus=2-3") {
+		t.Errorf("expected the parameters in the drop-in, got:\n%s", dropIn)
+	}
+
+	err = run([]string{"rt-conf", "status", "--root", root, "-file", configPath})
+	if err == nil || !strings.Contains(err.Error(), "can't be used with --root") {
+		t.Errorf("expected status to be rejected, got %v", err)
+	}
+}
+
+func TestRunReplay(t *testing.T) {
+	t.Cleanup(func() { sysfs.SetRoot("") })
+
+	// A captured system with 4 CPUs and an IRQ of eth0 handled by all of them
+	root := t.TempDir()
+	files := map[string]string{
+		"proc/cmdline":                   "quiet\n",
+		"proc/irq/7/smp_affinity_list":   "0-3\n",
+		"sys/kernel/irq/7/actions":       "eth0\n",
+		"sys/devices/system/cpu/present": "0-3\n",
+	}
+	for p, content := range files {
+		file := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	capturePath := filepath.Join(t.TempDir(), "box.tar")
+	sysfs.SetRoot(root)
+	if err := run([]string{"rt-conf", "capture", "-o", capturePath}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	sysfs.SetRoot("")
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(`
+kernel-cmdline:
+  parameters:
+    - isolcpus=2-3
+irq-tuning:
+  "eth0":
+    cpus: "3"
+    filter:
+      actions: "eth0"
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"rt-conf", "--replay", capturePath, "-file", configPath}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// The capture and the running system are left untouched
+	content, err := os.ReadFile(filepath.Join(root, "proc/irq/7/smp_affinity_list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "0-3\n" {
+		t.Errorf("expected the captured root untouched, got %q", content)
+	}
+	if sysfs.Root != sysfs.Dir("/") {
+		t.Errorf("expected the root of the running system, got %v", sysfs.Root)
+	}
+
+	err = run([]string{"rt-conf", "--replay", capturePath, "--try-next-boot", "-file", configPath})
+	if err == nil || !strings.Contains(err.Error(), "--replay can't be used") {
+		t.Errorf("expected --try-next-boot to be rejected, got %v", err)
+	}
+}
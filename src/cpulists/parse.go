@@ -19,7 +19,7 @@ type CPUs map[int]bool
 // Parse parses a CPU Lists string into CPUs map
 // It performs parsing based on the total number of available CPUs
 func Parse(cpuLists string) (CPUs, error) {
-	total, err := totalCPUs()
+	total, err := TotalCPUs()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get total available CPUs: %v", err)
 	}
@@ -71,50 +71,61 @@ func ParseForCPUs(cpuLists string, totalCPUs int) (CPUs, error) {
 	return cpus, nil
 }
 
-// ParseWithFlagsForCPUs parses a CPU Lists string into CPUs map
-func ParseWithFlagsForCPUs(cpuLists string, validFlags []string, totalCPUs int) (CPUs, string, error) {
-	hasFlag := true
-	// Split the string into two parts by the first comma
-	parts := strings.SplitN(cpuLists, ",", 2)
+// Flags is the set of flags preceding a CPU list, e.g. nohz and domain in
+// isolcpus=nohz,domain,2-3
+type Flags map[string]bool
 
-	// If it converts to a number, it's not a flag
-	_, err := strconv.Atoi(parts[0])
+// ParseWithFlagsForCPUs parses a CPU Lists string, optionally preceded by
+// any combination of validFlags, into CPUs map and Flags
+func ParseWithFlagsForCPUs(cpuLists string, validFlags []string, totalCPUs int) (CPUs, Flags, error) {
+	items := strings.Split(utils.TrimSurroundingQuotes(cpuLists), ",")
 
-	// Check if the first part isn't a flag
-	if len(parts) != 2 ||
-		err == nil ||
-		strings.Contains(parts[0], "-") ||
-		strings.Contains(parts[0], ":") ||
-		strings.Contains(parts[0], "/") {
-		hasFlag = false
-	}
-
-	// If it's a flag, check if it's a valid flag
-	if hasFlag {
-		if !slices.Contains(validFlags, parts[0]) {
-			return nil, "", fmt.Errorf("invalid flag: %s, expected one of %v", parts[0], validFlags)
+	flags := make(Flags)
+	n := 0
+	for ; n < len(items) && isFlag(items[n]); n++ {
+		flag := strings.TrimSpace(items[n])
+		if !slices.Contains(validFlags, flag) {
+			return nil, nil, fmt.Errorf("invalid flag: %s, expected one of %v", flag, validFlags)
+		}
+		if flags[flag] {
+			return nil, nil, fmt.Errorf("duplicate flag: %s", flag)
 		}
+		flags[flag] = true
+	}
 
-		cpus, err := ParseForCPUs(parts[1], totalCPUs)
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to parse CPUs: %s", err)
+	if n == len(items) {
+		return nil, nil, fmt.Errorf("missing CPU list after flags %s", strings.Join(items, ","))
+	}
+	for _, item := range items[n:] {
+		if isFlag(item) {
+			return nil, nil, fmt.Errorf("flag %s must precede the CPU list", strings.TrimSpace(item))
 		}
-		return cpus, parts[0], nil
 	}
 
-	cpus, err := ParseForCPUs(cpuLists, totalCPUs)
+	cpus, err := ParseForCPUs(strings.Join(items[n:], ","), totalCPUs)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to parse CPUs: %s", err)
+		return nil, nil, fmt.Errorf("failed to parse CPUs: %s", err)
 	}
-	return cpus, "", nil
+	return cpus, flags, nil
 }
 
-// ParseWithFlagsForCPUs parses a CPU Lists string into CPUs map
+// isFlag reports whether a CPU list item is a flag rather than CPUs.
+// CPU items start with a digit, N or "all".
+func isFlag(item string) bool {
+	item = strings.TrimSpace(item)
+	if item == "" || item == "all" {
+		return false
+	}
+	c := item[0]
+	return c >= 'a' && c <= 'z'
+}
+
+// ParseWithFlags parses a CPU Lists string with flags into CPUs map and Flags
 // It performs parsing based on the total number of available CPUs
-func ParseWithFlags(cpuLists string, validFlags []string) (CPUs, string, error) {
-	totalCPUs, err := totalCPUs()
+func ParseWithFlags(cpuLists string, validFlags []string) (CPUs, Flags, error) {
+	totalCPUs, err := TotalCPUs()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to get total available CPUs: %v", err)
+		return nil, nil, fmt.Errorf("failed to get total available CPUs: %v", err)
 	}
 
 	return ParseWithFlagsForCPUs(cpuLists, validFlags, totalCPUs)
@@ -244,6 +255,37 @@ func GenCPUlist(cpus []int) string {
 	return strings.Join(parts, ",")
 }
 
+// GenCPUMask generates the hexadecimal CPU mask of the kernel for the CPUs,
+// in 32 bits words separated by commas, e.g. "ff,00000003" for 0-1,32-39.
+func GenCPUMask(cpus []int) string {
+	if len(cpus) == 0 {
+		return "0"
+	}
+	list := deduplicateCPUs(cpus)
+
+	words := make([]uint32, list[len(list)-1]/32+1)
+	for _, cpu := range list {
+		words[cpu/32] |= 1 << (cpu % 32)
+	}
+	parts := []string{fmt.Sprintf("%x", words[len(words)-1])}
+	for i := len(words) - 2; i >= 0; i-- {
+		parts = append(parts, fmt.Sprintf("%08x", words[i]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// List returns the CPUs, sorted.
+func (c CPUs) List() []int {
+	var list []int
+	for cpu, ok := range c {
+		if ok {
+			list = append(list, cpu)
+		}
+	}
+	sort.Ints(list)
+	return list
+}
+
 func deduplicateCPUs(cpus []int) (cpulist []int) {
 	cpuMap := make(CPUs)
 	for _, cpu := range cpus {
@@ -3,6 +3,7 @@ package cpulists
 import (
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -287,6 +288,11 @@ func TestParseWithFlagsHappy(t *testing.T) {
 		{"nohz,0,N", maxCpus, isolcpuFlags},
 		{"domain,0,N", maxCpus, isolcpuFlags},
 		{"managed_irq,0,N", maxCpus, isolcpuFlags},
+
+		// Test multiple flags
+		{"nohz,domain,0-1", maxCpus, isolcpuFlags},
+		{"managed_irq,nohz,domain,0,N", maxCpus, isolcpuFlags},
+		{"domain,managed_irq,all", maxCpus, isolcpuFlags},
 	}
 
 	// If not set totalCPUs back to the original function, the next tests will fail.
@@ -321,6 +327,54 @@ func TestParseWithFlagsHappy(t *testing.T) {
 	})
 }
 
+func TestParseWithFlagsForCPUs(t *testing.T) {
+	isolcpuFlags := []string{"domain", "nohz", "managed_irq"}
+	testCases := []struct {
+		value string
+		cpus  CPUs
+		flags Flags
+		err   string
+	}{
+		{value: "2-3", cpus: CPUs{2: true, 3: true}, flags: Flags{}},
+		{
+			value: "nohz,domain,managed_irq,2-3",
+			cpus:  CPUs{2: true, 3: true},
+			flags: Flags{"nohz": true, "domain": true, "managed_irq": true},
+		},
+		{
+			value: "managed_irq,nohz,1,3",
+			cpus:  CPUs{1: true, 3: true},
+			flags: Flags{"nohz": true, "managed_irq": true},
+		},
+		{value: "nohz,foo,2-3", err: "invalid flag: foo, expected one of [domain nohz managed_irq]"},
+		{value: "nohz,domain,nohz,2-3", err: "duplicate flag: nohz"},
+		{value: "nohz,domain", err: "missing CPU list after flags nohz,domain"},
+		{value: "2-3,nohz", err: "flag nohz must precede the CPU list"},
+		{value: "nohz,2-a", err: "failed to parse CPUs"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			cpus, flags, err := ParseWithFlagsForCPUs(tc.value, isolcpuFlags, 8)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cpus, tc.cpus) {
+				t.Errorf("Expected CPUs %v, got %v", tc.cpus, cpus)
+			}
+			if !reflect.DeepEqual(flags, tc.flags) {
+				t.Errorf("Expected flags %v, got %v", tc.flags, flags)
+			}
+		})
+	}
+}
+
 func TestGenCPUlist(t *testing.T) {
 	testCases := []struct {
 		name   string
@@ -388,3 +442,46 @@ func TestGenCPUlist(t *testing.T) {
 		})
 	}
 }
+
+func TestGenCPUMask(t *testing.T) {
+	testCases := []struct {
+		name   string
+		cpus   []int
+		result string
+	}{
+		{
+			name:   "TestEmptyCPUs",
+			cpus:   []int{},
+			result: "0",
+		},
+		{
+			name:   "TestHousekeepingCPUs",
+			cpus:   []int{0, 1},
+			result: "3",
+		},
+		{
+			name:   "TestUnsortedCPUs",
+			cpus:   []int{7, 4, 0},
+			result: "91",
+		},
+		{
+			name:   "TestCPUsOver32",
+			cpus:   []int{0, 1, 32, 33, 34, 35, 36, 37, 38, 39},
+			result: "ff,00000003",
+		},
+		{
+			name:   "TestLastCPUOfWord",
+			cpus:   []int{31, 64},
+			result: "1,00000000,80000000",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := GenCPUMask(tc.cpus)
+			if result != tc.result {
+				t.Errorf("Expected %s, got %s", tc.result, result)
+			}
+		})
+	}
+}
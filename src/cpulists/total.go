@@ -1,43 +1,63 @@
 package cpulists
 
 import (
-	"encoding/json"
 	"fmt"
-	"os/exec"
 	"strconv"
 	"strings"
+
+	"github.com/canonical/rt-conf/src/sysfs"
 )
 
-var execCommand = func(name string, arg ...string) ([]byte, error) {
-	return exec.Command(name, arg...).Output()
-}
+// cpuPresent lists the CPUs of the system, read through sysfs.Root
+// See: https://docs.kernel.org/admin-guide/cputopology.html
+const cpuPresent = "/sys/devices/system/cpu/present"
 
+// totalCPUs returns the number of CPUs of the system, as the highest CPU
+// number plus one, since CPU lists are validated by CPU number.
 var totalCPUs = func() (int, error) {
-	output, err := execCommand("lscpu", "--json")
+	content, err := sysfs.Root.ReadFile(cpuPresent)
 	if err != nil {
-		return 0, err
-	}
-
-	var result struct {
-		CPUs []struct {
-			Field    string `json:"field"`
-			Data     string `json:"data"`
-			Children []any  `json:"children"`
-		} `json:"lscpu"`
-	}
-	if err := json.Unmarshal(output, &result); err != nil {
-		return 0, err
+		return 0, fmt.Errorf("failed to read %s: %v", cpuPresent, err)
 	}
 
-	for _, cpu := range result.CPUs {
-		if strings.TrimSpace(cpu.Field) == "CPU(s):" {
-			totalCPUs, err := strconv.Atoi(cpu.Data)
-			if err != nil {
-				return 0, err
+	// The list is sorted, e.g. 0-3,6-7
+	list := strings.TrimSpace(string(content))
+	last := -1
+	for _, item := range strings.Split(list, ",") {
+		first, end, isRange := strings.Cut(item, "-")
+		a, err := strconv.Atoi(first)
+		if err != nil || a <= last {
+			return 0, fmt.Errorf("invalid CPU list %q in %s", list, cpuPresent)
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(end); err != nil || b < a {
+				return 0, fmt.Errorf("invalid CPU list %q in %s", list, cpuPresent)
 			}
-			return totalCPUs, nil
 		}
+		last = b
 	}
+	return last + 1, nil
+}
+
+// cpuCount overrides the number of CPUs of the system when set
+var cpuCount int
+
+// SetTotalCPUs sets the number of CPUs the CPU lists are validated for,
+// instead of the ones of the running system, e.g. for another system.
+func SetTotalCPUs(n int) error {
+	if n < 1 {
+		return fmt.Errorf("invalid number of CPUs: %d", n)
+	}
+	cpuCount = n
+	return nil
+}
 
-	return 0, fmt.Errorf("could not find total CPUs")
+// TotalCPUs returns the total number of CPUs of the system, or the one set
+// by SetTotalCPUs
+func TotalCPUs() (int, error) {
+	if cpuCount > 0 {
+		return cpuCount, nil
+	}
+	return totalCPUs()
 }
@@ -1,9 +1,12 @@
 package cpulists
 
 import (
-	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/canonical/rt-conf/src/sysfs"
 )
 
 func TestTotalCPUs(t *testing.T) {
@@ -16,60 +19,136 @@ func TestTotalCPUs(t *testing.T) {
 	}
 }
 
-func TestTotalCPUsUnhappy(t *testing.T) {
-	type testCase struct {
-		name       string
-		mockOutput []byte
-		mockErr    error
-		expectErr  string
+func TestTotalCPUsSysfsRoot(t *testing.T) {
+	testCases := []struct {
+		name     string
+		present  string
+		expected int
+	}{
+		{
+			name:     "single CPU",
+			present:  "0\n",
+			expected: 1,
+		},
+		{
+			name:     "range",
+			present:  "0-7\n",
+			expected: 8,
+		},
+		{
+			name:     "offline CPUs in between",
+			present:  "0-3,6-7\n",
+			expected: 8,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			writePresent(t, tc.present, false)
+
+			c, err := totalCPUs()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c != tc.expected {
+				t.Fatalf("expected %d CPUs, got %d", tc.expected, c)
+			}
+		})
 	}
+}
 
-	testCases := []testCase{
+// writePresent points sysfs.Root to a temporary root, holding the present
+// CPU list given unless missing.
+func writePresent(t *testing.T, present string, missing bool) {
+	t.Helper()
+	root := t.TempDir()
+	sysfs.SetRoot(root)
+	t.Cleanup(func() { sysfs.SetRoot("") })
+	if missing {
+		return
+	}
+	path := filepath.Join(root, cpuPresent)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(present), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTotalCPUsUnhappy(t *testing.T) {
+	testCases := []struct {
+		name      string
+		present   string
+		missing   bool
+		expectErr string
+	}{
+		{
+			name:      "missing file",
+			missing:   true,
+			expectErr: "failed to read /sys/devices/system/cpu/present",
+		},
 		{
-			name:       "command error",
-			mockOutput: nil,
-			mockErr:    errors.New("fake command error"),
-			expectErr:  "fake command error",
+			name:      "empty file",
+			present:   "",
+			expectErr: "invalid CPU list",
 		},
 		{
-			name:       "invalid json output",
-			mockOutput: []byte("not-json"),
-			expectErr:  "invalid character",
+			name:      "malformed range",
+			present:   "0-a\n",
+			expectErr: "invalid CPU list",
 		},
 		{
-			name: "invalid CPU number",
-			mockOutput: []byte(`{
-				"lscpu": [
-					{ "field": "CPU(s):", "data": "not-an-int", "children": [] }
-				]
-			}`),
-			expectErr: "invalid syntax",
+			name:      "unterminated range",
+			present:   "0-\n",
+			expectErr: "invalid CPU list",
 		},
 		{
-			name: "missing CPU field",
-			mockOutput: []byte(`{
-				"lscpu": [
-					{ "field": "Model name:", "data": "Intel Xeon", "children": [] }
-				]
-			}`),
-			expectErr: "could not find total CPUs",
+			name:      "malformed start of range",
+			present:   "x-3\n",
+			expectErr: "invalid CPU list",
+		},
+		{
+			name:      "unsorted",
+			present:   "4-7,0-3\n",
+			expectErr: "invalid CPU list",
+		},
+		{
+			name:      "not a CPU list",
+			present:   "none\n",
+			expectErr: "invalid CPU list",
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			// override the execCommand used by totalCPUs
-			execCommand = func(_ string, _ ...string) ([]byte, error) {
-				return tc.mockOutput, tc.mockErr
-			}
+			writePresent(t, tc.present, tc.missing)
 
 			_, err := totalCPUs()
-			if err == nil {
-				t.Fatalf("expected error, got nil")
-			}
-			if !strings.Contains(err.Error(), tc.expectErr) {
+			if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
 				t.Fatalf("expected error containing %q, got: %v", tc.expectErr, err)
 			}
 		})
 	}
 }
+
+func TestSetTotalCPUs(t *testing.T) {
+	t.Cleanup(func() { cpuCount = 0 })
+
+	if err := SetTotalCPUs(0); err == nil {
+		t.Fatal("expected error for 0 CPUs, got nil")
+	}
+	if err := SetTotalCPUs(64); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c, err := TotalCPUs(); err != nil || c != 64 {
+		t.Fatalf("expected 64 CPUs, got %d, %v", c, err)
+	}
+	// CPU lists are validated for the CPUs set, not the ones of this system
+	if _, err := Parse("60-63"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := Parse("64"); err == nil {
+		t.Fatal("expected error for CPU 64, got nil")
+	}
+}
@@ -0,0 +1,149 @@
+package irq
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/model"
+	"github.com/canonical/rt-conf/src/sysfs"
+)
+
+// The devices whose IRQs can be filtered
+const (
+	pciDevices    = "/sys/bus/pci/devices"
+	netInterfaces = "/sys/class/net"
+)
+
+// deviceIRQs returns the IRQs of the device at dir: its MSI and MSI-X IRQs,
+// or its legacy IRQ when it has none.
+func deviceIRQs(dir string) (IRQs, error) {
+	irqs := make(IRQs)
+	entries, err := sysfs.Root.ReadDir(path.Join(dir, "msi_irqs"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read the MSI IRQs of %s: %v", dir, err)
+	}
+	for _, entry := range entries {
+		if num, err := strconv.Atoi(entry.Name()); err == nil {
+			irqs[num] = true
+		}
+	}
+	if len(irqs) > 0 {
+		return irqs, nil
+	}
+
+	content, err := sysfs.Root.ReadFile(path.Join(dir, "irq"))
+	if errors.Is(err, os.ErrNotExist) {
+		return irqs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the IRQ of %s: %v", dir, err)
+	}
+	// IRQ 0 means the device has no legacy IRQ
+	if num, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil && num > 0 {
+		irqs[num] = true
+	}
+	return irqs, nil
+}
+
+// pciDriver returns the driver bound to the PCI device at dir, if any.
+func pciDriver(dir string) (string, error) {
+	content, err := sysfs.Root.ReadFile(path.Join(dir, "uevent"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the driver of %s: %v", dir, err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if driver, ok := strings.CutPrefix(line, "DRIVER="); ok {
+			return driver, nil
+		}
+	}
+	return "", nil
+}
+
+// pciIRQs returns the IRQs of the PCI devices matching the address (BDF)
+// and driver patterns.
+func pciIRQs(device, driver string) (IRQs, error) {
+	entries, err := sysfs.Root.ReadDir(pciDevices)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read the PCI devices: %v", err)
+	}
+	irqs := make(IRQs)
+	for _, entry := range entries {
+		if !matchesRegex(entry.Name(), device) {
+			continue
+		}
+		dir := path.Join(pciDevices, entry.Name())
+		if driver != "" {
+			name, err := pciDriver(dir)
+			if err != nil {
+				return nil, err
+			}
+			if name == "" || !matchesRegex(name, driver) {
+				continue
+			}
+		}
+		devIRQs, err := deviceIRQs(dir)
+		if err != nil {
+			return nil, err
+		}
+		for num := range devIRQs {
+			irqs[num] = true
+		}
+	}
+	return irqs, nil
+}
+
+// netIRQs returns the IRQs of the devices of the network interfaces
+// matching the pattern. Virtual interfaces have no device, nor IRQs.
+func netIRQs(iface string) (IRQs, error) {
+	entries, err := sysfs.Root.ReadDir(netInterfaces)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read the network interfaces: %v", err)
+	}
+	irqs := make(IRQs)
+	for _, entry := range entries {
+		if !matchesRegex(entry.Name(), iface) {
+			continue
+		}
+		devIRQs, err := deviceIRQs(path.Join(netInterfaces, entry.Name(), "device"))
+		if err != nil {
+			return nil, err
+		}
+		for num := range devIRQs {
+			irqs[num] = true
+		}
+	}
+	return irqs, nil
+}
+
+// filterDeviceIRQs returns the IRQs of the devices matching the filter,
+// or nil when it doesn't filter by device.
+func filterDeviceIRQs(filter model.IRQFilter) (IRQs, error) {
+	var irqs IRQs
+	if filter.PCIDevice != "" || filter.PCIDriver != "" {
+		pci, err := pciIRQs(filter.PCIDevice, filter.PCIDriver)
+		if err != nil {
+			return nil, err
+		}
+		irqs = pci
+	}
+	if filter.NetInterface != "" {
+		net, err := netIRQs(filter.NetInterface)
+		if err != nil {
+			return nil, err
+		}
+		if irqs == nil {
+			return net, nil
+		}
+		// Both filters apply
+		for num := range irqs {
+			if !net[num] {
+				delete(irqs, num)
+			}
+		}
+	}
+	return irqs, nil
+}
@@ -4,6 +4,8 @@ package irq
 type IRQReaderWriter interface {
 	ReadIRQs() ([]IRQInfo, error)
 	WriteCPUAffinity(irqNum int, cpus string) (success bool, managedIRQ bool, err error)
+	// WriteDefaultAffinity sets the CPU mask of the IRQs registered later
+	WriteDefaultAffinity(mask string) error
 }
 
 // IRQInfo represents information about an IRQ.
@@ -12,8 +14,10 @@ type IRQInfo struct {
 	Number   int
 	Actions  string
 	ChipName string
+	Hwirq    string
 	Name     string
 	Type     string
 	Wakeup   string
+	Affinity string // CPU list, empty when unknown
 	// PerCPuCount string // ** NOTE: Not needed for now
 }
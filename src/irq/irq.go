@@ -3,7 +3,6 @@ package irq
 import (
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -12,6 +11,7 @@ import (
 	"github.com/canonical/rt-conf/src/cpulists"
 	"github.com/canonical/rt-conf/src/debug"
 	"github.com/canonical/rt-conf/src/model"
+	"github.com/canonical/rt-conf/src/sysfs"
 	"github.com/canonical/rt-conf/src/utils"
 )
 
@@ -44,15 +44,6 @@ type IRQs map[int]bool // use the same logic as CPUs lists
 // realIRQReaderWriter writes CPU affinity to the real `/proc/irq/<irq>/smp_affinity_list` file.
 type realIRQReaderWriter struct{}
 
-var (
-	procIRQ      = model.ProcIRQ
-	sysKernelIRQ = model.SysKernelIRQ
-)
-
-var writeFile = func(path string, content []byte, perm os.FileMode) error {
-	return os.WriteFile(path, content, perm)
-}
-
 // Write IRQ affinity
 
 // returns:
@@ -60,8 +51,8 @@ var writeFile = func(path string, content []byte, perm os.FileMode) error {
 // - managedIRQ: true if the irqNum was a managed (read-only) IRQ false if not
 // - err: error if any occurred nil if no error occurred
 func (w *realIRQReaderWriter) WriteCPUAffinity(irqNum int, cpus string) (success bool, managedIRQ bool, err error) {
-	affinityFile := fmt.Sprintf("%s/%d/smp_affinity_list", procIRQ, irqNum)
-	err = writeFile(affinityFile, []byte(cpus), 0o644)
+	affinityFile := fmt.Sprintf("%s/%d/smp_affinity_list", model.ProcIRQ, irqNum)
+	err = sysfs.Root.WriteFile(affinityFile, []byte(cpus))
 	if err != nil {
 		if strings.Contains(err.Error(), "input/output error") {
 			return false, true, nil
@@ -73,11 +64,46 @@ func (w *realIRQReaderWriter) WriteCPUAffinity(irqNum int, cpus string) (success
 	return true, false, nil
 }
 
+// defaultAffinityFile is the CPU mask of the IRQs registered later
+var defaultAffinityFile = model.ProcIRQ + "/default_smp_affinity"
+
+func (w *realIRQReaderWriter) WriteDefaultAffinity(mask string) error {
+	if err := sysfs.Root.WriteFile(defaultAffinityFile, []byte(mask)); err != nil {
+		return fmt.Errorf("error writing to %s: %v", defaultAffinityFile, err)
+	}
+	return nil
+}
+
+// dryRunIRQReaderWriter reads the real IRQs but only reports the CPU affinity
+// it would write. Managed IRQs can't be detected since they only fail on write.
+type dryRunIRQReaderWriter struct {
+	realIRQReaderWriter
+}
+
+func (w *dryRunIRQReaderWriter) WriteCPUAffinity(irqNum int, cpus string) (success bool, managedIRQ bool, err error) {
+	affinityFile := fmt.Sprintf("%s/%d/smp_affinity_list", model.ProcIRQ, irqNum)
+	current, err := sysfs.Root.ReadFile(affinityFile)
+	if err != nil {
+		return false, false, fmt.Errorf("error reading %s: %v", affinityFile, err)
+	}
+	utils.LogPlannedWrite(affinityFile, strings.TrimSpace(string(current)), cpus)
+	return true, false, nil
+}
+
+func (w *dryRunIRQReaderWriter) WriteDefaultAffinity(mask string) error {
+	current, err := sysfs.Root.ReadFile(defaultAffinityFile)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", defaultAffinityFile, err)
+	}
+	utils.LogPlannedWrite(defaultAffinityFile, strings.TrimSpace(string(current)), mask)
+	return nil
+}
+
 func (r *realIRQReaderWriter) ReadIRQs() ([]IRQInfo, error) {
 	var irqInfos []IRQInfo
 
 	// Read the directories in /sys/kernel/irq
-	dirEntries, err := os.ReadDir(sysKernelIRQ)
+	dirEntries, err := sysfs.Root.ReadDir(model.SysKernelIRQ)
 	if err != nil {
 		return nil, err
 	}
@@ -96,13 +122,13 @@ func (r *realIRQReaderWriter) ReadIRQs() ([]IRQInfo, error) {
 
 			// Read files in the IRQ directory
 			files := []string{
-				"actions", "chip_name", "name", "type", "wakeup",
+				"actions", "chip_name", "hwirq", "name", "type", "wakeup",
 			}
 			for _, file := range files {
 				filePath := filepath.Join(
-					sysKernelIRQ, entry.Name(), file,
+					model.SysKernelIRQ, entry.Name(), file,
 				)
-				content, err := os.ReadFile(filePath)
+				content, err := sysfs.Root.ReadFile(filePath)
 				if err != nil {
 					// TODO: Log warning here
 					continue
@@ -120,6 +146,8 @@ func (r *realIRQReaderWriter) ReadIRQs() ([]IRQInfo, error) {
 					irqInfo.Actions = c
 				case "chip_name":
 					irqInfo.ChipName = c
+				case "hwirq":
+					irqInfo.Hwirq = c
 				case "name":
 					irqInfo.Name = c
 				case "type":
@@ -128,6 +156,10 @@ func (r *realIRQReaderWriter) ReadIRQs() ([]IRQInfo, error) {
 					irqInfo.Wakeup = c
 				}
 			}
+			affinityFile := fmt.Sprintf("%s/%d/smp_affinity_list", model.ProcIRQ, irqInfo.Number)
+			if content, err := sysfs.Root.ReadFile(affinityFile); err == nil {
+				irqInfo.Affinity = strings.TrimSpace(string(content))
+			}
 			// Only append active IRQs
 			if !nonActiveIRQ {
 				irqInfos = append(irqInfos, irqInfo)
@@ -139,11 +171,14 @@ func (r *realIRQReaderWriter) ReadIRQs() ([]IRQInfo, error) {
 
 func ApplyIRQConfig(config *model.InternalConfig) error {
 	utils.PrintTitle("IRQ Tuning")
-	if len(config.Data.Interrupts) == 0 {
+	if len(config.Data.Interrupts) == 0 && config.Data.IRQHousekeeping == (model.IRQs{}) {
 		// If no IRQ tuning is specified, skip the process
 		log.Println("No IRQ tuning rules found in config")
 		return nil
 	}
+	if config.DryRun {
+		return applyIRQConfig(config, &dryRunIRQReaderWriter{})
+	}
 	return applyIRQConfig(config, &realIRQReaderWriter{})
 }
 
@@ -161,8 +196,16 @@ func applyIRQConfig(
 		return fmt.Errorf("no IRQs found")
 	}
 
-	// Range over IRQ tuning array
-	for label, irqTuning := range config.Data.Interrupts {
+	if err := applyHousekeeping(config, irqs, handler); err != nil {
+		return err
+	}
+
+	// owners are the rules setting the affinity of each IRQ, the last one
+	// applied takes precedence
+	owners := make(map[int]string)
+	rules := config.Data.Interrupts.Names()
+	for _, label := range rules {
+		irqTuning := config.Data.Interrupts[label]
 		log.Printf("Rule: %s\n", label)
 
 		matchingIRQs, err := filterIRQs(irqs, irqTuning.Filter)
@@ -171,8 +214,14 @@ func applyIRQConfig(
 		}
 
 		if len(matchingIRQs) == 0 {
-			log.Println("WARN: no IRQs matched the filter")
-			// TODO: confirm if it should fail when nothing is matched
+			switch config.Data.OnNoMatch(irqTuning) {
+			case model.OnNoMatchWarn:
+				log.Printf("Warning: no IRQs matched the filter of rule %s, skipping it", label)
+				continue
+			case model.OnNoMatchIgnore:
+				debug.Printf("No IRQs matched the filter of rule %s, skipping it", label)
+				continue
+			}
 			return fmt.Errorf("no IRQs matched the filter: %v",
 				irqTuning.Filter)
 		}
@@ -180,6 +229,7 @@ func applyIRQConfig(
 		// cleanup managed IRQs map
 		managedIRQs := make([]int, 0, len(irqs))
 		setIRQs := make([]int, 0, len(irqs))
+		overridden := make(map[string][]int)
 		for irqNum := range matchingIRQs {
 			success, managedIRQ, err := handler.WriteCPUAffinity(irqNum, irqTuning.CPUs)
 			if err != nil {
@@ -190,6 +240,10 @@ func applyIRQConfig(
 			}
 			if success {
 				setIRQs = append(setIRQs, irqNum)
+				if owner, ok := owners[irqNum]; ok {
+					overridden[owner] = append(overridden[owner], irqNum)
+				}
+				owners[irqNum] = label
 			}
 		}
 
@@ -197,31 +251,222 @@ func applyIRQConfig(
 		if err != nil {
 			return err
 		}
-		logChanges(setIRQs, managedIRQs, cpus, irqTuning.CPUs)
+		logChanges(setIRQs, managedIRQs, cpus, irqTuning.CPUs, config.DryRun)
+		for _, owner := range rules {
+			if irqs, ok := overridden[owner]; ok {
+				log.Printf("Warning: rule %s overrides rule %s on IRQs %s\n",
+					label, owner, cpulists.GenCPUlist(irqs))
+			}
+		}
 	}
+	logPrecedence(owners, rules)
 	return nil
 }
 
-// filterIRQs filters IRQs based on the provided filters (matches any filter).
+// logPrecedence reports the rule finally setting the affinity of each IRQ,
+// when several rules apply.
+func logPrecedence(owners map[int]string, rules []string) {
+	if len(rules) < 2 {
+		return
+	}
+	owned := make(map[string][]int)
+	for irqNum, rule := range owners {
+		owned[rule] = append(owned[rule], irqNum)
+	}
+
+	msgs := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if irqs, ok := owned[rule]; ok {
+			msgs = append(msgs, fmt.Sprintf("Rule %s owns IRQs %s", rule,
+				cpulists.GenCPUlist(irqs)))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("Rule %s owns no IRQ", rule))
+		}
+	}
+	log.Println("Precedence:")
+	utils.LogTreeStyle(msgs)
+}
+
+// applyHousekeeping moves the IRQs off the remove-from-cpus CPUs, to the
+// handle-on-cpus ones, which also handle the IRQs registered later. The IRQs
+// which can't be moved are reported.
+func applyHousekeeping(config *model.InternalConfig, irqs []IRQInfo, handler IRQReaderWriter) error {
+	hk := config.Data.IRQHousekeeping
+	if hk == (model.IRQs{}) {
+		return nil
+	}
+	log.Println("Housekeeping:")
+
+	remove, handle, err := hk.CPUs()
+	if err != nil {
+		return err
+	}
+	handleList := cpulists.GenCPUlist(handle.List())
+
+	var moved, managed, failed []int
+	for _, irq := range irqs {
+		if !onCPUs(irq.Affinity, remove) {
+			continue
+		}
+		success, managedIRQ, err := handler.WriteCPUAffinity(irq.Number, handleList)
+		switch {
+		case err != nil:
+			debug.Printf("Failed to move IRQ %d: %v", irq.Number, err)
+			failed = append(failed, irq.Number)
+		case managedIRQ:
+			managed = append(managed, irq.Number)
+		case success:
+			moved = append(moved, irq.Number)
+		}
+	}
+
+	if err := handler.WriteDefaultAffinity(cpulists.GenCPUMask(handle.List())); err != nil {
+		return err
+	}
+
+	moveVerb, setVerb := "Moved", "Set"
+	if config.DryRun {
+		moveVerb, setVerb = "Would move", "Would set"
+	}
+	var msgs []string
+	if len(moved) > 0 {
+		msgs = append(msgs, fmt.Sprintf("%s IRQs %s to CPUs %s", moveVerb,
+			cpulists.GenCPUlist(moved), handleList))
+	}
+	if len(managed) > 0 {
+		msgs = append(msgs, fmt.Sprintf("Ignored managed IRQs: %s",
+			cpulists.GenCPUlist(managed)))
+	}
+	if len(failed) > 0 {
+		msgs = append(msgs, fmt.Sprintf("Could not move IRQs: %s",
+			cpulists.GenCPUlist(failed)))
+	}
+	msgs = append(msgs, fmt.Sprintf("%s the default affinity of new IRQs to CPUs %s",
+		setVerb, handleList))
+	utils.LogTreeStyle(msgs)
+	return nil
+}
+
+// onCPUs reports whether an IRQ affinity includes any of the CPUs, true when
+// the affinity is unknown.
+func onCPUs(affinity string, cpus cpulists.CPUs) bool {
+	current, err := cpulists.Parse(affinity)
+	if affinity == "" || err != nil {
+		return true
+	}
+	for cpu := range current {
+		if cpus[cpu] {
+			return true
+		}
+	}
+	return false
+}
+
+// filterIRQs filters IRQs based on the provided filter: the IRQs matching
+// all its fields and all its blocks.
 func filterIRQs(irqs []IRQInfo, filter model.IRQFilter) (IRQs, error) {
-	matchingIRQs := make(IRQs)
+	matchingIRQs, err := filterFields(irqs, filter)
+	if err != nil {
+		return nil, err
+	}
 
+	for _, f := range filter.All {
+		all, err := filterIRQs(irqs, f)
+		if err != nil {
+			return nil, err
+		}
+		for num := range matchingIRQs {
+			if !all[num] {
+				delete(matchingIRQs, num)
+			}
+		}
+	}
+	if len(filter.Any) > 0 {
+		anyIRQs := make(IRQs)
+		for _, f := range filter.Any {
+			matching, err := filterIRQs(irqs, f)
+			if err != nil {
+				return nil, err
+			}
+			for num := range matching {
+				anyIRQs[num] = true
+			}
+		}
+		for num := range matchingIRQs {
+			if !anyIRQs[num] {
+				delete(matchingIRQs, num)
+			}
+		}
+	}
+	if filter.Not != nil {
+		not, err := filterIRQs(irqs, *filter.Not)
+		if err != nil {
+			return nil, err
+		}
+		for num := range not {
+			delete(matchingIRQs, num)
+		}
+	}
+	return matchingIRQs, nil
+}
+
+// filterFields returns the IRQs matching all the fields of the filter,
+// ignoring its blocks.
+func filterFields(irqs []IRQInfo, filter model.IRQFilter) (IRQs, error) {
+	var numbers cpulists.CPUs
+	if filter.Number != "" {
+		var err error
+		if numbers, err = model.ParseNumbers(filter.Number); err != nil {
+			return nil, fmt.Errorf("invalid IRQ numbers %q: %v", filter.Number, err)
+		}
+	}
+	var hwirqs cpulists.CPUs
+	if filter.Hwirq != "" {
+		var err error
+		if hwirqs, err = model.ParseNumbers(filter.Hwirq); err != nil {
+			return nil, fmt.Errorf("invalid hwirqs %q: %v", filter.Hwirq, err)
+		}
+	}
+	devices, err := filterDeviceIRQs(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	matchingIRQs := make(IRQs)
 	for _, irq := range irqs {
-		if matchesAnyFilter(irq, filter) {
+		if numbers != nil && !numbers[irq.Number] {
+			continue
+		}
+		if devices != nil && !devices[irq.Number] {
+			continue
+		}
+		if hwirqs != nil && !matchesNumbers(irq.Hwirq, hwirqs) {
+			continue
+		}
+		if filter.Wakeup != "" && irq.Wakeup != filter.Wakeup {
+			continue
+		}
+		if matchesAllFilters(irq, filter) {
 			matchingIRQs[irq.Number] = true
 		}
 	}
 	return matchingIRQs, nil
 }
 
-// matchesAnyFilter checks if an IRQ matches any of the given filters.
-func matchesAnyFilter(irq IRQInfo, filter model.IRQFilter) bool {
+// matchesAllFilters checks if an IRQ matches all the regexes of the filter.
+func matchesAllFilters(irq IRQInfo, filter model.IRQFilter) bool {
 	return matchesRegex(irq.Actions, filter.Actions) &&
 		matchesRegex(irq.ChipName, filter.ChipName) &&
 		matchesRegex(irq.Name, filter.Name) &&
 		matchesRegex(irq.Type, filter.Type)
 }
 
+// matchesNumbers checks if a number read from sysfs is in the list.
+func matchesNumbers(value string, numbers cpulists.CPUs) bool {
+	n, err := strconv.Atoi(value)
+	return err == nil && numbers[n]
+}
+
 // matchesRegex checks if a field matches a regex pattern.
 func matchesRegex(value, pattern string) bool {
 	if pattern == "" {
@@ -231,15 +476,19 @@ func matchesRegex(value, pattern string) bool {
 	return err == nil && match
 }
 
-func logChanges(changed, managed []int, cpuList cpulists.CPUs, cpus string) {
+func logChanges(changed, managed []int, cpuList cpulists.CPUs, cpus string, dryRun bool) {
 	pluralSuffix := "s"
 	if len(cpuList) == 1 {
 		pluralSuffix = ""
 	}
+	verb := "Assigned"
+	if dryRun {
+		verb = "Would assign"
+	}
 
 	var msgs []string
 	if len(changed) > 0 {
-		msgs = append(msgs, fmt.Sprintf("Assigned IRQs %s to CPU%s %s",
+		msgs = append(msgs, fmt.Sprintf("%s IRQs %s to CPU%s %s", verb,
 			cpulists.GenCPUlist(changed), pluralSuffix, cpus))
 	}
 
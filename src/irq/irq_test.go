@@ -4,18 +4,25 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/canonical/rt-conf/src/model"
+	"github.com/canonical/rt-conf/src/sysfs"
+	"go.yaml.in/yaml/v4"
 )
 
 // MockIRQReaderWriter is a mock implementation of IRQReaderWriter for testing.
 type mockIRQReaderWriter struct {
 	IRQs            map[uint]IRQInfo
 	WrittenAffinity map[int]string
+	DefaultAffinity string
 	Errors          map[string]error
+	// Per IRQ write errors, and managed IRQs
+	IRQErrors   map[int]error
+	ManagedIRQs map[int]bool
 }
 
 func (m *mockIRQReaderWriter) ReadIRQs() ([]IRQInfo, error) {
@@ -35,6 +42,12 @@ func (m *mockIRQReaderWriter) WriteCPUAffinity(irqNum int, cpus string) (
 	if err, ok := m.Errors["WriteCPUAffinity"]; ok {
 		return false, false, err
 	}
+	if err, ok := m.IRQErrors[irqNum]; ok {
+		return false, false, err
+	}
+	if m.ManagedIRQs[irqNum] {
+		return false, true, nil
+	}
 	if m.WrittenAffinity == nil {
 		m.WrittenAffinity = make(map[int]string)
 	}
@@ -44,6 +57,14 @@ func (m *mockIRQReaderWriter) WriteCPUAffinity(irqNum int, cpus string) (
 	return true, false, nil
 }
 
+func (m *mockIRQReaderWriter) WriteDefaultAffinity(mask string) error {
+	if err, ok := m.Errors["WriteDefaultAffinity"]; ok {
+		return err
+	}
+	m.DefaultAffinity = mask
+	return nil
+}
+
 type IRQTestCase struct {
 	Yaml    string
 	Handler IRQReaderWriter
@@ -159,8 +180,33 @@ func mainLogicIRQ(t *testing.T, cfg IRQTestCase, i int) (string, error) {
 	return "", nil
 }
 
+// setupSysfsRoot makes the tuners use a temporary root, and returns its
+// dir for path, e.g. model.ProcIRQ.
+func setupSysfsRoot(t *testing.T, path string) string {
+	t.Helper()
+	root := t.TempDir()
+	sysfs.SetRoot(root)
+	t.Cleanup(func() { sysfs.SetRoot("") })
+
+	dir := filepath.Join(root, path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	return dir
+}
+
+// writeErrFS fails to write any file
+type writeErrFS struct {
+	sysfs.FS
+	err error
+}
+
+func (w writeErrFS) WriteFile(_ string, _ []byte) error {
+	return w.err
+}
+
 func TestWriteCPUAffinitySuccessfulWrite(t *testing.T) {
-	tmpDir := t.TempDir()
+	tmpDir := setupSysfsRoot(t, model.ProcIRQ)
 
 	irqNum := 1
 	cpus := "0-3"
@@ -177,7 +223,6 @@ func TestWriteCPUAffinitySuccessfulWrite(t *testing.T) {
 		t.Fatalf("failed to close file: %v", err)
 	}
 
-	procIRQ = tmpDir // override to avoid touching /proc
 	writer := &realIRQReaderWriter{}
 	_, _, err = writer.WriteCPUAffinity(irqNum, cpus)
 	if err != nil {
@@ -195,7 +240,7 @@ func TestWriteCPUAffinitySuccessfulWrite(t *testing.T) {
 
 // Simulate a real write error that's not ignorable (not "input/output error")
 func TestWriteCPUAffinityFileNotFound(t *testing.T) {
-	procIRQ = "/this/path/does/not/exist"
+	setupSysfsRoot(t, model.ProcIRQ)
 
 	writer := &realIRQReaderWriter{}
 	_, _, err := writer.WriteCPUAffinity(99, "1-2")
@@ -210,9 +255,10 @@ func TestWriteCPUAffinityFileNotFound(t *testing.T) {
 
 func TestWriteCPUAffinityInputOutputErrorIgnored(t *testing.T) {
 	writer := &realIRQReaderWriter{}
-	writeFile = func(_ string, _ []byte, _ os.FileMode) error {
-		return fmt.Errorf("input/output error") // Simulated /proc error
+	sysfs.Root = writeErrFS{
+		err: fmt.Errorf("input/output error"), // Simulated /proc error
 	}
+	t.Cleanup(func() { sysfs.SetRoot("") })
 
 	_, _, err := writer.WriteCPUAffinity(1, "0")
 	if err != nil {
@@ -222,8 +268,7 @@ func TestWriteCPUAffinityInputOutputErrorIgnored(t *testing.T) {
 
 // Sanity: return nil even if file already has the value
 func TestWriteCPUAffinityAlreadySet(t *testing.T) {
-	tmpDir := t.TempDir()
-	procIRQ = tmpDir
+	tmpDir := setupSysfsRoot(t, model.ProcIRQ)
 
 	irqNum := 5
 	cpus := "0"
@@ -251,8 +296,7 @@ type irqDirEntry struct {
 func setupIRQTestDir(t *testing.T, entries []irqDirEntry) string {
 	t.Helper()
 
-	tmpDir := t.TempDir()
-	sysKernelIRQ = tmpDir
+	tmpDir := setupSysfsRoot(t, model.SysKernelIRQ)
 
 	for _, e := range entries {
 		dir := filepath.Join(tmpDir, strconv.Itoa(e.Number))
@@ -316,8 +360,7 @@ func TestReadIRQsEmptyActionsIgnored(t *testing.T) {
 }
 
 func TestReadIRQsNonNumericDirectoryIgnored(t *testing.T) {
-	tmp := t.TempDir()
-	sysKernelIRQ = tmp
+	tmp := setupSysfsRoot(t, model.SysKernelIRQ)
 	_ = os.Mkdir(filepath.Join(tmp, "notanumber"), 0o755)
 
 	r := &realIRQReaderWriter{}
@@ -331,7 +374,8 @@ func TestReadIRQsNonNumericDirectoryIgnored(t *testing.T) {
 }
 
 func TestReadIRQsReadDirError(t *testing.T) {
-	sysKernelIRQ = "/invalid/path"
+	sysfs.SetRoot(t.TempDir()) // without /sys/kernel/irq
+	t.Cleanup(func() { sysfs.SetRoot("") })
 
 	r := &realIRQReaderWriter{}
 
@@ -372,3 +416,284 @@ func TestApplyIRQConfig(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestDryRunWriteCPUAffinity(t *testing.T) {
+	tmpDir := setupSysfsRoot(t, model.ProcIRQ)
+
+	irqPath := filepath.Join(tmpDir, "7")
+	if err := os.MkdirAll(irqPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	affinityFile := filepath.Join(irqPath, "smp_affinity_list")
+	if err := os.WriteFile(affinityFile, []byte("0-3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := &dryRunIRQReaderWriter{}
+	success, managed, err := writer.WriteCPUAffinity(7, "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !success || managed {
+		t.Fatalf("expected success without managed IRQ, got %v %v",
+			success, managed)
+	}
+
+	content, err := os.ReadFile(affinityFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "0-3\n" {
+		t.Errorf("dry-run must not write, file content changed to %q",
+			string(content))
+	}
+}
+
+func TestDryRunWriteCPUAffinityMissingFile(t *testing.T) {
+	setupSysfsRoot(t, model.ProcIRQ)
+
+	writer := &dryRunIRQReaderWriter{}
+	_, _, err := writer.WriteCPUAffinity(99, "0")
+	if err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+	if !strings.Contains(err.Error(), "error reading") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+func TestApplyIRQConfigOnNoMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		global    string
+		onNoMatch string
+		expectErr bool
+	}{
+		{name: "fail by default", expectErr: true},
+		{name: "fail", onNoMatch: model.OnNoMatchFail, expectErr: true},
+		{name: "warn", onNoMatch: model.OnNoMatchWarn},
+		{name: "ignore", onNoMatch: model.OnNoMatchIgnore},
+		{name: "global warn", global: model.OnNoMatchWarn},
+		{name: "rule over global", global: model.OnNoMatchWarn,
+			onNoMatch: model.OnNoMatchFail, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &model.InternalConfig{
+				Data: model.Config{
+					Interrupts: model.Interrupts{
+						"absent-nic": {
+							CPUs:      "0",
+							Filter:    model.IRQFilter{Actions: "eth1"},
+							OnNoMatch: tc.onNoMatch,
+						},
+						"present-nic": {
+							CPUs:   "0",
+							Filter: model.IRQFilter{Actions: "eth0"},
+						},
+					},
+					IRQOnNoMatch: tc.global,
+				},
+			}
+			handler := &mockIRQReaderWriter{
+				IRQs: map[uint]IRQInfo{
+					10: {Number: 10, Actions: "eth0"},
+				},
+			}
+
+			err := applyIRQConfig(config, handler)
+			if tc.expectErr {
+				if err == nil || !strings.Contains(err.Error(), "no IRQs matched the filter") {
+					t.Fatalf("expected no match error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// The other rules still apply
+			if handler.WrittenAffinity[10] != "0" {
+				t.Errorf("expected IRQ 10 assigned to CPU 0, got %v", handler.WrittenAffinity)
+			}
+		})
+	}
+}
+
+func TestApplyIRQConfigPrecedence(t *testing.T) {
+	cpuDir := setupSysfsRoot(t, "/sys/devices/system/cpu")
+	if err := os.WriteFile(filepath.Join(cpuDir, "present"), []byte("0-1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var config model.InternalConfig
+	// Both rules match the IRQ 10, the last one in the file wins
+	if err := yaml.Unmarshal([]byte(`
+irq-tuning:
+  nics:
+    cpus: "0"
+    filter:
+      actions: "eth"
+  eth0:
+    cpus: "1"
+    filter:
+      actions: "eth0"
+`), &config.Data); err != nil {
+		t.Fatal(err)
+	}
+	handler := &mockIRQReaderWriter{
+		IRQs: map[uint]IRQInfo{
+			10: {Number: 10, Actions: "eth0"},
+			11: {Number: 11, Actions: "eth1"},
+		},
+	}
+	if err := applyIRQConfig(&config, handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[int]string{10: "1", 11: "0"}
+	if !reflect.DeepEqual(handler.WrittenAffinity, expected) {
+		t.Errorf("expected %v, got %v", expected, handler.WrittenAffinity)
+	}
+
+	// Unless the first one has a higher priority
+	rule := config.Data.Interrupts["nics"]
+	rule.Priority = 1
+	config.Data.Interrupts["nics"] = rule
+	if err := applyIRQConfig(&config, handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = map[int]string{10: "0", 11: "0"}
+	if !reflect.DeepEqual(handler.WrittenAffinity, expected) {
+		t.Errorf("expected %v, got %v", expected, handler.WrittenAffinity)
+	}
+}
+
+func TestApplyHousekeeping(t *testing.T) {
+	cpuDir := setupSysfsRoot(t, "/sys/devices/system/cpu")
+	if err := os.WriteFile(filepath.Join(cpuDir, "present"), []byte("0-7\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &model.InternalConfig{
+		Data: model.Config{
+			IRQHousekeeping: model.IRQs{IsolateCPU: "2-7"},
+			Interrupts: model.Interrupts{
+				"rt-nic": {CPUs: "3", Filter: model.IRQFilter{Actions: "eth1"}},
+			},
+		},
+	}
+	handler := &mockIRQReaderWriter{
+		IRQs: map[uint]IRQInfo{
+			10: {Number: 10, Actions: "eth0", Affinity: "0-7"},
+			11: {Number: 11, Actions: "eth1", Affinity: "0-7"},
+			12: {Number: 12, Actions: "nvme", Affinity: "4"},
+			13: {Number: 13, Actions: "timer", Affinity: "0"},
+			14: {Number: 14, Actions: "nvme", Affinity: "5"},
+			15: {Number: 15, Actions: "ipi", Affinity: "6"},
+		},
+		ManagedIRQs: map[int]bool{14: true},
+		IRQErrors:   map[int]error{15: fmt.Errorf("invalid argument")},
+	}
+
+	if err := applyIRQConfig(config, handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// IRQ 13 is already off the CPUs, and the rules apply afterwards
+	expected := map[int]string{10: "0-1", 11: "3", 12: "0-1"}
+	if !reflect.DeepEqual(handler.WrittenAffinity, expected) {
+		t.Errorf("expected %v, got %v", expected, handler.WrittenAffinity)
+	}
+	if handler.DefaultAffinity != "3" {
+		t.Errorf("expected default affinity 3, got %q", handler.DefaultAffinity)
+	}
+}
+
+func TestWriteDefaultAffinity(t *testing.T) {
+	procIRQ := setupSysfsRoot(t, model.ProcIRQ)
+	file := filepath.Join(procIRQ, "default_smp_affinity")
+	if err := os.WriteFile(file, []byte("ff\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&dryRunIRQReaderWriter{}).WriteDefaultAffinity("3"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content, _ := os.ReadFile(file); string(content) != "ff\n" {
+		t.Errorf("dry-run must not write, file content changed to %q", content)
+	}
+
+	if err := (&realIRQReaderWriter{}).WriteDefaultAffinity("3"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content, _ := os.ReadFile(file); string(content) != "3" {
+		t.Errorf("expected %q, got %q", "3", content)
+	}
+}
+
+func TestFilterIRQs(t *testing.T) {
+	root := setupSysfsRoot(t, "/sys")
+	files := map[string]string{
+		"bus/pci/devices/0000:01:00.0/uevent":      "DRIVER=igb\nPCI_SLOT_NAME=0000:01:00.0\n",
+		"bus/pci/devices/0000:01:00.0/msi_irqs/20": "msix\n",
+		"bus/pci/devices/0000:01:00.0/msi_irqs/21": "msix\n",
+		"bus/pci/devices/0000:02:00.0/uevent":      "DRIVER=nvme\n",
+		"bus/pci/devices/0000:02:00.0/irq":         "16\n",
+		"bus/pci/devices/0000:03:00.0/uevent":      "PCI_SLOT_NAME=0000:03:00.0\n",
+		"bus/pci/devices/0000:03:00.0/irq":         "17\n",
+		"class/net/eth0/device/msi_irqs/20":        "msix\n",
+		"class/net/eth0/device/msi_irqs/21":        "msix\n",
+		"class/net/lo/addr_len":                    "6\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	irqs := []IRQInfo{
+		{Number: 9, Actions: "acpi", Hwirq: "9", Wakeup: "enabled"},
+		{Number: 16, Actions: "nvme0q0", Hwirq: "16"},
+		{Number: 17, Actions: "ehci_hcd", Hwirq: "17"},
+		{Number: 20, Actions: "eth0-rx-0", Hwirq: "524288"},
+		{Number: 21, Actions: "eth0-tx-0", Hwirq: "524289"},
+	}
+	tests := []struct {
+		name     string
+		filter   model.IRQFilter
+		expected IRQs
+	}{
+		{"number", model.IRQFilter{Number: "16-20"}, IRQs{16: true, 17: true, 20: true}},
+		{"number missing on the system", model.IRQFilter{Number: "22-1000"}, IRQs{}},
+		{"number and actions", model.IRQFilter{Number: "16-21", Actions: "eth"}, IRQs{20: true, 21: true}},
+		{"hwirq", model.IRQFilter{Hwirq: "9,524289-600000"}, IRQs{9: true, 21: true}},
+		{"wakeup", model.IRQFilter{Wakeup: "enabled"}, IRQs{9: true}},
+		{"pci device", model.IRQFilter{PCIDevice: "0000:0[12]"}, IRQs{16: true, 20: true, 21: true}},
+		{"pci driver", model.IRQFilter{PCIDriver: "^nvme$"}, IRQs{16: true}},
+		{"pci device without driver", model.IRQFilter{PCIDevice: "03", PCIDriver: "."}, IRQs{}},
+		{"net interface", model.IRQFilter{NetInterface: "^eth0$"}, IRQs{20: true, 21: true}},
+		{"virtual net interface", model.IRQFilter{NetInterface: "lo"}, IRQs{}},
+		{"pci and net", model.IRQFilter{PCIDriver: "nvme", NetInterface: "eth0"}, IRQs{}},
+		{"any", model.IRQFilter{Any: []model.IRQFilter{{Actions: "nvme"}, {PCIDriver: "igb"}}},
+			IRQs{16: true, 20: true, 21: true}},
+		{"all", model.IRQFilter{Number: "9-20", All: []model.IRQFilter{{Actions: "_"}, {Hwirq: "0-100"}}},
+			IRQs{17: true}},
+		{"not", model.IRQFilter{Actions: "eth0", Not: &model.IRQFilter{Actions: "tx"}}, IRQs{20: true}},
+		{"nested", model.IRQFilter{Not: &model.IRQFilter{Any: []model.IRQFilter{{Wakeup: "enabled"}, {NetInterface: "eth0"}}}},
+			IRQs{16: true, 17: true}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			matching, err := filterIRQs(irqs, tc.filter)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(matching, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, matching)
+			}
+		})
+	}
+}
@@ -10,28 +10,74 @@ import (
 )
 
 var kcmdSys = map[system.SystemType]func(*model.InternalConfig) ([]string, error){
-	system.Rpi:        UpdateRPi,
-	system.Grub:       UpdateGrub,
-	system.UbuntuCore: UpdateUbuntuCore,
+	system.Rpi:         UpdateRPi,
+	system.Grub:        UpdateGrub,
+	system.Uboot:       UpdateUboot,
+	system.UbuntuCore:  UpdateUbuntuCore,
+	system.SystemdBoot: UpdateSystemdBoot,
+}
+
+var kcmdReset = map[system.SystemType]func(*model.InternalConfig) ([]string, error){
+	system.Rpi:         ResetRPi,
+	system.Grub:        ResetGrub,
+	system.Uboot:       ResetUboot,
+	system.UbuntuCore:  ResetUbuntuCore,
+	system.SystemdBoot: ResetSystemdBoot,
+}
+
+// bootloader returns the bootloader set by --bootloader or by the
+// configuration, the detected one otherwise.
+func bootloader(c *model.InternalConfig) (system.SystemType, error) {
+	name := c.Bootloader
+	if name == "" {
+		name = c.Data.KernelCmdline.Bootloader
+	}
+	if name != "" {
+		return system.ParseSystemType(name)
+	}
+
+	sys, err := system.DetectSystem()
+	if err != nil {
+		return system.Unknown, fmt.Errorf("failed to detect system: %v", err)
+	}
+	return sys, nil
+}
+
+// checkOffline rejects the bootloaders that can't be configured on an
+// offline root filesystem, see SetRoot.
+func checkOffline(sys system.SystemType) error {
+	if offlineRoot != "" && sys == system.UbuntuCore {
+		return fmt.Errorf("%v can't be configured with --root, its kernel command line is set through snapd", sys)
+	}
+	return nil
 }
 
 func ProcessKcmdArgs(c *model.InternalConfig) ([]string, error) {
 	utils.PrintTitle("Kernel Command Line Parameters")
-	if len(c.Data.KernelCmdline.Parameters) == 0 {
+	if c.Data.KernelCmdline.IsEmpty() {
 		// No kernel command line options to process
 		log.Println("No kernel command line options to process")
 		return nil, nil
 	}
 
 	var msgs []string
-	sys, err := system.DetectSystem()
+	sys, err := bootloader(c)
 	if err != nil {
-		return nil, fmt.Errorf("failed to detect system: %v", err)
+		return nil, err
 	}
 	processKcmd, ok := kcmdSys[sys]
 	if !ok {
 		return nil, fmt.Errorf("unsupported bootloader: %v", sys)
 	}
+	if c.TryNextBoot && sys != system.Grub {
+		return nil, fmt.Errorf("--try-next-boot is only supported with GRUB")
+	}
+	if err := checkOffline(sys); err != nil {
+		return nil, err
+	}
+	if c.TryNextBoot && offlineRoot != "" {
+		return nil, fmt.Errorf("--try-next-boot can't be used with --root")
+	}
 	tmp, err := processKcmd(c)
 	if err != nil {
 		return nil, err
@@ -40,3 +86,58 @@ func ProcessKcmdArgs(c *model.InternalConfig) ([]string, error) {
 
 	return msgs, nil
 }
+
+// ResetKcmdArgs removes the kernel command line parameters set by rt-conf
+// from the bootloader configuration.
+func ResetKcmdArgs(c *model.InternalConfig) ([]string, error) {
+	utils.PrintTitle("Kernel Command Line Parameters")
+
+	sys, err := bootloader(c)
+	if err != nil {
+		return nil, err
+	}
+	resetKcmd, ok := kcmdReset[sys]
+	if !ok {
+		return nil, fmt.Errorf("unsupported bootloader: %v", sys)
+	}
+	if err := checkOffline(sys); err != nil {
+		return nil, err
+	}
+	return resetKcmd(c)
+}
+
+// CommitKcmdArgs makes the kernel command line tried with --try-next-boot
+// permanent.
+func CommitKcmdArgs(c *model.InternalConfig) ([]string, error) {
+	utils.PrintTitle("Kernel Command Line Parameters")
+
+	sys, err := bootloader(c)
+	if err != nil {
+		return nil, err
+	}
+	if sys != system.Grub {
+		return nil, fmt.Errorf("committing is only supported with GRUB")
+	}
+	return CommitGrub(c)
+}
+
+// DetectBootloader reports the bootloaders found on the system, with the
+// evidence that led to them, and the one rt-conf uses.
+func DetectBootloader(c *model.InternalConfig) ([]string, error) {
+	utils.PrintTitle("Bootloader Detection")
+
+	detections, err := system.Detect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect system: %v", err)
+	}
+	overridden := c.Bootloader != "" || c.Data.KernelCmdline.Bootloader != ""
+	if !overridden && system.Ambiguous(detections) != nil {
+		// Reported by the conclusion
+		return DetectConclusion(detections, system.Unknown, false), nil
+	}
+	sys, err := bootloader(c)
+	if err != nil {
+		return nil, err
+	}
+	return DetectConclusion(detections, sys, overridden), nil
+}
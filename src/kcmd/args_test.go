@@ -2,6 +2,7 @@ package kcmd
 
 import (
 	"errors"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -125,3 +126,107 @@ func TestProcessKcmdArgs(t *testing.T) {
 	system.DetectSystem = savedDetect
 	kcmdSys = savedKcmdSys
 }
+
+func TestResetKcmdArgs(t *testing.T) {
+	savedDetect := system.DetectSystem
+	savedReset := kcmdReset
+	t.Cleanup(func() {
+		system.DetectSystem = savedDetect
+		kcmdReset = savedReset
+	})
+
+	kcmdReset = map[system.SystemType]func(*model.InternalConfig) ([]string, error){
+		system.Grub: func(_ *model.InternalConfig) ([]string, error) {
+			return []string{"reset"}, nil
+		},
+	}
+
+	tests := []struct {
+		name      string
+		mockSys   system.SystemType
+		mockErr   error
+		expect    []string
+		expectErr string
+	}{
+		{
+			name:      "Detection fails",
+			mockErr:   errors.New("simulated failure"),
+			expectErr: "failed to detect system",
+		},
+		{
+			name:      "Unsupported Bootloader",
+			mockSys:   system.Unknown,
+			expectErr: "unsupported bootloader",
+		},
+		{
+			name:    "Supported Bootloader",
+			mockSys: system.Grub,
+			expect:  []string{"reset"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			system.DetectSystem = func() (system.SystemType, error) {
+				return tc.mockSys, tc.mockErr
+			}
+
+			out, err := ResetKcmdArgs(&model.InternalConfig{})
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected error containing %q, got: %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(out, tc.expect) {
+				t.Fatalf("expected %v, got %v", tc.expect, out)
+			}
+		})
+	}
+}
+
+func TestBootloaderOverride(t *testing.T) {
+	savedDetect := system.DetectSystem
+	t.Cleanup(func() { system.DetectSystem = savedDetect })
+	system.DetectSystem = func() (system.SystemType, error) {
+		return system.Unknown, nil
+	}
+
+	tests := []struct {
+		name      string
+		flag      string
+		config    string
+		expect    system.SystemType
+		expectErr string
+	}{
+		{name: "Detected", expect: system.Unknown},
+		{name: "Configuration", config: "rpi", expect: system.Rpi},
+		{name: "Flag over configuration", flag: "grub", config: "rpi", expect: system.Grub},
+		{name: "Unknown bootloader", flag: "lilo", expectErr: `unknown bootloader "lilo"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &model.InternalConfig{
+				Data:       model.Config{KernelCmdline: model.KernelCmdline{Bootloader: tc.config}},
+				Bootloader: tc.flag,
+			}
+			sys, err := bootloader(c)
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected error containing %q, got: %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sys != tc.expect {
+				t.Fatalf("expected %v, got %v", tc.expect, sys)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package kcmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/model"
+)
+
+// readCmdlineFile reads the parameters from a kernel command line file,
+// which holds the whole command line in a single line.
+func readCmdlineFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return model.SplitCmdline(string(content)), nil
+}
+
+// renderCmdlineFile renders the content of a kernel command line file,
+// failing if it doesn't fit the kernel command line.
+func renderCmdlineFile(params []string) (string, error) {
+	cmdline := strings.Join(params, " ")
+	if len(cmdline) > model.CommandLineSize()-1 {
+		return "", fmt.Errorf("command line exceeds maximum length of %d bytes on %s",
+			model.CommandLineSize(), model.TargetArch())
+	}
+	return cmdline + "\n", nil
+}
+
+// writeCmdlineFile replaces the content of a kernel command line file,
+// keeping a copy of the previous content in a backup file.
+func writeCmdlineFile(path, content string) error {
+	if err := backupFile(path); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(content))
+}
+
+// backupFile copies path to path.bak, if path exists. An existing backup is
+// kept, so that it holds the content from before the first change.
+func backupFile(path string) error {
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	bak, err := os.OpenFile(path+".bak", os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to back up %s: %v", path, err)
+	}
+	if _, err := bak.Write(content); err != nil {
+		bak.Close()
+		return fmt.Errorf("failed to back up %s: %v", path, err)
+	}
+	if err := bak.Close(); err != nil {
+		return fmt.Errorf("failed to back up %s: %v", path, err)
+	}
+	return nil
+}
+
+// atomicTmpSuffix makes the name of the temporary file written next to a
+// file replaced by writeFileAtomic. It is fixed, so that the snap can be
+// granted access to it only.
+const atomicTmpSuffix = ".rt-conf.tmp"
+
+// writeFileAtomic replaces the content of path by writing to path.rt-conf.tmp
+// and renaming it over the original file, so the file is never left half
+// written. Existing permissions are kept. The temporary file is created
+// exclusively, so that concurrent runs don't write to the same one.
+var writeFileAtomic = func(path string, content []byte) error {
+	perm := os.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	tmpPath := path + atomicTmpSuffix
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("failed to create temporary file: %s exists, another rt-conf may be running, remove it otherwise", tmpPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write to %s file: %v", tmpPath, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s file: %v", tmpPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s file: %v", tmpPath, err)
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+	renamed = true
+	return nil
+}
+
+// ownedParam records a parameter set by rt-conf in a kernel command line
+// file, along with the parameter it replaced, if any. Files holding several
+// command lines, like extlinux.conf, scope the record to one of them.
+type ownedParam struct {
+	Param    string
+	Replaced string
+	Scope    string
+}
+
+// ownershipFile returns the path of the file recording the parameters
+// set by rt-conf in the kernel command line file at path.
+func ownershipFile(path string) string {
+	return path + ".rt-conf"
+}
+
+// readOwnedParams reads the parameters set by rt-conf in path.
+func readOwnedParams(path string) ([]ownedParam, error) {
+	content, err := os.ReadFile(ownershipFile(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", ownershipFile(path), err)
+	}
+
+	var owned []ownedParam
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := append(strings.Split(line, "\t"), "", "")
+		owned = append(owned, ownedParam{
+			Param:    fields[0],
+			Replaced: fields[1],
+			Scope:    fields[2],
+		})
+	}
+	return owned, nil
+}
+
+// writeOwnedParams records the parameters set by rt-conf in path,
+// removing the record when there is none.
+func writeOwnedParams(path string, owned []ownedParam) error {
+	if len(owned) == 0 {
+		err := os.Remove(ownershipFile(path))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %v", ownershipFile(path), err)
+		}
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# Parameters set by rt-conf in " + path + ", please do not edit\n")
+	for _, o := range owned {
+		sb.WriteString(o.Param)
+		if o.Replaced != "" || o.Scope != "" {
+			sb.WriteString("\t" + o.Replaced)
+		}
+		if o.Scope != "" {
+			sb.WriteString("\t" + o.Scope)
+		}
+		sb.WriteString("\n")
+	}
+	if err := writeFileAtomic(ownershipFile(path), []byte(sb.String())); err != nil {
+		return fmt.Errorf("failed to record the parameters set in %s: %v", path, err)
+	}
+	return nil
+}
+
+// replaceOwnedFile records newOwned for path, then replaces path with
+// content through write. The record is written first so that parameters set
+// in path are never left unrecorded; when write fails, the previous record,
+// owned, is put back.
+func replaceOwnedFile(path, content string, owned, newOwned []ownedParam,
+	write func(path, content string) error) error {
+	if err := writeOwnedParams(path, newOwned); err != nil {
+		return err
+	}
+	if err := write(path, content); err != nil {
+		if rerr := writeOwnedParams(path, owned); rerr != nil {
+			log.Printf("Warning: failed to restore the record of %s: %v", path, rerr)
+		}
+		return fmt.Errorf("error updating %s: %v", path, err)
+	}
+	return nil
+}
+
+// undoOwnedParams removes the parameters set by rt-conf from params,
+// restoring the ones they replaced. Records without a parameter stand for
+// parameters removed by rt-conf, which are appended back.
+func undoOwnedParams(params []string, owned []ownedParam) []string {
+	var restored []string
+	for _, p := range params {
+		i := slices.IndexFunc(owned, func(o ownedParam) bool {
+			return o.Param == p
+		})
+		switch {
+		case i == -1:
+			restored = append(restored, p)
+		case owned[i].Replaced != "":
+			restored = append(restored, owned[i].Replaced)
+		}
+	}
+	for _, o := range owned {
+		if o.Param == "" && o.Replaced != "" {
+			restored = append(restored, o.Replaced)
+		}
+	}
+	return restored
+}
+
+// scopedParams returns the ownership records of the given scope.
+func scopedParams(owned []ownedParam, scope string) []ownedParam {
+	var scoped []ownedParam
+	for _, o := range owned {
+		if o.Scope == scope {
+			scoped = append(scoped, o)
+		}
+	}
+	return scoped
+}
+
+// mergeOwnedParams merges params into existing, after undoing the changes
+// of a previous run so that parameters dropped from the configuration don't
+// linger. Parameters matching remove are dropped, as well as the later
+// occurrences of the parameters replaced. It returns the merged parameters
+// and the new ownership records.
+func mergeOwnedParams(existing []string, owned []ownedParam, params, remove []string) ([]string, []ownedParam) {
+	var base []string
+	var newOwned []ownedParam
+	for _, b := range undoOwnedParams(existing, owned) {
+		if model.RemovedBy(remove, b) {
+			newOwned = append(newOwned, ownedParam{Replaced: b})
+			continue
+		}
+		base = append(base, b)
+	}
+
+	for _, p := range params {
+		var matches []string
+		for _, b := range base {
+			if model.SameKey(b, p) {
+				matches = append(matches, b)
+			}
+		}
+		switch {
+		case len(matches) == 0:
+			newOwned = append(newOwned, ownedParam{Param: p})
+		case matches[0] != p:
+			newOwned = append(newOwned, ownedParam{Param: p, Replaced: matches[0]})
+		}
+		for _, m := range matches[min(1, len(matches)):] {
+			newOwned = append(newOwned, ownedParam{Replaced: m})
+		}
+	}
+	return model.MergeParams(base, params), newOwned
+}
+
+// removedParams returns the parameters removed by rt-conf, according to the
+// ownership records.
+func removedParams(owned []ownedParam) []string {
+	var removed []string
+	for _, o := range owned {
+		if o.Param == "" && o.Replaced != "" {
+			removed = append(removed, o.Replaced)
+		}
+	}
+	return removed
+}
+
+// resetCmdlineFile undoes the changes made by rt-conf to the kernel command
+// line file at path. The command is suggested to apply the changes, if any.
+func resetCmdlineFile(bootloader, path string, dryRun bool, command string) ([]string, error) {
+	owned, err := readOwnedParams(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(owned) == 0 {
+		return NothingToResetConclusion(bootloader), nil
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	content, err := renderCmdlineFile(
+		undoOwnedParams(model.SplitCmdline(string(current)), owned))
+	if err != nil {
+		return nil, fmt.Errorf("error updating %s: %v", path, err)
+	}
+
+	if dryRun {
+		return DryRunConclusion(bootloader, path, string(current), content), nil
+	}
+
+	if err := replaceOwnedFile(path, content, owned, nil, writeCmdlineFile); err != nil {
+		return nil, err
+	}
+
+	return ResetConclusion(bootloader, path, command), nil
+}
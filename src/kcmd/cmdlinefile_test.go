@@ -0,0 +1,356 @@
+package kcmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/canonical/rt-conf/src/model"
+)
+
+func TestReadCmdlineFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmdline")
+	if err := os.WriteFile(path, []byte("root=/dev/sda1  ro\tquiet\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := readCmdlineFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"root=/dev/sda1", "ro", "quiet"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("expected %q, got %q", expected, params)
+	}
+
+	if _, err := readCmdlineFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for missing file, got nil")
+	}
+}
+
+func TestRenderCmdlineFile(t *testing.T) {
+	content, err := renderCmdlineFile([]string{"root=/dev/sda1", "nohz=on"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content != "root=/dev/sda1 nohz=on\n" {
+		t.Errorf("unexpected content: %q", content)
+	}
+
+	_, err = renderCmdlineFile([]string{strings.Repeat("a", model.CommandLineSize())})
+	if err == nil || !strings.Contains(err.Error(), "exceeds maximum length") {
+		t.Errorf("expected length error, got %v", err)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("New file", func(t *testing.T) {
+		path := filepath.Join(dir, "new")
+		if err := writeFileAtomic(path, []byte("nohz=on\n")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0o644 {
+			t.Errorf("expected 0644 permissions, got %v", fi.Mode().Perm())
+		}
+	})
+
+	t.Run("Keeps permissions", func(t *testing.T) {
+		path := filepath.Join(dir, "existing")
+		if err := os.WriteFile(path, []byte("quiet\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFileAtomic(path, []byte("nohz=on\n")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0o600 {
+			t.Errorf("expected 0600 permissions, got %v", fi.Mode().Perm())
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "nohz=on\n" {
+			t.Errorf("unexpected content: %q", string(content))
+		}
+	})
+
+	t.Run("No leftover temporary files", func(t *testing.T) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), atomicTmpSuffix) {
+				t.Errorf("unexpected temporary file left: %s", e.Name())
+			}
+		}
+	})
+
+	t.Run("Concurrent run", func(t *testing.T) {
+		// The temporary file of another run is neither used nor removed
+		path := filepath.Join(dir, "concurrent")
+		if err := os.WriteFile(path+atomicTmpSuffix, []byte("other"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err := writeFileAtomic(path, []byte("nohz=on\n"))
+		if err == nil || !strings.Contains(err.Error(), "another rt-conf may be running") {
+			t.Fatalf("expected error, got %v", err)
+		}
+		content, err := os.ReadFile(path + atomicTmpSuffix)
+		if err != nil || string(content) != "other" {
+			t.Errorf("expected the temporary file of the other run to be kept, got %q, %v", content, err)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s not to be written, got %v", path, err)
+		}
+	})
+
+	t.Run("Missing directory", func(t *testing.T) {
+		err := writeFileAtomic(filepath.Join(dir, "missing", "file"), nil)
+		if err == nil || !strings.Contains(err.Error(), "failed to create temporary file") {
+			t.Errorf("expected error, got %v", err)
+		}
+	})
+}
+
+func TestWriteCmdlineFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cmdline.txt")
+
+	// No backup when there is nothing to back up
+	if err := writeCmdlineFile(path, "quiet\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Fatalf("expected no backup file, got %v", err)
+	}
+
+	if err := writeCmdlineFile(path, "quiet nohz=on\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for file, expected := range map[string]string{
+		path:          "quiet nohz=on\n",
+		path + ".bak": "quiet\n",
+	} {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Errorf("expected %q in %s, got %q", expected, file, string(content))
+		}
+	}
+
+	// Applying again keeps the original content in the backup
+	if err := writeCmdlineFile(path, "quiet nohz=on isolcpus=2\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, err := os.ReadFile(path + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "quiet\n" {
+		t.Errorf("expected the original content in the backup, got %q", string(content))
+	}
+}
+
+func TestOwnedParamsRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmdline.txt")
+
+	owned, err := readOwnedParams(path)
+	if err != nil || owned != nil {
+		t.Fatalf("expected no records, got %v, %v", owned, err)
+	}
+
+	expected := []ownedParam{
+		{Param: "nohz=on", Replaced: "nohz=off"},
+		{Param: "isolcpus=2-3"},
+		{Param: "quiet", Scope: "1"},
+		{Replaced: "splash", Scope: "1"},
+	}
+	if err := writeOwnedParams(path, expected); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	owned, err = readOwnedParams(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(owned, expected) {
+		t.Errorf("expected %v, got %v", expected, owned)
+	}
+
+	if err := writeOwnedParams(path, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(ownershipFile(path)); !os.IsNotExist(err) {
+		t.Errorf("expected record to be removed, got %v", err)
+	}
+}
+
+func TestReplaceOwnedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmdline.txt")
+	owned := []ownedParam{{Param: "nohz=on"}}
+	newOwned := []ownedParam{{Param: "nohz=on"}, {Param: "isolcpus=2-3"}}
+	if err := writeOwnedParams(path, owned); err != nil {
+		t.Fatal(err)
+	}
+
+	err := replaceOwnedFile(path, "nohz=on isolcpus=2-3\n", owned, newOwned,
+		func(_, _ string) error {
+			// The new record is in place before the file is replaced
+			if recorded, _ := readOwnedParams(path); !reflect.DeepEqual(recorded, newOwned) {
+				t.Errorf("expected record %v before writing, got %v", newOwned, recorded)
+			}
+			return errors.New("disk full")
+		})
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected write error, got %v", err)
+	}
+	if recorded, _ := readOwnedParams(path); !reflect.DeepEqual(recorded, owned) {
+		t.Errorf("expected previous record %v to be restored, got %v", owned, recorded)
+	}
+
+	if err := replaceOwnedFile(path, "nohz=on isolcpus=2-3\n", owned, newOwned,
+		writeCmdlineFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recorded, _ := readOwnedParams(path); !reflect.DeepEqual(recorded, newOwned) {
+		t.Errorf("expected record %v, got %v", newOwned, recorded)
+	}
+}
+
+func TestMergeOwnedParams(t *testing.T) {
+	tests := []struct {
+		name          string
+		existing      []string
+		owned         []ownedParam
+		params        []string
+		remove        []string
+		expected      []string
+		expectedOwned []ownedParam
+	}{
+		{
+			name:          "First run",
+			existing:      []string{"root=/dev/sda1", "nohz=off", "quiet"},
+			params:        []string{"nohz=on", "isolcpus=2-3", "quiet"},
+			expected:      []string{"root=/dev/sda1", "nohz=on", "quiet", "isolcpus=2-3"},
+			expectedOwned: []ownedParam{{Param: "nohz=on", Replaced: "nohz=off"}, {Param: "isolcpus=2-3"}},
+		},
+		{
+			name:     "Parameter dropped from the configuration",
+			existing: []string{"root=/dev/sda1", "nohz=on", "quiet", "isolcpus=2-3"},
+			owned:    []ownedParam{{Param: "nohz=on", Replaced: "nohz=off"}, {Param: "isolcpus=2-3"}},
+			params:   []string{"nohz=on"},
+			expected: []string{"root=/dev/sda1", "nohz=on", "quiet"},
+			expectedOwned: []ownedParam{
+				{Param: "nohz=on", Replaced: "nohz=off"},
+			},
+		},
+		{
+			name:          "Parameter changed by someone else",
+			existing:      []string{"root=/dev/sda1", "isolcpus=1"},
+			owned:         []ownedParam{{Param: "isolcpus=2-3"}},
+			params:        []string{"isolcpus=2-3"},
+			expected:      []string{"root=/dev/sda1", "isolcpus=2-3"},
+			expectedOwned: []ownedParam{{Param: "isolcpus=2-3", Replaced: "isolcpus=1"}},
+		},
+		{
+			name:     "Removed parameters",
+			existing: []string{"root=/dev/sda1", "quiet", "splash", "intel_pstate=active", "console=tty0"},
+			params:   []string{"nohz=on"},
+			remove:   []string{"quiet", "intel-pstate", "console=ttyS0", "splash=1"},
+			expected: []string{"root=/dev/sda1", "splash", "console=tty0", "nohz=on"},
+			expectedOwned: []ownedParam{
+				{Replaced: "quiet"}, {Replaced: "intel_pstate=active"}, {Param: "nohz=on"},
+			},
+		},
+		{
+			name:     "Later occurrences replaced",
+			existing: []string{"console=tty0", "quiet", "console=ttyS0,115200"},
+			params:   []string{"console=ttyAMA0"},
+			expected: []string{"console=ttyAMA0", "quiet"},
+			expectedOwned: []ownedParam{
+				{Param: "console=ttyAMA0", Replaced: "console=tty0"}, {Replaced: "console=ttyS0,115200"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, owned := mergeOwnedParams(tc.existing, tc.owned, tc.params, tc.remove)
+			if !reflect.DeepEqual(merged, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, merged)
+			}
+			if !reflect.DeepEqual(owned, tc.expectedOwned) {
+				t.Errorf("expected ownership %v, got %v", tc.expectedOwned, owned)
+			}
+			restored := undoOwnedParams(merged, owned)
+			for _, p := range restored {
+				for _, o := range owned {
+					if p == o.Param {
+						t.Errorf("expected %q to be undone, got %q", p, restored)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestResetCmdlineFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmdline.txt")
+	if err := os.WriteFile(path, []byte("root=/dev/sda1 nohz=on isolcpus=2-3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := resetCmdlineFile("Raspberry Pi", path, false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(msgs, ""), "nothing to reset") {
+		t.Errorf("expected nothing to reset, got %v", msgs)
+	}
+
+	owned := []ownedParam{{Param: "nohz=on", Replaced: "nohz=off"}, {Param: "isolcpus=2-3"}}
+	if err := writeOwnedParams(path, owned); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err = resetCmdlineFile("Raspberry Pi", path, true, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(msgs, ""), "\troot=/dev/sda1 nohz=off\n") {
+		t.Errorf("expected dry-run output, got %v", msgs)
+	}
+
+	msgs, err = resetCmdlineFile("Raspberry Pi", path, false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(msgs, ""), "Removed the kernel command line parameters") {
+		t.Errorf("unexpected output: %v", msgs)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "root=/dev/sda1 nohz=off\n" {
+		t.Errorf("unexpected content: %q", string(content))
+	}
+	if _, err := os.Stat(ownershipFile(path)); !os.IsNotExist(err) {
+		t.Errorf("expected record to be removed, got %v", err)
+	}
+}
@@ -1,11 +1,21 @@
 package kcmd
 
-func GrubConclusion(grubFile, appended string) []string {
+import (
+	"fmt"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/system"
+)
+
+func GrubConclusion(grubFile, appended string, removed []string) []string {
 	s := []string{
-		"Detected bootloader: GRUB\n",
+		"Bootloader: GRUB\n",
 		"Created drop-in GRUB configuration file: " + grubFile + " \n",
 		"to append the following parameters:\n",
 		"\t" + appended + "\n",
+	}
+	s = append(s, removedLines(removed)...)
+	s = append(s,
 		"\n",
 		"Please run:\n",
 		"\n",
@@ -13,30 +23,303 @@ func GrubConclusion(grubFile, appended string) []string {
 		"\n",
 		"to apply the changes to your bootloader.\n",
 		"\n",
+	)
+	return s
+}
+
+// GrubTryConclusion describes the menu entry booting once with the new
+// kernel command line.
+func GrubTryConclusion(customFile, appended string) []string {
+	s := []string{
+		"Bootloader: GRUB\n",
+		"Added a menu entry to " + customFile + "\n",
+		"booting once with the following parameters:\n",
+		"\t" + appended + "\n",
+		"\n",
+		"Please reboot your system, then run:\n",
+		"\n",
+		"\tsudo rt-conf commit\n",
+		"\n",
+		"to make the changes permanent. Without it, the following boot\n",
+		"falls back to the previous kernel command line.\n",
+		"\n",
 	}
 	return s
 }
 
-func RpiConclusion(cmdline string) []string {
+// GrubRTKernelConclusion describes the real-time kernel made the default
+// GRUB entry.
+func GrubRTKernelConclusion(version string, only bool) []string {
+	s := []string{
+		"The real-time kernel " + version + " is now the default boot entry",
+	}
+	if only {
+		s[0] += ",\nand the only one booting with the parameters above.\n"
+	} else {
+		s[0] += ".\n"
+	}
+	return append(s, "\n")
+}
+
+func RpiConclusion(cmdlineFile, appended string, removed []string) []string {
 	s := []string{
-		"Detected bootloader: Raspberry Pi\n",
+		"Bootloader: Raspberry Pi\n",
+		"Updated " + cmdlineFile + "\n",
+		"with the following parameters:\n",
+		"\t" + appended + "\n",
+	}
+	s = append(s, removedLines(removed)...)
+	s = append(s,
 		"\n",
-		"Please, append the following to /boot/firmware/cmdline.txt:\n",
-		"In case of old style boot partition,\n",
-		"append to /boot/cmdline.txt\n",
-		cmdline,
+		"The original content is kept in "+cmdlineFile+".bak\n",
+		"Please reboot your system to apply the changes.\n",
 		"\n",
+	)
+	return s
+}
+
+func UbootConclusion(extlinuxFile, appended string, removed []string) []string {
+	s := []string{
+		"Bootloader: U-Boot\n",
+		"Updated the append lines of " + extlinuxFile + "\n",
+		"with the following parameters:\n",
+		"\t" + appended + "\n",
 	}
+	s = append(s, removedLines(removed)...)
+	s = append(s,
+		"\n",
+		"The original content is kept in "+extlinuxFile+".bak\n",
+		"Please reboot your system to apply the changes.\n",
+		"\n",
+	)
 	return s
 }
 
-func UbuntuCoreConclusion() []string {
+func UbootScriptConclusion(cmdline string) []string {
 	s := []string{
-		"Detected bootloader: Ubuntu Core managed\n",
+		"Bootloader: U-Boot\n",
 		"\n",
-		"Sucessfully applied the changes.\n",
+		"No extlinux.conf found, please add the following to the boot script\n",
+		"source (boot.cmd) and regenerate boot.scr with mkimage:\n",
+		"\tsetenv bootargs \"${bootargs} " + cmdline + "\"\n",
+		"\n",
+	}
+	return s
+}
+
+// SystemdBootConclusion describes the update of the kernel-install command
+// line file, and the commands regenerating the entries of the kernels.
+func SystemdBootConclusion(cmdlineFile, appended string, removed, commands []string) []string {
+	s := []string{
+		"Bootloader: systemd-boot\n",
+		"Updated " + cmdlineFile + "\n",
+		"with the following parameters:\n",
+		"\t" + appended + "\n",
+	}
+	s = append(s, removedLines(removed)...)
+	s = append(s,
+		"\n",
+		"Please run, for each kernel to boot with these parameters:\n",
+		"\n",
+	)
+	for _, c := range commands {
+		s = append(s, "\t"+c+"\n")
+	}
+	s = append(s,
+		"\n",
+		"to regenerate the boot loader entries, then reboot your system.\n",
+		"\n",
+	)
+	return s
+}
+
+// removedLines lists the parameters removed from the kernel command line.
+func removedLines(removed []string) []string {
+	if len(removed) == 0 {
+		return nil
+	}
+	return []string{
+		"and without the following ones:\n",
+		"\t" + strings.Join(removed, " ") + "\n",
+	}
+}
+
+// UbuntuCoreConclusion describes the kernel command line parameters
+// applied via the snapd system options.
+func UbuntuCoreConclusion(added, changed, removed, unchanged []string, rebootPending bool) []string {
+	s := []string{
+		"Bootloader: Ubuntu Core managed\n",
+		"\n",
+	}
+	s = append(s, CmdlineChangesConclusion(added, changed, removed, unchanged)...)
+	s = append(s, "Successfully applied the changes.\n")
+	if rebootPending {
+		s = append(s, "The change is waiting for a system restart to complete.\n")
+	}
+	s = append(s,
 		"Please reboot your system to apply the changes.\n",
 		"\n",
+	)
+	return s
+}
+
+// UbuntuCoreUpToDateConclusion reports that the snapd system options
+// already hold the kernel command line parameters.
+func UbuntuCoreUpToDateConclusion(unchanged []string) []string {
+	s := []string{
+		"Bootloader: Ubuntu Core managed\n",
+		"\n",
+	}
+	s = append(s, CmdlineChangesConclusion(nil, nil, nil, unchanged)...)
+	s = append(s,
+		"The kernel command line is already up to date.\n",
+		"\n",
+	)
+	return s
+}
+
+// CmdlineChangesConclusion lists the kernel command line parameters added,
+// changed, removed or left unchanged.
+func CmdlineChangesConclusion(added, changed, removed, unchanged []string) []string {
+	var s []string
+	for _, section := range []struct {
+		title  string
+		params []string
+	}{
+		{"Added", added},
+		{"Changed", changed},
+		{"Removed", removed},
+		{"Unchanged", unchanged},
+	} {
+		if len(section.params) == 0 {
+			continue
+		}
+		s = append(s, section.title+":\n")
+		for _, p := range section.params {
+			s = append(s, "\t"+p+"\n")
+		}
+	}
+	if len(s) > 0 {
+		s = append(s, "\n")
+	}
+	return s
+}
+
+// ResetConclusion describes the removal of the kernel command line parameters
+// set by rt-conf from target. The command applies the change, when needed.
+func ResetConclusion(bootloader, target, command string) []string {
+	s := []string{
+		"Bootloader: " + bootloader + "\n",
+		"Removed the kernel command line parameters set by rt-conf from " + target + "\n",
+		"\n",
+	}
+	if command != "" {
+		s = append(s,
+			"Please run:\n",
+			"\n",
+			"\t"+command+"\n",
+			"\n",
+			"to apply the changes to your bootloader.\n",
+			"\n",
+		)
+	} else {
+		s = append(s,
+			"Please reboot your system to apply the changes.\n",
+			"\n",
+		)
+	}
+	return s
+}
+
+// NothingToResetConclusion reports that rt-conf has no parameters to remove.
+func NothingToResetConclusion(bootloader string) []string {
+	s := []string{
+		"Bootloader: " + bootloader + "\n",
+		"\n",
+		"No kernel command line parameters set by rt-conf, nothing to reset.\n",
+		"\n",
 	}
 	return s
 }
+
+// DryRunConclusion describes the change that would be applied to target,
+// showing its current content and the content it would be replaced with.
+func DryRunConclusion(bootloader, target, current, next string) []string {
+	s := []string{
+		"Bootloader: " + bootloader + "\n",
+		"\n",
+		"Dry-run mode, no changes were made.\n",
+		"Would update " + target + "\n",
+		"\n",
+		"Current:\n",
+	}
+	s = append(s, indentLines(current)...)
+	s = append(s, "New:\n")
+	s = append(s, indentLines(next)...)
+	s = append(s, "\n")
+	return s
+}
+
+func indentLines(content string) []string {
+	content = strings.TrimSuffix(content, "\n")
+	if content == "" {
+		return []string{"\t(empty)\n"}
+	}
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		lines = append(lines, "\t"+line+"\n")
+	}
+	return lines
+}
+
+// StatusConclusion lists the state of each kernel command line parameter,
+// followed by the notes on the bootloader configuration.
+func StatusConclusion(statuses []ParamStatus, notes []string) []string {
+	width := 0
+	for _, st := range statuses {
+		width = max(width, len(st.Param))
+	}
+
+	s := []string{"Kernel command line parameters:\n"}
+	for _, st := range statuses {
+		line := fmt.Sprintf("\t%-*s  %s", width, st.Param, st.State)
+		if st.Running != "" && st.Running != st.Param {
+			line += " (running: " + st.Running + ")"
+		}
+		s = append(s, line+"\n")
+	}
+	s = append(s, "\n")
+
+	for _, note := range notes {
+		s = append(s, note+"\n")
+	}
+	if len(notes) > 0 {
+		s = append(s, "\n")
+	}
+	return s
+}
+
+// DetectConclusion describes the bootloaders found on the system and the
+// one used, set by the user when overridden.
+func DetectConclusion(detections []system.Detection, used system.SystemType, overridden bool) []string {
+	var s []string
+	if len(detections) == 0 {
+		s = append(s, "No bootloader detected.\n")
+	}
+	for _, d := range detections {
+		s = append(s, fmt.Sprintf("Found %s with %s confidence:\n", d.System, d.Confidence))
+		for _, e := range d.Evidence {
+			s = append(s, "\t- "+e+"\n")
+		}
+	}
+	s = append(s, "\n")
+	if overridden {
+		s = append(s, "Using bootloader: "+used.String()+", as set by the user\n")
+	} else if tied := system.Ambiguous(detections); tied != nil {
+		s = append(s, "No bootloader used: "+system.JoinSystems(tied)+
+			" were found with the same confidence, set --bootloader or kernel-cmdline.bootloader\n")
+	} else {
+		s = append(s, "Using bootloader: "+used.String()+"\n")
+	}
+	return append(s, "\n")
+}
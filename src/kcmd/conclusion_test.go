@@ -1,13 +1,18 @@
 package kcmd
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/canonical/rt-conf/src/system"
+)
 
 func TestGrubConclusion(t *testing.T) {
 	grubFile := "/etc/default/grub"
 	latest := "quiet splash isolcpus=1-2"
 
 	expected := []string{
-		"Detected bootloader: GRUB\n",
+		"Bootloader: GRUB\n",
 		"Created drop-in GRUB configuration file: " + grubFile + " \n",
 		"to append the following parameters:\n",
 		"\t" + latest + "\n",
@@ -20,7 +25,7 @@ func TestGrubConclusion(t *testing.T) {
 		"\n",
 	}
 
-	result := GrubConclusion(grubFile, latest)
+	result := GrubConclusion(grubFile, latest, nil)
 
 	if len(result) != len(expected) {
 		t.Errorf("Expected %d lines, got %d", len(expected), len(result))
@@ -34,17 +39,19 @@ func TestGrubConclusion(t *testing.T) {
 }
 
 func TestRpiConclusion(t *testing.T) {
-	cmdline := "quiet splash isolcpus=1-2"
+	cmdlineFile := "/boot/firmware/cmdline.txt"
+	cmdline := "isolcpus=1-2"
 	expected := []string{
-		"Detected bootloader: Raspberry Pi\n",
+		"Bootloader: Raspberry Pi\n",
+		"Updated " + cmdlineFile + "\n",
+		"with the following parameters:\n",
+		"\t" + cmdline + "\n",
 		"\n",
-		"Please, append the following to /boot/firmware/cmdline.txt:\n",
-		"In case of old style boot partition,\n",
-		"append to /boot/cmdline.txt\n",
-		cmdline,
+		"The original content is kept in " + cmdlineFile + ".bak\n",
+		"Please reboot your system to apply the changes.\n",
 		"\n",
 	}
-	result := RpiConclusion(cmdline)
+	result := RpiConclusion(cmdlineFile, cmdline, nil)
 
 	if len(result) != len(expected) {
 		t.Errorf("Expected %d lines, got %d", len(expected), len(result))
@@ -58,14 +65,182 @@ func TestRpiConclusion(t *testing.T) {
 }
 
 func TestUbuntuCoreConclusion(t *testing.T) {
+	tests := []struct {
+		name          string
+		rebootPending bool
+		expected      []string
+	}{
+		{
+			name: "Change done",
+			expected: []string{
+				"Bootloader: Ubuntu Core managed\n",
+				"\n",
+				"Added:\n",
+				"\tisolcpus=1-3\n",
+				"Changed:\n",
+				"\tnohz=off -> nohz=on\n",
+				"Removed:\n",
+				"\tquiet\n",
+				"\n",
+				"Successfully applied the changes.\n",
+				"Please reboot your system to apply the changes.\n",
+				"\n",
+			},
+		},
+		{
+			name:          "Change waiting for a restart",
+			rebootPending: true,
+			expected: []string{
+				"Bootloader: Ubuntu Core managed\n",
+				"\n",
+				"Added:\n",
+				"\tisolcpus=1-3\n",
+				"Changed:\n",
+				"\tnohz=off -> nohz=on\n",
+				"Removed:\n",
+				"\tquiet\n",
+				"\n",
+				"Successfully applied the changes.\n",
+				"The change is waiting for a system restart to complete.\n",
+				"Please reboot your system to apply the changes.\n",
+				"\n",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result := UbuntuCoreConclusion([]string{"isolcpus=1-3"},
+				[]string{"nohz=off -> nohz=on"}, []string{"quiet"}, nil, tc.rebootPending)
+
+			if len(result) != len(tc.expected) {
+				t.Fatalf("Expected %d lines, got %d", len(tc.expected), len(result))
+			}
+			for i, line := range tc.expected {
+				if result[i] != line {
+					t.Errorf("Expected line %d to be '%s', got '%s'", i, line, result[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUbuntuCoreUpToDateConclusion(t *testing.T) {
 	expected := []string{
-		"Detected bootloader: Ubuntu Core managed\n",
+		"Bootloader: Ubuntu Core managed\n",
 		"\n",
-		"Sucessfully applied the changes.\n",
+		"Unchanged:\n",
+		"\tnohz=on\n",
+		"\n",
+		"The kernel command line is already up to date.\n",
+		"\n",
+	}
+	result := UbuntuCoreUpToDateConclusion([]string{"nohz=on"})
+
+	if len(result) != len(expected) {
+		t.Errorf("Expected %d lines, got %d", len(expected), len(result))
+	}
+
+	for i, line := range expected {
+		if result[i] != line {
+			t.Errorf("Expected line %d to be '%s', got '%s'", i, line, result[i])
+		}
+	}
+}
+
+func TestDryRunConclusion(t *testing.T) {
+	expected := []string{
+		"Bootloader: GRUB\n",
+		"\n",
+		"Dry-run mode, no changes were made.\n",
+		"Would update /etc/default/grub.d/60_rt-conf.cfg\n",
+		"\n",
+		"Current:\n",
+		"\t(empty)\n",
+		"New:\n",
+		"\t# banner\n",
+		"\tGRUB_CMDLINE_LINUX_DEFAULT=\"nohz=on\"\n",
+		"\n",
+	}
+	result := DryRunConclusion("GRUB", "/etc/default/grub.d/60_rt-conf.cfg",
+		"", "# banner\nGRUB_CMDLINE_LINUX_DEFAULT=\"nohz=on\"\n")
+
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d: %q", len(expected), len(result), result)
+	}
+
+	for i, line := range expected {
+		if result[i] != line {
+			t.Errorf("Expected line %d to be '%s', got '%s'", i, line, result[i])
+		}
+	}
+}
+
+func TestUbootConclusion(t *testing.T) {
+	expected := []string{
+		"Bootloader: U-Boot\n",
+		"Updated the append lines of /boot/extlinux/extlinux.conf\n",
+		"with the following parameters:\n",
+		"\tisolcpus=1-2\n",
+		"\n",
+		"The original content is kept in /boot/extlinux/extlinux.conf.bak\n",
 		"Please reboot your system to apply the changes.\n",
 		"\n",
 	}
-	result := UbuntuCoreConclusion()
+	result := UbootConclusion("/boot/extlinux/extlinux.conf", "isolcpus=1-2", nil)
+
+	if len(result) != len(expected) {
+		t.Errorf("Expected %d lines, got %d", len(expected), len(result))
+	}
+
+	for i, line := range expected {
+		if result[i] != line {
+			t.Errorf("Expected line %d to be '%s', got '%s'", i, line, result[i])
+		}
+	}
+}
+
+func TestUbootScriptConclusion(t *testing.T) {
+	expected := []string{
+		"Bootloader: U-Boot\n",
+		"\n",
+		"No extlinux.conf found, please add the following to the boot script\n",
+		"source (boot.cmd) and regenerate boot.scr with mkimage:\n",
+		"\tsetenv bootargs \"${bootargs} isolcpus=1-2\"\n",
+		"\n",
+	}
+	result := UbootScriptConclusion("isolcpus=1-2")
+
+	if len(result) != len(expected) {
+		t.Errorf("Expected %d lines, got %d", len(expected), len(result))
+	}
+
+	for i, line := range expected {
+		if result[i] != line {
+			t.Errorf("Expected line %d to be '%s', got '%s'", i, line, result[i])
+		}
+	}
+}
+
+func TestSystemdBootConclusion(t *testing.T) {
+	expected := []string{
+		"Bootloader: systemd-boot\n",
+		"Updated /etc/kernel/cmdline\n",
+		"with the following parameters:\n",
+		"\tisolcpus=1-2\n",
+		"\n",
+		"Please run, for each kernel to boot with these parameters:\n",
+		"\n",
+		"\tsudo kernel-install add 6.8.0-1008-realtime /boot/vmlinuz-6.8.0-1008-realtime\n",
+		"\tsudo kernel-install add 6.8.0-45-generic /boot/vmlinuz-6.8.0-45-generic\n",
+		"\n",
+		"to regenerate the boot loader entries, then reboot your system.\n",
+		"\n",
+	}
+	result := SystemdBootConclusion("/etc/kernel/cmdline", "isolcpus=1-2", nil, []string{
+		"sudo kernel-install add 6.8.0-1008-realtime /boot/vmlinuz-6.8.0-1008-realtime",
+		"sudo kernel-install add 6.8.0-45-generic /boot/vmlinuz-6.8.0-45-generic",
+	})
 
 	if len(result) != len(expected) {
 		t.Errorf("Expected %d lines, got %d", len(expected), len(result))
@@ -77,3 +252,142 @@ func TestUbuntuCoreConclusion(t *testing.T) {
 		}
 	}
 }
+
+func TestResetConclusion(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  string
+		expected []string
+	}{
+		{
+			name:    "With command",
+			command: "sudo update-grub",
+			expected: []string{
+				"Bootloader: GRUB\n",
+				"Removed the kernel command line parameters set by rt-conf from /etc/default/grub.d/60_rt-conf.cfg\n",
+				"\n",
+				"Please run:\n",
+				"\n",
+				"\tsudo update-grub\n",
+				"\n",
+				"to apply the changes to your bootloader.\n",
+				"\n",
+			},
+		},
+		{
+			name: "Without command",
+			expected: []string{
+				"Bootloader: GRUB\n",
+				"Removed the kernel command line parameters set by rt-conf from /etc/default/grub.d/60_rt-conf.cfg\n",
+				"\n",
+				"Please reboot your system to apply the changes.\n",
+				"\n",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result := ResetConclusion("GRUB",
+				"/etc/default/grub.d/60_rt-conf.cfg", tc.command)
+
+			if len(result) != len(tc.expected) {
+				t.Fatalf("Expected %d lines, got %d", len(tc.expected), len(result))
+			}
+			for i, line := range tc.expected {
+				if result[i] != line {
+					t.Errorf("Expected line %d to be '%s', got '%s'", i, line, result[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNothingToResetConclusion(t *testing.T) {
+	expected := []string{
+		"Bootloader: Raspberry Pi\n",
+		"\n",
+		"No kernel command line parameters set by rt-conf, nothing to reset.\n",
+		"\n",
+	}
+	result := NothingToResetConclusion("Raspberry Pi")
+
+	if len(result) != len(expected) {
+		t.Errorf("Expected %d lines, got %d", len(expected), len(result))
+	}
+
+	for i, line := range expected {
+		if result[i] != line {
+			t.Errorf("Expected line %d to be '%s', got '%s'", i, line, result[i])
+		}
+	}
+}
+
+func TestStatusConclusion(t *testing.T) {
+	expected := []string{
+		"Kernel command line parameters:\n",
+		"\tnohz=on        active\n",
+		"\tisolcpus=1-3   pending reboot (running: isolcpus=1)\n",
+		"\tirqaffinity=0  missing\n",
+		"\n",
+		"Please run 'sudo update-grub' to apply them.\n",
+		"\n",
+	}
+	result := StatusConclusion([]ParamStatus{
+		{Param: "nohz=on", State: StatusActive, Running: "nohz=on"},
+		{Param: "isolcpus=1-3", State: StatusPendingReboot, Running: "isolcpus=1"},
+		{Param: "irqaffinity=0", State: StatusMissing},
+	}, []string{"Please run 'sudo update-grub' to apply them."})
+
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d", len(expected), len(result))
+	}
+
+	for i, line := range expected {
+		if result[i] != line {
+			t.Errorf("Expected line %d to be '%s', got '%s'", i, line, result[i])
+		}
+	}
+}
+
+func TestDetectConclusion(t *testing.T) {
+	detections := []system.Detection{
+		{
+			System:     system.Grub,
+			Confidence: system.Medium,
+			Evidence:   []string{"/etc/default/grub exists"},
+		},
+		{
+			System:     system.Uboot,
+			Confidence: system.Low,
+			Evidence:   []string{"/boot/boot.scr exists"},
+		},
+	}
+
+	got := strings.Join(DetectConclusion(detections, system.Grub, false), "")
+	expected := "Found grub with medium confidence:\n" +
+		"\t- /etc/default/grub exists\n" +
+		"Found uboot with low confidence:\n" +
+		"\t- /boot/boot.scr exists\n" +
+		"\n" +
+		"Using bootloader: grub\n" +
+		"\n"
+	if got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+
+	// Bootloaders found with the same confidence
+	detections[1].Confidence = system.Medium
+	got = strings.Join(DetectConclusion(detections, system.Unknown, false), "")
+	e := "No bootloader used: grub and uboot were found with the same confidence"
+	if !strings.Contains(got, e) {
+		t.Errorf("expected %q in:\n%s", e, got)
+	}
+
+	got = strings.Join(DetectConclusion(nil, system.Uboot, true), "")
+	for _, e := range []string{"No bootloader detected", "Using bootloader: uboot, as set by the user"} {
+		if !strings.Contains(got, e) {
+			t.Errorf("expected %q in:\n%s", e, got)
+		}
+	}
+}
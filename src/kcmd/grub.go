@@ -1,8 +1,12 @@
 package kcmd
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/canonical/rt-conf/src/model"
@@ -12,7 +16,7 @@ import (
 // merges it with the kernel command line parameters specified in the provided config,
 // and writes the resulting command line to a drop-in configuration file for GRUB.
 func UpdateGrub(cfg *model.InternalConfig) ([]string, error) {
-	if len(cfg.Data.KernelCmdline.Parameters) == 0 {
+	if cfg.Data.KernelCmdline.IsEmpty() {
 		return nil, fmt.Errorf("no parameters to inject")
 	}
 
@@ -20,21 +24,236 @@ func UpdateGrub(cfg *model.InternalConfig) ([]string, error) {
 		return nil, fmt.Errorf("invalid new parameters: %v", err)
 	}
 
-	cfg.GrubCfg.Cmdline = strings.Join(cfg.Data.KernelCmdline.Parameters, " ")
+	params, err := cfg.Data.KernelCmdline.Tokens()
+	if err != nil {
+		return nil, fmt.Errorf("invalid new parameters: %v", err)
+	}
+	cfg.GrubCfg.Cmdline = strings.Join(params, " ")
+
+	// The parameters set replace the ones of the default command lines
+	remove := cfg.Data.KernelCmdline.Remove
+	cfg.GrubCfg.Filter = slices.Clone(remove)
+	for _, p := range params {
+		cfg.GrubCfg.Filter = append(cfg.GrubCfg.Filter, model.ParamKey(p))
+	}
+
+	var removed []string
+	existing, err := grubDefaultCmdline(cfg.GrubCfg.GrubDropInFile)
+	if err != nil {
+		log.Printf("Warning: %v, the resulting kernel command line can't be validated", err)
+	} else {
+		var kept []string
+		for _, e := range existing {
+			if model.RemovedBy(remove, e) {
+				removed = append(removed, e)
+			}
+			if !model.RemovedBy(cfg.GrubCfg.Filter, e) {
+				kept = append(kept, e)
+			}
+		}
+		final := append(kept, params...)
+		if err := cfg.Data.KernelCmdline.ValidateCmdline(existing, final); err != nil {
+			return nil, err
+		}
+	}
+
+	rtKernel, err := setupGrubRTKernel(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.TryNextBoot {
+		return tryGrub(cfg, params)
+	}
+
+	if cfg.DryRun {
+		current, err := os.ReadFile(cfg.GrubCfg.GrubDropInFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read %s: %v",
+				cfg.GrubCfg.GrubDropInFile, err)
+		}
+		s := DryRunConclusion("GRUB", cfg.GrubCfg.GrubDropInFile,
+			string(current), grubDropIn(cfg.GrubCfg))
+		if cfg.GrubCfg.RTKernelOnly {
+			script, err := os.ReadFile(grubScriptFile)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("failed to read %s: %v", grubScriptFile, err)
+			}
+			s = append(s, DryRunConclusion("GRUB", grubScriptFile,
+				string(script), grubRTScript(cfg.GrubCfg))...)
+		}
+		return s, nil
+	}
 
 	if err := processFile(cfg.GrubCfg); err != nil {
 		return nil, fmt.Errorf("error updating %s: %v", cfg.GrubCfg.GrubDropInFile, err)
 	}
+	if err := writeGrubRTScript(cfg.GrubCfg); err != nil {
+		return nil, err
+	}
+	if err := discardGrubTry(cfg.GrubCfg.GrubDropInFile); err != nil {
+		return nil, err
+	}
+
+	s := GrubConclusion(cfg.GrubCfg.GrubDropInFile, cfg.GrubCfg.Cmdline, removed)
+	if rtKernel != "" {
+		s = append(s, GrubRTKernelConclusion(rtKernel, cfg.GrubCfg.RTKernelOnly)...)
+	}
+	return s, nil
+}
+
+// ResetGrub removes the drop-in GRUB configuration file created by rt-conf,
+// along with the kernel command line waiting to be committed and the
+// real-time kernel entry script, if any.
+func ResetGrub(cfg *model.InternalConfig) ([]string, error) {
+	dropIn := cfg.GrubCfg.GrubDropInFile
+	if !cfg.DryRun {
+		if err := discardGrubTry(dropIn); err != nil {
+			return nil, err
+		}
+		if err := removeGrubRTScript(); err != nil {
+			return nil, err
+		}
+	}
+
+	current, err := os.ReadFile(dropIn)
+	if errors.Is(err, os.ErrNotExist) {
+		return NothingToResetConclusion("GRUB"), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", dropIn, err)
+	}
+
+	if cfg.DryRun {
+		return DryRunConclusion("GRUB", dropIn, string(current), ""), nil
+	}
 
-	return GrubConclusion(cfg.GrubCfg.GrubDropInFile, cfg.GrubCfg.Cmdline), nil
+	if err := os.Remove(dropIn); err != nil {
+		return nil, fmt.Errorf("failed to remove %s: %v", dropIn, err)
+	}
+
+	return ResetConclusion("GRUB", dropIn, "sudo update-grub"), nil
+}
+
+// grubDropIn renders the content of the GRUB drop-in configuration file.
+// The file is sourced by grub-mkconfig, so the command line is escaped for
+// a double quoted shell string. The parameters matching the filter are
+// dropped from the default command lines by a shell function. When the
+// command line only applies to the real-time kernel, it is left to the
+// script generating its entry, see grubRTScript.
+func grubDropIn(grub model.Grub) string {
+	content := "# This file is automatically generated by rt-conf, please do not edit\n"
+	if grub.Default != "" {
+		content += fmt.Sprintf("GRUB_DEFAULT=\"%s\"\n", shellEscaper.Replace(grub.Default))
+	}
+	if grub.RTKernelOnly {
+		return content
+	}
+
+	cmdline := shellEscaper.Replace(grub.Cmdline)
+	if len(grub.Filter) == 0 {
+		return content + fmt.Sprintf(
+			"GRUB_CMDLINE_LINUX_DEFAULT=\"${GRUB_CMDLINE_LINUX_DEFAULT} %s\"\n", cmdline)
+	}
+
+	return content + grubFilterFunc(grub.Filter) +
+		`GRUB_CMDLINE_LINUX="$(rt_conf_filter "${GRUB_CMDLINE_LINUX}")"
+` + fmt.Sprintf("GRUB_CMDLINE_LINUX_DEFAULT=\"%s %s\"\n", grubFilterDefault, cmdline) +
+		"unset -f rt_conf_filter\n"
+}
+
+// grubFilterFunc renders the rt_conf_filter shell function, which prints
+// its argument without the parameters matching the filter.
+func grubFilterFunc(filter []string) string {
+	var patterns []string
+	for _, f := range filter {
+		key, value, hasValue := strings.Cut(model.UnquoteParam(f), "=")
+		// The kernel treats dashes and underscores alike. The rest of the
+		// key is quoted, to be matched literally and never expanded.
+		parts := strings.Split(strings.ReplaceAll(key, "_", "-"), "-")
+		for i, part := range parts {
+			parts[i] = `"` + shellEscaper.Replace(part) + `"`
+		}
+		key = strings.Join(parts, "[-_]")
+		if hasValue {
+			patterns = append(patterns, key+`"=`+shellEscaper.Replace(value)+`"`)
+			continue
+		}
+		patterns = append(patterns, key, key+"=*")
+	}
+
+	return `rt_conf_filter() {
+	set -f
+	rt_conf_out=""
+	for rt_conf_p in $1; do
+		case "$rt_conf_p" in
+		` + strings.Join(patterns, "|") + `) ;;
+		*) rt_conf_out="${rt_conf_out}${rt_conf_out:+ }${rt_conf_p}" ;;
+		esac
+	done
+	printf '%s' "$rt_conf_out"
+}
+`
+}
+
+// grubFilterDefault is the default command line once filtered, in the
+// drop-in configuration file
+const grubFilterDefault = `$(rt_conf_filter "${GRUB_CMDLINE_LINUX_DEFAULT}")`
+
+// grubDefaultCmdline returns the parameters of the default GRUB command
+// lines, set in /etc/default/grub and the other files of the drop-in
+// directory, in the order grub-mkconfig sources them.
+func grubDefaultCmdline(dropIn string) ([]string, error) {
+	dir := filepath.Dir(dropIn)
+	files, err := filepath.Glob(filepath.Join(dir, "*.cfg"))
+	if err != nil {
+		return nil, err
+	}
+	if defaults, ok := strings.CutSuffix(dir, ".d"); ok {
+		files = append([]string{defaults}, files...)
+	}
+
+	vars := map[string]string{}
+	for _, file := range files {
+		if file == dropIn {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			name, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+			if !ok || (name != "GRUB_CMDLINE_LINUX" && name != "GRUB_CMDLINE_LINUX_DEFAULT") {
+				continue
+			}
+			if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+				vars[name] = value[1 : len(value)-1]
+				continue
+			}
+			value = strings.TrimPrefix(value, "\"")
+			value = strings.TrimSuffix(value, "\"")
+			value = strings.ReplaceAll(value, "${"+name+"}", vars[name])
+			value = strings.ReplaceAll(value, "$"+name, vars[name])
+			vars[name] = shellUnescaper.Replace(value)
+		}
+	}
+	return model.SplitCmdline(vars["GRUB_CMDLINE_LINUX"] + " " +
+		vars["GRUB_CMDLINE_LINUX_DEFAULT"]), nil
 }
 
+// shellEscaper escapes the characters special in a double quoted shell
+// string, shellUnescaper reverts it.
+var (
+	shellEscaper = strings.NewReplacer(
+		`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	shellUnescaper = strings.NewReplacer(
+		`\\`, `\`, `\"`, `"`, `\$`, "$", "\\`", "`")
+)
+
 // processFile writes the GRUB configuration to the specified file.
 var processFile = func(grub model.Grub) error {
-	banner := "# This file is automatically generated by rt-conf, please do not edit\n"
-	cmdline := fmt.Sprintf(`GRUB_CMDLINE_LINUX_DEFAULT="${GRUB_CMDLINE_LINUX_DEFAULT} %s"\n`, grub.Cmdline)
-
-	content := banner + cmdline
+	content := grubDropIn(grub)
 
 	if err := os.WriteFile(grub.GrubDropInFile, []byte(content), 0o644); err != nil {
 		return fmt.Errorf("failed to write to %s file: %v", grub.GrubDropInFile, err)
@@ -3,7 +3,9 @@ package kcmd
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -61,6 +63,141 @@ func TestProcessFile(t *testing.T) {
 	})
 }
 
+func TestGrubDropInEscaping(t *testing.T) {
+	cmdline := `dyndbg="file foo.c +p" x=$(touch\tpwned) y=` + "`id`" + ` z=a\b`
+	path := filepath.Join(t.TempDir(), "60_rt-conf.cfg")
+	if err := os.WriteFile(path, []byte(grubDropIn(model.Grub{Cmdline: cmdline})), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := readGrubDropIn(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(params, " ") != cmdline {
+		t.Errorf("expected %q, got %q", cmdline, params)
+	}
+
+	// The drop-in must give back the command line verbatim once sourced
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	out, err := exec.Command("sh", "-c",
+		`GRUB_CMDLINE_LINUX_DEFAULT=quiet; . "$0"; printf %s "$GRUB_CMDLINE_LINUX_DEFAULT"`,
+		path).Output()
+	if err != nil {
+		t.Fatalf("failed to source %s: %v", path, err)
+	}
+	if string(out) != "quiet "+cmdline {
+		t.Errorf("expected %q, got %q", "quiet "+cmdline, out)
+	}
+}
+
+func TestGrubDropInFilter(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	path := filepath.Join(t.TempDir(), "60_rt-conf.cfg")
+	dropIn := grubDropIn(model.Grub{
+		Cmdline: "nohz=on isolcpus=2-3",
+		Filter:  []string{"quiet", "intel_pstate", "console=ttyS0", "nohz", "isolcpus"},
+	})
+	if err := os.WriteFile(path, []byte(dropIn), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("sh", "-c", `
+		GRUB_CMDLINE_LINUX="ro console=ttyS0 console=tty0 isolcpus=1"
+		GRUB_CMDLINE_LINUX_DEFAULT="quiet splash intel-pstate=active nohz=off"
+		. "$0"
+		type rt_conf_filter >/dev/null 2>&1 && echo "function leaked"
+		printf '%s|%s' "$GRUB_CMDLINE_LINUX" "$GRUB_CMDLINE_LINUX_DEFAULT"`,
+		path).Output()
+	if err != nil {
+		t.Fatalf("failed to source %s: %v", path, err)
+	}
+	expected := "ro console=tty0|splash nohz=on isolcpus=2-3"
+	if string(out) != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	params, err := readGrubDropIn(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(params, " ") != "nohz=on isolcpus=2-3" {
+		t.Errorf("expected the drop-in parameters, got %q", params)
+	}
+}
+
+func TestGrubDropInFilterHostileKey(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	dir := t.TempDir()
+	pwned := filepath.Join(dir, "pwned")
+	path := filepath.Join(dir, "60_rt-conf.cfg")
+	dropIn := grubDropIn(model.Grub{
+		Cmdline: "nohz=on",
+		Filter: []string{
+			"a$(touch${IFS}" + pwned + ")=1",
+			"b`touch " + pwned + "`",
+			`c";touch ` + pwned + `;"`,
+			"*",
+		},
+	})
+	if err := os.WriteFile(path, []byte(dropIn), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("sh", "-c", `
+		GRUB_CMDLINE_LINUX_DEFAULT='quiet a$(x)=1 *'
+		. "$0"
+		printf '%s' "$GRUB_CMDLINE_LINUX_DEFAULT"`,
+		path).Output()
+	if err != nil {
+		t.Fatalf("failed to source %s: %v", path, err)
+	}
+	if _, err := os.Stat(pwned); err == nil {
+		t.Fatal("sourcing the drop-in ran a command from a parameter name")
+	}
+	// The keys are matched literally, * doesn't match quiet
+	expected := "quiet a$(x)=1 nohz=on"
+	if string(out) != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestGrubDefaultCmdline(t *testing.T) {
+	dir := t.TempDir()
+	dropInDir := filepath.Join(dir, "grub.d")
+	if err := os.Mkdir(dropInDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(dir, "grub"): "GRUB_DEFAULT=0\n" +
+			"GRUB_CMDLINE_LINUX_DEFAULT=\"quiet splash\"\n" +
+			"GRUB_CMDLINE_LINUX=\"console=tty0\"\n",
+		filepath.Join(dropInDir, "50-cloudimg.cfg"): "GRUB_CMDLINE_LINUX_DEFAULT=\"$GRUB_CMDLINE_LINUX_DEFAULT console=ttyS0\"\n",
+		filepath.Join(dropInDir, "60_rt-conf.cfg"):  "GRUB_CMDLINE_LINUX_DEFAULT=\"nohz=on\"\n",
+		filepath.Join(dropInDir, "70-other.cfg"):    "GRUB_CMDLINE_LINUX='ro'\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	params, err := grubDefaultCmdline(filepath.Join(dropInDir, "60_rt-conf.cfg"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"ro", "quiet", "splash", "console=ttyS0"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("expected %q, got %q", expected, params)
+	}
+}
+
 func TestUpdateGrub(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -80,7 +217,7 @@ func TestUpdateGrub(t *testing.T) {
 					"isolcpus=1-3",
 				},
 			},
-			expectOutput: "Detected bootloader: GRUB",
+			expectOutput: "Bootloader: GRUB",
 		},
 		{
 			name: "ProcessFile fails",
@@ -99,7 +236,7 @@ func TestUpdateGrub(t *testing.T) {
 					"nohz=on",
 				},
 			},
-			expectOutput: "Detected bootloader: GRUB",
+			expectOutput: "Bootloader: GRUB",
 		},
 		{
 			name: "duplicate parameters with different values",
@@ -114,6 +251,14 @@ func TestUpdateGrub(t *testing.T) {
 		},
 	}
 
+	savedProcessFile := processFile
+	savedScriptFile := grubScriptFile
+	t.Cleanup(func() {
+		processFile = savedProcessFile
+		grubScriptFile = savedScriptFile
+	})
+	grubScriptFile = filepath.Join(t.TempDir(), "09_rt-conf")
+
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			tmpDir := t.TempDir()
@@ -162,3 +307,98 @@ func TestUpdateGrub(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateGrubDryRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfgPath := filepath.Join(tmpDir, "rt-conf.cfg")
+	previous := "GRUB_CMDLINE_LINUX_DEFAULT=\"${GRUB_CMDLINE_LINUX_DEFAULT} nohz=off\"\n"
+	if err := os.WriteFile(cfgPath, []byte(previous), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	savedProcessFile := processFile
+	t.Cleanup(func() { processFile = savedProcessFile })
+	processFile = func(_ model.Grub) error {
+		t.Fatal("processFile must not be called in dry-run mode")
+		return nil
+	}
+
+	conf := &model.InternalConfig{
+		Data: model.Config{
+			KernelCmdline: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+			},
+		},
+		GrubCfg: model.Grub{
+			GrubDropInFile: cfgPath,
+		},
+		DryRun: true,
+	}
+
+	msgs, err := UpdateGrub(conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	joined := strings.Join(msgs, "")
+	for _, expected := range []string{
+		"Would update " + cfgPath,
+		"nohz=off",
+		`"${GRUB_CMDLINE_LINUX_DEFAULT}") nohz=on`,
+	} {
+		if !strings.Contains(joined, expected) {
+			t.Errorf("expected output to contain %q, got:\n%s", expected, joined)
+		}
+	}
+
+	content, err := os.ReadFile(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != previous {
+		t.Errorf("dry-run must not write, got:\n%s", string(content))
+	}
+}
+
+func TestResetGrub(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "rt-conf.cfg")
+	savedScriptFile := grubScriptFile
+	t.Cleanup(func() { grubScriptFile = savedScriptFile })
+	grubScriptFile = filepath.Join(t.TempDir(), "09_rt-conf")
+	conf := &model.InternalConfig{
+		GrubCfg: model.Grub{
+			GrubDropInFile: cfgPath,
+		},
+	}
+
+	msgs, err := ResetGrub(conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(msgs, ""), "nothing to reset") {
+		t.Errorf("expected nothing to reset, got %v", msgs)
+	}
+
+	if err := os.WriteFile(cfgPath, []byte("GRUB_CMDLINE_LINUX_DEFAULT=\"nohz=on\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.DryRun = true
+	if _, err := ResetGrub(conf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(cfgPath); err != nil {
+		t.Fatalf("dry-run must not remove the drop-in: %v", err)
+	}
+
+	conf.DryRun = false
+	msgs, err = ResetGrub(conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(msgs, ""), "sudo update-grub") {
+		t.Errorf("expected update-grub hint, got %v", msgs)
+	}
+	if _, err := os.Stat(cfgPath); !os.IsNotExist(err) {
+		t.Errorf("expected drop-in to be removed, got %v", err)
+	}
+}
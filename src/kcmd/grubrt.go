@@ -0,0 +1,308 @@
+package kcmd
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/model"
+)
+
+// systemBootDir holds the installed kernels and their config files, on the
+// system booting them
+const systemBootDir = "/boot"
+
+// bootDir is where rt-conf reads systemBootDir, relocated by SetRoot
+var bootDir = systemBootDir
+
+// grubScriptFile generates the menu entry of the real-time kernel when the
+// parameters only apply to it. It runs before 10_linux, so that the entry
+// comes first in grub.cfg.
+var grubScriptFile = "/etc/grub.d/09_rt-conf"
+
+// grubRTEntry is the id of the menu entry generated by grubScriptFile
+const grubRTEntry = "rt-conf-realtime"
+
+// installedKernels returns the versions of the kernels installed in dir,
+// newest first.
+func installedKernels(dir string) ([]string, error) {
+	images, err := filepath.Glob(filepath.Join(dir, "vmlinuz-*"))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(images))
+	for _, image := range images {
+		versions = append(versions, strings.TrimPrefix(filepath.Base(image), "vmlinuz-"))
+	}
+	slices.SortFunc(versions, func(a, b string) int {
+		return compareKernelVersions(b, a)
+	})
+	return versions, nil
+}
+
+// rtKernels returns the versions of the PREEMPT_RT kernels installed in
+// dir, newest first.
+func rtKernels(dir string) ([]string, error) {
+	installed, err := installedKernels(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, version := range installed {
+		rt, err := isRTKernel(dir, version)
+		if err != nil {
+			return nil, err
+		}
+		if rt {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+// isRTKernel reports whether the kernel is built with PREEMPT_RT according
+// to its config file, or to its flavour when the config file is missing.
+func isRTKernel(dir, version string) (bool, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "config-"+version))
+	if errors.Is(err, os.ErrNotExist) {
+		return strings.HasSuffix(version, "-realtime") ||
+			strings.HasSuffix(version, "-rt"), nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read the config of kernel %s: %v", version, err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "CONFIG_PREEMPT_RT=y" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compareKernelVersions compares the versions chunk by chunk, the numeric
+// ones by value, so that 6.8.0-100 is newer than 6.8.0-99.
+func compareKernelVersions(a, b string) int {
+	for a != "" && b != "" {
+		var ca, cb string
+		ca, a = versionChunk(a)
+		cb, b = versionChunk(b)
+		if isDigit(ca[0]) && isDigit(cb[0]) {
+			ca = strings.TrimLeft(ca, "0")
+			cb = strings.TrimLeft(cb, "0")
+			if c := cmp.Compare(len(ca), len(cb)); c != 0 {
+				return c
+			}
+		}
+		if c := strings.Compare(ca, cb); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// versionChunk splits the leading run of digits, or of non-digits, of s.
+func versionChunk(s string) (string, string) {
+	i := 1
+	for i < len(s) && isDigit(s[i]) == isDigit(s[0]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// grubMenuID matches the id of a menu entry or submenu in grub.cfg
+var grubMenuID = regexp.MustCompile(`(?:\$menuentry_id_option|--id)\s+'?([^'\s]+)'?`)
+
+// grubMenuTitle matches the title of a menu entry or submenu in grub.cfg
+var grubMenuTitle = regexp.MustCompile(`^(?:menuentry|submenu)\s+'([^']*)'`)
+
+// grubEntryPath returns the GRUB_DEFAULT path, submenus included, of the
+// first menu entry of grub.cfg booting the kernel version. Recovery entries
+// are skipped. The entries of the submenus are preferred, since the top
+// level entry boots the newest kernel, whichever it is after an update.
+func grubEntryPath(grubCfg, version string) (string, error) {
+	// The ids of the blocks being read, empty for the other blocks
+	var blocks []string
+	// The first top level entry booting the kernel, used when no submenu
+	// entry does
+	topLevel := ""
+	for _, line := range strings.Split(grubCfg, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "}":
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+			continue
+		case strings.HasSuffix(trimmed, "{"):
+			id := ""
+			if strings.HasPrefix(trimmed, "menuentry ") || strings.HasPrefix(trimmed, "submenu ") {
+				if m := grubMenuID.FindStringSubmatch(trimmed); m != nil {
+					id = m[1]
+				} else if m := grubMenuTitle.FindStringSubmatch(trimmed); m != nil {
+					id = m[1]
+				}
+			}
+			blocks = append(blocks, id)
+			continue
+		}
+
+		fields := model.SplitCmdline(trimmed)
+		if len(fields) < 2 || (fields[0] != "linux" && fields[0] != "linuxefi") ||
+			!strings.HasSuffix(fields[1], "/vmlinuz-"+version) ||
+			slices.Contains(fields[2:], "recovery") {
+			continue
+		}
+		var path []string
+		for _, id := range blocks {
+			if id != "" {
+				path = append(path, id)
+			}
+		}
+		if len(path) > 1 {
+			return strings.Join(path, ">"), nil
+		}
+		if len(path) == 1 && topLevel == "" {
+			topLevel = path[0]
+		}
+	}
+	if topLevel != "" {
+		return topLevel, nil
+	}
+	return "", fmt.Errorf("no menu entry found for kernel %s", version)
+}
+
+// setupGrubRTKernel sets the default entry of GRUB to the newest real-time
+// kernel, according to the GRUB options of the configuration.
+func setupGrubRTKernel(cfg *model.InternalConfig) (string, error) {
+	opts := cfg.Data.KernelCmdline.Grub
+	if !opts.DefaultRTKernel && !opts.RTKernelOnly {
+		return "", nil
+	}
+
+	kernels, err := rtKernels(bootDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to look for real-time kernels: %v", err)
+	}
+	if len(kernels) == 0 {
+		return "", fmt.Errorf("no real-time kernel found in %s", bootDir)
+	}
+	version := kernels[0]
+
+	if opts.RTKernelOnly {
+		if cfg.TryNextBoot {
+			return "", fmt.Errorf("--try-next-boot can't be used with kernel-cmdline.grub.rt-kernel-only")
+		}
+		cfg.GrubCfg.RTKernelOnly = true
+		cfg.GrubCfg.Default = grubRTEntry
+		return version, nil
+	}
+
+	grubCfg, err := os.ReadFile(grubCfgFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", grubCfgFile, err)
+	}
+	path, err := grubEntryPath(string(grubCfg), version)
+	if err != nil {
+		return "", fmt.Errorf("%v in %s, please run 'sudo update-grub' first", err, grubCfgFile)
+	}
+	cfg.GrubCfg.Default = path
+	return version, nil
+}
+
+// grubRTScript renders the /etc/grub.d script generating the menu entry of
+// the newest real-time kernel, booting with the command line. It relies on
+// the helpers of grub-mkconfig, and looks for the kernel each time it runs,
+// so that the entry follows kernel updates. It runs on the target system, so
+// it reads systemBootDir even with SetRoot.
+func grubRTScript(grub model.Grub) string {
+	args := `${GRUB_CMDLINE_LINUX} ${GRUB_CMDLINE_LINUX_DEFAULT}`
+	filter := ""
+	if len(grub.Filter) > 0 {
+		args = `$(rt_conf_filter "` + args + `")`
+		filter = grubFilterFunc(grub.Filter)
+	}
+
+	return `#!/bin/sh
+# This file is automatically generated by rt-conf, please do not edit
+set -e
+
+. "$pkgdatadir/grub-mkconfig_lib"
+
+rt_conf_cmdline="` + shellEscaper.Replace(grub.Cmdline) + `"
+rt_conf_boot_dir="` + systemBootDir + `"
+` + filter + `
+rt_conf_versions=""
+for rt_conf_image in ${rt_conf_boot_dir}/vmlinuz-*; do
+	[ -f "${rt_conf_image}" ] || continue
+	rt_conf_version="${rt_conf_image#${rt_conf_boot_dir}/vmlinuz-}"
+	rt_conf_config="${rt_conf_boot_dir}/config-${rt_conf_version}"
+	if [ -f "${rt_conf_config}" ]; then
+		grep -qx 'CONFIG_PREEMPT_RT=y' "${rt_conf_config}" || continue
+	else
+		case "${rt_conf_version}" in
+		*-realtime|*-rt) ;;
+		*) continue ;;
+		esac
+	fi
+	rt_conf_versions="${rt_conf_versions} ${rt_conf_version}"
+done
+if [ -z "${rt_conf_versions}" ]; then
+	echo "rt-conf: no real-time kernel found in ${rt_conf_boot_dir}" >&2
+	exit 0
+fi
+rt_conf_version="$(version_find_latest ${rt_conf_versions})"
+echo "Found real-time kernel for rt-conf: ${rt_conf_boot_dir}/vmlinuz-${rt_conf_version}" >&2
+
+rt_conf_boot="$(make_system_path_relative_to_its_root ${rt_conf_boot_dir})"
+if [ -z "${GRUB_DEVICE_UUID}" ] || [ "${GRUB_DISABLE_LINUX_UUID}" = "true" ]; then
+	rt_conf_root="${GRUB_DEVICE}"
+else
+	rt_conf_root="UUID=${GRUB_DEVICE_UUID}"
+fi
+
+printf "menuentry 'Linux %s with the rt-conf parameters' --id ` + grubRTEntry + ` {\n" "${rt_conf_version}"
+prepare_grub_to_access_device "${GRUB_DEVICE_BOOT}" | sed 's/^/\t/'
+printf '\tlinux\t%s root=%s ro %s\n' "${rt_conf_boot}/vmlinuz-${rt_conf_version}" \
+	"${rt_conf_root}" "` + args + ` ${rt_conf_cmdline}"
+if [ -f "${rt_conf_boot_dir}/initrd.img-${rt_conf_version}" ]; then
+	printf '\tinitrd\t%s\n' "${rt_conf_boot}/initrd.img-${rt_conf_version}"
+fi
+echo "}"
+`
+}
+
+// writeGrubRTScript writes the script generating the real-time kernel
+// entry when the parameters only apply to it, and removes it otherwise.
+func writeGrubRTScript(grub model.Grub) error {
+	if !grub.RTKernelOnly {
+		return removeGrubRTScript()
+	}
+	if err := os.WriteFile(grubScriptFile, []byte(grubRTScript(grub)), 0o755); err != nil {
+		return fmt.Errorf("failed to write to %s file: %v", grubScriptFile, err)
+	}
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(grubScriptFile, 0o755); err != nil {
+		return fmt.Errorf("failed to make %s executable: %v", grubScriptFile, err)
+	}
+	return nil
+}
+
+// removeGrubRTScript removes the real-time kernel entry script, if any.
+func removeGrubRTScript() error {
+	err := os.Remove(grubScriptFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %v", grubScriptFile, err)
+	}
+	return nil
+}
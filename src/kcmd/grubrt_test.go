@@ -0,0 +1,312 @@
+package kcmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/canonical/rt-conf/src/model"
+)
+
+const grubCfgAdvanced = `
+menuentry 'Ubuntu' --class ubuntu $menuentry_id_option 'gnulinux-simple-1234' {
+	linux	/boot/vmlinuz-6.8.0-45-generic root=UUID=1234 ro quiet splash
+}
+submenu 'Advanced options for Ubuntu' $menuentry_id_option 'gnulinux-advanced-1234' {
+	menuentry 'Ubuntu, with Linux 6.8.0-45-generic' --class ubuntu $menuentry_id_option 'gnulinux-6.8.0-45-generic-advanced-1234' {
+		if [ x$grub_platform = xxen ]; then insmod xzio; fi
+		linux	/boot/vmlinuz-6.8.0-45-generic root=UUID=1234 ro quiet splash
+	}
+	menuentry 'Ubuntu, with Linux 6.8.0-1008-realtime (recovery mode)' --class ubuntu $menuentry_id_option 'gnulinux-6.8.0-1008-realtime-recovery-1234' {
+		linux	/boot/vmlinuz-6.8.0-1008-realtime root=UUID=1234 ro recovery nomodeset
+	}
+	menuentry 'Ubuntu, with Linux 6.8.0-1008-realtime' --class ubuntu $menuentry_id_option 'gnulinux-6.8.0-1008-realtime-advanced-1234' {
+		linux	/boot/vmlinuz-6.8.0-1008-realtime root=UUID=1234 ro quiet splash
+	}
+}
+`
+
+// writeBootDir creates kernel images in a temporary boot directory, along
+// with the config files given, and points bootDir to it.
+func writeBootDir(t *testing.T, kernels map[string]string) {
+	dir := t.TempDir()
+	for version, config := range kernels {
+		if err := os.WriteFile(filepath.Join(dir, "vmlinuz-"+version), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if config == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, "config-"+version), []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := bootDir
+	t.Cleanup(func() { bootDir = saved })
+	bootDir = dir
+}
+
+func TestRTKernels(t *testing.T) {
+	writeBootDir(t, map[string]string{
+		"6.8.0-45-generic":   "CONFIG_PREEMPT_DYNAMIC=y\n# CONFIG_PREEMPT_RT is not set\n",
+		"6.8.0-99-realtime":  "CONFIG_PREEMPT_RT=y\n",
+		"6.8.0-100-realtime": "CONFIG_PREEMPT_RT=y\n",
+		"6.8.0-101-custom":   "CONFIG_PREEMPT_RT=y\n",
+		"6.6.0-rt":           "",
+		"6.9.0-lowlatency":   "",
+	})
+
+	kernels, err := rtKernels(bootDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"6.8.0-101-custom", "6.8.0-100-realtime", "6.8.0-99-realtime", "6.6.0-rt"}
+	if !reflect.DeepEqual(kernels, expected) {
+		t.Errorf("expected %v, got %v", expected, kernels)
+	}
+}
+
+func TestCompareKernelVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"6.8.0-100-realtime", "6.8.0-99-realtime", 1},
+		{"6.8.0-1008-realtime", "6.8.0-1008-realtime", 0},
+		{"5.15.0-1032-realtime", "6.8.0-1008-realtime", -1},
+		{"6.8.0", "6.8.0-1", -1},
+		{"6.08.0", "6.8.0", 0},
+	}
+
+	for _, tc := range tests {
+		if got := compareKernelVersions(tc.a, tc.b); got != tc.expected {
+			t.Errorf("compareKernelVersions(%q, %q): expected %d, got %d", tc.a, tc.b, tc.expected, got)
+		}
+	}
+}
+
+func TestGrubEntryPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		grubCfg   string
+		version   string
+		expected  string
+		expectErr string
+	}{
+		{
+			name:     "Advanced entry",
+			grubCfg:  grubCfgAdvanced,
+			version:  "6.8.0-1008-realtime",
+			expected: "gnulinux-advanced-1234>gnulinux-6.8.0-1008-realtime-advanced-1234",
+		},
+		{
+			// Not the top level entry, which boots the newest kernel
+			name:     "Newest kernel",
+			grubCfg:  grubCfgAdvanced,
+			version:  "6.8.0-45-generic",
+			expected: "gnulinux-advanced-1234>gnulinux-6.8.0-45-generic-advanced-1234",
+		},
+		{
+			name:     "Top level entry only",
+			grubCfg:  grubCfgAdvanced[:strings.Index(grubCfgAdvanced, "submenu")],
+			version:  "6.8.0-45-generic",
+			expected: "gnulinux-simple-1234",
+		},
+		{
+			name:     "Entries without ids",
+			grubCfg:  grubCfgSample,
+			version:  "6.8.0-1008-realtime",
+			expected: "gnulinux-simple",
+		},
+		{
+			name:      "Missing kernel",
+			grubCfg:   grubCfgAdvanced,
+			version:   "6.8.0-2000-realtime",
+			expectErr: "no menu entry found for kernel 6.8.0-2000-realtime",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path, err := grubEntryPath(tc.grubCfg, tc.version)
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if path != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, path)
+			}
+		})
+	}
+}
+
+func TestGrubRTScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell available")
+	}
+	writeBootDir(t, map[string]string{
+		"6.8.0-45-generic":    "# CONFIG_PREEMPT_RT is not set\n",
+		"6.8.0-1008-realtime": "CONFIG_PREEMPT_RT=y\n",
+	})
+	if err := os.WriteFile(filepath.Join(bootDir, "initrd.img-6.8.0-1008-realtime"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stubs of the grub-mkconfig helpers
+	lib := t.TempDir()
+	if err := os.WriteFile(filepath.Join(lib, "grub-mkconfig_lib"), []byte(`
+prepare_grub_to_access_device() { echo "search --fs-uuid $1"; }
+make_system_path_relative_to_its_root() { echo /boot; }
+version_find_latest() { echo "$@" | tr ' ' '\n' | sort -V | tail -n 1; }
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The script reads /boot, point it to the fixture
+	content := strings.Replace(grubRTScript(model.Grub{
+		Cmdline: "isolcpus=2-3 nohz=on",
+		Filter:  []string{"quiet", "isolcpus", "nohz"},
+	}), `rt_conf_boot_dir="/boot"`, `rt_conf_boot_dir="`+bootDir+`"`, 1)
+	script := filepath.Join(t.TempDir(), "09_rt-conf")
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("sh", script)
+	cmd.Env = append(os.Environ(),
+		"pkgdatadir="+lib,
+		"GRUB_DEVICE=/dev/sda2",
+		"GRUB_DEVICE_UUID=1234",
+		"GRUB_DEVICE_BOOT=1234",
+		"GRUB_CMDLINE_LINUX=console=ttyS0",
+		"GRUB_CMDLINE_LINUX_DEFAULT=quiet splash isolcpus=1",
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("failed to run the script: %v", err)
+	}
+	expected := "menuentry 'Linux 6.8.0-1008-realtime with the rt-conf parameters' --id rt-conf-realtime {\n" +
+		"\tsearch --fs-uuid 1234\n" +
+		"\tlinux\t/boot/vmlinuz-6.8.0-1008-realtime root=UUID=1234 ro console=ttyS0 splash isolcpus=2-3 nohz=on\n" +
+		"\tinitrd\t/boot/initrd.img-6.8.0-1008-realtime\n" +
+		"}\n"
+	if string(out) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+
+	params, err := readGrubDropIn(script)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(params, " ") != "isolcpus=2-3 nohz=on" {
+		t.Errorf("expected the script parameters, got %q", params)
+	}
+}
+
+func TestUpdateGrubRTKernel(t *testing.T) {
+	writeBootDir(t, map[string]string{
+		"6.8.0-45-generic":    "# CONFIG_PREEMPT_RT is not set\n",
+		"6.8.0-1008-realtime": "CONFIG_PREEMPT_RT=y\n",
+	})
+	dir := t.TempDir()
+	savedCfgFile, savedScriptFile := grubCfgFile, grubScriptFile
+	savedCustomCfg := grubCustomCfg
+	t.Cleanup(func() {
+		grubCfgFile, grubScriptFile = savedCfgFile, savedScriptFile
+		grubCustomCfg = savedCustomCfg
+	})
+	grubCfgFile = filepath.Join(dir, "grub.cfg")
+	grubScriptFile = filepath.Join(dir, "09_rt-conf")
+	grubCustomCfg = filepath.Join(dir, "custom.cfg")
+	if err := os.WriteFile(grubCfgFile, []byte(grubCfgAdvanced), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	newConfig := func(opts model.GrubOptions) *model.InternalConfig {
+		return &model.InternalConfig{
+			Data: model.Config{KernelCmdline: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+				Grub:       opts,
+			}},
+			GrubCfg: model.Grub{GrubDropInFile: filepath.Join(dir, "60_rt-conf.cfg")},
+		}
+	}
+
+	t.Run("Default real-time kernel", func(t *testing.T) {
+		msgs, err := UpdateGrub(newConfig(model.GrubOptions{DefaultRTKernel: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		dropIn, err := os.ReadFile(filepath.Join(dir, "60_rt-conf.cfg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range []string{
+			`GRUB_DEFAULT="gnulinux-advanced-1234>gnulinux-6.8.0-1008-realtime-advanced-1234"`,
+			`GRUB_CMDLINE_LINUX_DEFAULT=`,
+		} {
+			if !strings.Contains(string(dropIn), expected) {
+				t.Errorf("expected the drop-in to contain %q, got:\n%s", expected, dropIn)
+			}
+		}
+		if _, err := os.Stat(grubScriptFile); !os.IsNotExist(err) {
+			t.Errorf("expected no entry script, got %v", err)
+		}
+		if !strings.Contains(strings.Join(msgs, ""), "6.8.0-1008-realtime is now the default boot entry") {
+			t.Errorf("expected the real-time kernel in the output, got %v", msgs)
+		}
+	})
+
+	t.Run("Real-time kernel only", func(t *testing.T) {
+		_, err := UpdateGrub(newConfig(model.GrubOptions{RTKernelOnly: true}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		dropIn, err := os.ReadFile(filepath.Join(dir, "60_rt-conf.cfg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(dropIn), "GRUB_CMDLINE_LINUX") ||
+			!strings.Contains(string(dropIn), `GRUB_DEFAULT="rt-conf-realtime"`) {
+			t.Errorf("expected the drop-in to only set the default entry, got:\n%s", dropIn)
+		}
+		info, err := os.Stat(grubScriptFile)
+		if err != nil {
+			t.Fatalf("expected the entry script: %v", err)
+		}
+		if info.Mode().Perm()&0o100 == 0 {
+			t.Errorf("expected the entry script to be executable, got %v", info.Mode())
+		}
+
+		if _, err := ResetGrub(newConfig(model.GrubOptions{})); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := os.Stat(grubScriptFile); !os.IsNotExist(err) {
+			t.Errorf("expected the entry script to be removed, got %v", err)
+		}
+	})
+
+	t.Run("Try next boot", func(t *testing.T) {
+		cfg := newConfig(model.GrubOptions{RTKernelOnly: true})
+		cfg.TryNextBoot = true
+		if _, err := UpdateGrub(cfg); err == nil || !strings.Contains(err.Error(), "can't be used") {
+			t.Errorf("expected an error, got %v", err)
+		}
+	})
+
+	t.Run("No real-time kernel", func(t *testing.T) {
+		writeBootDir(t, map[string]string{"6.8.0-45-generic": "# CONFIG_PREEMPT_RT is not set\n"})
+		_, err := UpdateGrub(newConfig(model.GrubOptions{DefaultRTKernel: true}))
+		if err == nil || !strings.Contains(err.Error(), "no real-time kernel found") {
+			t.Errorf("expected an error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,233 @@
+package kcmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/model"
+)
+
+// grubTryEntry is the id of the GRUB menu entry booting once with the new
+// kernel command line, see TryGrub
+const grubTryEntry = "rt-conf-try"
+
+// Markers of the try entry in custom.cfg
+const (
+	grubTryBegin = "### BEGIN rt-conf try entry ###"
+	grubTryEnd   = "### END rt-conf try entry ###"
+)
+
+// grubCustomCfg is sourced by grub.cfg at boot time, via /etc/grub.d/41_custom
+var grubCustomCfg = "/boot/grub/custom.cfg"
+
+// grubEditenv edits the default GRUB environment block
+var grubEditenv = func(args ...string) error {
+	out, err := exec.Command("grub-editenv", append([]string{"-"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("grub-editenv failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// pendingDropIn returns the path of the drop-in waiting to be committed.
+// It isn't sourced by grub-mkconfig, which only reads *.cfg files.
+func pendingDropIn(dropIn string) string {
+	return dropIn + ".try"
+}
+
+// tryGrub adds a menu entry with the new kernel command line to custom.cfg
+// and makes it the next_entry of GRUB, so that only the next boot uses it.
+// GRUB clears next_entry when booting it, the boot after that falls back to
+// the default entry. The drop-in is kept aside until CommitGrub.
+func tryGrub(cfg *model.InternalConfig, params []string) ([]string, error) {
+	grubCfg, err := os.ReadFile(grubCfgFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", grubCfgFile, err)
+	}
+	entry, err := grubTryMenuEntry(string(grubCfg), cfg.GrubCfg.Filter, params)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", grubCfgFile, err)
+	}
+
+	current, err := os.ReadFile(grubCustomCfg)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %v", grubCustomCfg, err)
+	}
+	custom := setGrubTryEntry(string(current), entry)
+
+	if cfg.DryRun {
+		return DryRunConclusion("GRUB", grubCustomCfg, string(current), custom), nil
+	}
+
+	pending := cfg.GrubCfg
+	pending.GrubDropInFile = pendingDropIn(cfg.GrubCfg.GrubDropInFile)
+	if err := processFile(pending); err != nil {
+		return nil, fmt.Errorf("error updating %s: %v", pending.GrubDropInFile, err)
+	}
+	if err := writeFileAtomic(grubCustomCfg, []byte(custom)); err != nil {
+		return nil, fmt.Errorf("error updating %s: %v", grubCustomCfg, err)
+	}
+	if err := grubEditenv("set", "next_entry="+grubTryEntry); err != nil {
+		return nil, err
+	}
+
+	return GrubTryConclusion(grubCustomCfg, cfg.GrubCfg.Cmdline), nil
+}
+
+// CommitGrub makes the kernel command line tried with --try-next-boot
+// permanent, once the running kernel uses it.
+func CommitGrub(cfg *model.InternalConfig) ([]string, error) {
+	dropIn := cfg.GrubCfg.GrubDropInFile
+	pending := pendingDropIn(dropIn)
+	content, err := os.ReadFile(pending)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("nothing to commit, run 'rt-conf apply --try-next-boot' first")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", pending, err)
+	}
+
+	params, err := readGrubDropIn(pending)
+	if err != nil {
+		return nil, err
+	}
+	running, err := readCmdlineFile(procCmdline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", procCmdline, err)
+	}
+	var missing []string
+	for _, p := range params {
+		if r, ok := lastWithKey(running, p); !ok || r != p {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("the running kernel doesn't use %s, reboot into "+
+			"the try entry first", strings.Join(missing, " "))
+	}
+
+	current, err := os.ReadFile(dropIn)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %v", dropIn, err)
+	}
+	if cfg.DryRun {
+		return DryRunConclusion("GRUB", dropIn, string(current), string(content)), nil
+	}
+
+	if err := os.Rename(pending, dropIn); err != nil {
+		return nil, fmt.Errorf("failed to commit %s: %v", pending, err)
+	}
+	if err := removeGrubTryEntry(); err != nil {
+		return nil, err
+	}
+
+	return GrubConclusion(dropIn, strings.Join(params, " "), nil), nil
+}
+
+// discardGrubTry removes the drop-in waiting to be committed and the try
+// entry, if any.
+func discardGrubTry(dropIn string) error {
+	err := os.Remove(pendingDropIn(dropIn))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %v", pendingDropIn(dropIn), err)
+	}
+	return removeGrubTryEntry()
+}
+
+// removeGrubTryEntry removes the try entry from custom.cfg, and the file
+// when nothing else is left in it.
+func removeGrubTryEntry() error {
+	current, err := os.ReadFile(grubCustomCfg)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", grubCustomCfg, err)
+	}
+
+	custom := setGrubTryEntry(string(current), "")
+	switch {
+	case custom == string(current):
+		return nil
+	case strings.TrimSpace(custom) == "":
+		err = os.Remove(grubCustomCfg)
+	default:
+		err = writeFileAtomic(grubCustomCfg, []byte(custom))
+	}
+	if err != nil {
+		return fmt.Errorf("error updating %s: %v", grubCustomCfg, err)
+	}
+	return nil
+}
+
+// grubTryMenuEntry returns a copy of the first menu entry of grub.cfg, the
+// default one, booting with params. The parameters matching filter are
+// dropped from its command line.
+func grubTryMenuEntry(grubCfg string, filter, params []string) (string, error) {
+	var body []string
+	depth := 0
+	hasLinux := false
+	for _, line := range strings.Split(grubCfg, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if depth == 0 {
+			if strings.HasPrefix(trimmed, "menuentry ") && strings.HasSuffix(trimmed, "{") {
+				depth = 1
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasSuffix(trimmed, "{"):
+			depth++
+		case trimmed == "}":
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+
+		fields := model.SplitCmdline(trimmed)
+		if len(fields) >= 2 && (fields[0] == "linux" || fields[0] == "linuxefi") {
+			var args []string
+			for _, a := range fields[2:] {
+				if !model.RemovedBy(filter, a) {
+					args = append(args, a)
+				}
+			}
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			line = indent + fields[0] + "\t" + fields[1] + " " +
+				strings.Join(append(args, params...), " ")
+			hasLinux = true
+		}
+		body = append(body, line)
+	}
+
+	if len(body) == 0 {
+		return "", fmt.Errorf("no menu entry found")
+	}
+	if !hasLinux {
+		return "", fmt.Errorf("no linux command found in the default menu entry")
+	}
+	return fmt.Sprintf("menuentry 'rt-conf: try the new kernel command line' --id %s {\n%s\n}\n",
+		grubTryEntry, strings.Join(body, "\n")), nil
+}
+
+// setGrubTryEntry replaces the try entry of custom.cfg with entry,
+// removing it when entry is empty.
+func setGrubTryEntry(custom, entry string) string {
+	if begin := strings.Index(custom, grubTryBegin+"\n"); begin != -1 {
+		if end := strings.Index(custom[begin:], grubTryEnd+"\n"); end != -1 {
+			custom = custom[:begin] + custom[begin+end+len(grubTryEnd)+1:]
+		}
+	}
+	if entry == "" {
+		return custom
+	}
+	if custom != "" && !strings.HasSuffix(custom, "\n") {
+		custom += "\n"
+	}
+	return custom + grubTryBegin + "\n" + entry + grubTryEnd + "\n"
+}
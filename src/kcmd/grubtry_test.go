@@ -0,0 +1,150 @@
+package kcmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/canonical/rt-conf/src/model"
+)
+
+func TestGrubTryMenuEntry(t *testing.T) {
+	entry, err := grubTryMenuEntry(grubCfgSample, []string{"quiet", "isolcpus"}, []string{"isolcpus=2-3", "idle=poll"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "menuentry 'rt-conf: try the new kernel command line' --id rt-conf-try {\n" +
+		"\trecordfail\n" +
+		"\tlinux\t/boot/vmlinuz-6.8.0-1008-realtime root=UUID=1234 ro splash nohz=on $vt_handoff isolcpus=2-3 idle=poll\n" +
+		"\tinitrd\t/boot/initrd.img-6.8.0-1008-realtime\n" +
+		"}\n"
+	if entry != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, entry)
+	}
+
+	if _, err := grubTryMenuEntry("set timeout=5\n", nil, nil); err == nil ||
+		!strings.Contains(err.Error(), "no menu entry found") {
+		t.Errorf("expected no menu entry error, got %v", err)
+	}
+}
+
+func TestSetGrubTryEntry(t *testing.T) {
+	entry := "menuentry 'try' --id rt-conf-try {\n}\n"
+	block := grubTryBegin + "\n" + entry + grubTryEnd + "\n"
+
+	tests := []struct {
+		name     string
+		custom   string
+		entry    string
+		expected string
+	}{
+		{"Empty custom.cfg", "", entry, block},
+		{"Existing entries", "menuentry 'mine' {\n}", entry, "menuentry 'mine' {\n}\n" + block},
+		{"Replaced entry", "# mine\n" + block, "menuentry 'new' {\n}\n",
+			"# mine\n" + grubTryBegin + "\nmenuentry 'new' {\n}\n" + grubTryEnd + "\n"},
+		{"Removed entry", "# mine\n" + block + "# other\n", "", "# mine\n# other\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := setGrubTryEntry(tc.custom, tc.entry); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestTryAndCommitGrub(t *testing.T) {
+	dir := t.TempDir()
+	savedGrubCfg := grubCfgFile
+	savedCustomCfg := grubCustomCfg
+	savedEditenv := grubEditenv
+	savedProcCmdline := procCmdline
+	t.Cleanup(func() {
+		grubCfgFile = savedGrubCfg
+		grubCustomCfg = savedCustomCfg
+		grubEditenv = savedEditenv
+		procCmdline = savedProcCmdline
+	})
+
+	grubCfgFile = filepath.Join(dir, "grub.cfg")
+	grubCustomCfg = filepath.Join(dir, "custom.cfg")
+	procCmdline = filepath.Join(dir, "cmdline")
+	if err := os.WriteFile(grubCfgFile, []byte(grubCfgSample), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var editenv [][]string
+	grubEditenv = func(args ...string) error {
+		editenv = append(editenv, args)
+		return nil
+	}
+
+	dropIn := filepath.Join(dir, "60_rt-conf.cfg")
+	cfg := &model.InternalConfig{
+		Data: model.Config{
+			KernelCmdline: model.KernelCmdline{
+				Parameters: []string{"isolcpus=2-3"},
+			},
+		},
+		GrubCfg:     model.Grub{GrubDropInFile: dropIn},
+		TryNextBoot: true,
+	}
+
+	msgs, err := UpdateGrub(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(msgs, ""), "sudo rt-conf commit") {
+		t.Errorf("expected commit instructions, got %q", msgs)
+	}
+	if !reflect.DeepEqual(editenv, [][]string{{"set", "next_entry=rt-conf-try"}}) {
+		t.Errorf("expected next_entry to be set, got %q", editenv)
+	}
+	if _, err := os.Stat(dropIn); !os.IsNotExist(err) {
+		t.Errorf("expected no drop-in before commit, got %v", err)
+	}
+	custom, err := os.ReadFile(grubCustomCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(custom), "--id rt-conf-try") ||
+		!strings.Contains(string(custom), "isolcpus=2-3") {
+		t.Errorf("expected try entry in custom.cfg, got:\n%s", custom)
+	}
+
+	// The next boot didn't use the try entry
+	if err := os.WriteFile(procCmdline, []byte("root=UUID=1234 ro isolcpus=1-3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CommitGrub(cfg); err == nil ||
+		!strings.Contains(err.Error(), "the running kernel doesn't use isolcpus=2-3") {
+		t.Fatalf("expected commit to fail, got %v", err)
+	}
+
+	if err := os.WriteFile(procCmdline, []byte("root=UUID=1234 ro isolcpus=2-3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err = CommitGrub(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(msgs, ""), "sudo update-grub") {
+		t.Errorf("expected update-grub instructions, got %q", msgs)
+	}
+	params, err := readGrubDropIn(dropIn)
+	if err != nil || !reflect.DeepEqual(params, []string{"isolcpus=2-3"}) {
+		t.Errorf("expected committed drop-in, got %q, %v", params, err)
+	}
+	if _, err := os.Stat(pendingDropIn(dropIn)); !os.IsNotExist(err) {
+		t.Errorf("expected pending drop-in to be removed, got %v", err)
+	}
+	if _, err := os.Stat(grubCustomCfg); !os.IsNotExist(err) {
+		t.Errorf("expected custom.cfg to be removed, got %v", err)
+	}
+
+	if _, err := CommitGrub(cfg); err == nil || !strings.Contains(err.Error(), "nothing to commit") {
+		t.Errorf("expected nothing to commit, got %v", err)
+	}
+}
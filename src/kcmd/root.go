@@ -0,0 +1,35 @@
+package kcmd
+
+import (
+	"path/filepath"
+
+	"github.com/canonical/rt-conf/src/system"
+)
+
+// offlineRoot is the root filesystem configured by rt-conf, e.g. a mounted
+// image, empty when configuring the running system. See SetRoot.
+var offlineRoot string
+
+// The paths of the running system relocated by SetRoot
+var rootPaths = map[*string]string{
+	&grubCfgFile:         grubCfgFile,
+	&grubCustomCfg:       grubCustomCfg,
+	&grubScriptFile:      grubScriptFile,
+	&bootDir:             bootDir,
+	&usrLibKernelCmdline: usrLibKernelCmdline,
+}
+
+// SetRoot makes the bootloader backends edit the files of the root
+// filesystem mounted on root instead of the ones of the running system,
+// an empty root sets them back. The running kernel and snapd are left
+// aside, so Ubuntu Core and the GRUB try entry aren't supported.
+func SetRoot(root string) {
+	offlineRoot = root
+	for path, def := range rootPaths {
+		*path = filepath.Join(root, def)
+	}
+	rpiCmdlineFiles = make([]string, len(system.RpiCmdlineFiles))
+	for i, f := range system.RpiCmdlineFiles {
+		rpiCmdlineFiles[i] = filepath.Join(root, f)
+	}
+}
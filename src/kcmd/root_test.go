@@ -0,0 +1,89 @@
+package kcmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/canonical/rt-conf/src/model"
+	"github.com/canonical/rt-conf/src/system"
+)
+
+func TestSetRoot(t *testing.T) {
+	t.Cleanup(func() { SetRoot("") })
+
+	root := t.TempDir()
+	SetRoot(root)
+	for _, tc := range []struct{ got, expected string }{
+		{grubCfgFile, filepath.Join(root, "/boot/grub/grub.cfg")},
+		{grubCustomCfg, filepath.Join(root, "/boot/grub/custom.cfg")},
+		{grubScriptFile, filepath.Join(root, "/etc/grub.d/09_rt-conf")},
+		{bootDir, filepath.Join(root, "/boot")},
+		{usrLibKernelCmdline, filepath.Join(root, "/usr/lib/kernel/cmdline")},
+		{rpiCmdlineFiles[0], filepath.Join(root, "/boot/firmware/cmdline.txt")},
+	} {
+		if tc.got != tc.expected {
+			t.Errorf("expected %s, got %s", tc.expected, tc.got)
+		}
+	}
+
+	SetRoot("")
+	if grubCfgFile != "/boot/grub/grub.cfg" || rpiCmdlineFiles[1] != "/boot/cmdline.txt" {
+		t.Errorf("expected the paths of the running system, got %s %v", grubCfgFile, rpiCmdlineFiles)
+	}
+}
+
+func TestProcessKcmdArgsOffline(t *testing.T) {
+	savedDetect := system.DetectSystem
+	t.Cleanup(func() {
+		system.DetectSystem = savedDetect
+		SetRoot("")
+	})
+	SetRoot(t.TempDir())
+
+	c := &model.InternalConfig{
+		Data: model.Config{KernelCmdline: model.KernelCmdline{Parameters: []string{"nohz=on"}}},
+	}
+	system.DetectSystem = func() (system.SystemType, error) {
+		return system.UbuntuCore, nil
+	}
+	if _, err := ProcessKcmdArgs(c); err == nil || !strings.Contains(err.Error(), "can't be configured with --root") {
+		t.Errorf("expected Ubuntu Core to be rejected, got %v", err)
+	}
+	if _, err := ResetKcmdArgs(c); err == nil || !strings.Contains(err.Error(), "can't be configured with --root") {
+		t.Errorf("expected Ubuntu Core to be rejected, got %v", err)
+	}
+
+	system.DetectSystem = func() (system.SystemType, error) {
+		return system.Grub, nil
+	}
+	c.TryNextBoot = true
+	if _, err := ProcessKcmdArgs(c); err == nil || !strings.Contains(err.Error(), "--try-next-boot can't be used with --root") {
+		t.Errorf("expected --try-next-boot to be rejected, got %v", err)
+	}
+}
+
+func TestGrubRTScriptOffline(t *testing.T) {
+	t.Cleanup(func() { SetRoot("") })
+	root := t.TempDir()
+	SetRoot(root)
+	if err := os.MkdirAll(filepath.Dir(grubScriptFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeGrubRTScript(model.Grub{Cmdline: "nohz=on", RTKernelOnly: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(root, "/etc/grub.d/09_rt-conf"))
+	if err != nil {
+		t.Fatalf("expected the script in the image: %v", err)
+	}
+	// The script runs in the image, where the kernels are in /boot
+	if !strings.Contains(string(content), `rt_conf_boot_dir="/boot"`+"\n") {
+		t.Errorf("expected the script to look for the kernels in /boot, got:\n%s", content)
+	}
+	if strings.Contains(string(content), root) {
+		t.Errorf("expected no path of the host in the script, got:\n%s", content)
+	}
+}
@@ -1,17 +1,95 @@
 package kcmd
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"slices"
 	"strings"
 
 	"github.com/canonical/rt-conf/src/model"
+	"github.com/canonical/rt-conf/src/system"
 )
 
-// InjectToGrubFiles inject the kernel command line parameters to the grub files. /etc/default/grub
+// rpiCmdlineFiles are the locations of cmdline.txt, relocated by SetRoot
+var rpiCmdlineFiles = slices.Clone(system.RpiCmdlineFiles)
+
+// UpdateRPi merges the kernel command line parameters into cmdline.txt,
+// replacing the ones already set.
 func UpdateRPi(cfg *model.InternalConfig) ([]string, error) {
-	if len(cfg.Data.KernelCmdline.Parameters) == 0 {
+	if cfg.Data.KernelCmdline.IsEmpty() {
 		return nil, fmt.Errorf("no parameters to inject")
 	}
 
-	return RpiConclusion(strings.Join(cfg.Data.KernelCmdline.Parameters, " ")), nil
+	if err := cfg.Data.KernelCmdline.HasDuplicates(); err != nil {
+		return nil, fmt.Errorf("invalid new parameters: %v", err)
+	}
+
+	cmdlineFile, err := findRpiCmdline()
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := os.ReadFile(cmdlineFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", cmdlineFile, err)
+	}
+
+	owned, err := readOwnedParams(cmdlineFile)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := cfg.Data.KernelCmdline.Tokens()
+	if err != nil {
+		return nil, fmt.Errorf("invalid new parameters: %v", err)
+	}
+	existing := model.SplitCmdline(string(current))
+	merged, newOwned := mergeOwnedParams(existing, owned, params,
+		cfg.Data.KernelCmdline.Remove)
+	if err := cfg.Data.KernelCmdline.ValidateCmdline(existing, merged); err != nil {
+		return nil, fmt.Errorf("error updating %s: %v", cmdlineFile, err)
+	}
+	content, err := renderCmdlineFile(merged)
+	if err != nil {
+		return nil, fmt.Errorf("error updating %s: %v", cmdlineFile, err)
+	}
+
+	if cfg.DryRun {
+		return DryRunConclusion("Raspberry Pi", cmdlineFile,
+			string(current), content), nil
+	}
+
+	if err := replaceOwnedFile(cmdlineFile, content, owned, newOwned,
+		writeCmdlineFile); err != nil {
+		return nil, err
+	}
+
+	return RpiConclusion(cmdlineFile, strings.Join(params, " "),
+		removedParams(newOwned)), nil
+}
+
+// ResetRPi removes the kernel command line parameters set by rt-conf
+// from cmdline.txt, restoring the ones they replaced.
+func ResetRPi(cfg *model.InternalConfig) ([]string, error) {
+	cmdlineFile, err := findRpiCmdline()
+	if err != nil {
+		return nil, err
+	}
+	return resetCmdlineFile("Raspberry Pi", cmdlineFile, cfg.DryRun, "")
+}
+
+// findRpiCmdline returns the path of the cmdline.txt read by the firmware.
+func findRpiCmdline() (string, error) {
+	for _, path := range rpiCmdlineFiles {
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to stat %s: %v", path, err)
+		}
+	}
+	return "", fmt.Errorf("cmdline.txt not found, looked for: %s",
+		strings.Join(rpiCmdlineFiles, ", "))
 }
@@ -1,6 +1,8 @@
 package kcmd
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -11,6 +13,11 @@ func TestUpdateRPi(t *testing.T) {
 	type testCase struct {
 		name        string
 		kcmdline    model.KernelCmdline
+		firmware    string // content of /boot/firmware/cmdline.txt
+		legacy      string // content of /boot/cmdline.txt
+		dryRun      bool
+		expectFile  string // which file is expected to be updated
+		expectCmd   string
 		expectErr   string
 		expectParts []string
 	}
@@ -21,6 +28,21 @@ func TestUpdateRPi(t *testing.T) {
 			kcmdline:  model.KernelCmdline{}, // all fields empty
 			expectErr: "no parameters to inject",
 		},
+		{
+			name: "Duplicate parameters with different values",
+			kcmdline: model.KernelCmdline{
+				Parameters: []string{"nohz=on", "nohz=off"},
+			},
+			firmware:  "console=serial0,115200 root=LABEL=writable\n",
+			expectErr: "invalid new parameters",
+		},
+		{
+			name: "No cmdline.txt",
+			kcmdline: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+			},
+			expectErr: "cmdline.txt not found",
+		},
 		{
 			name: "Valid parameters",
 			kcmdline: model.KernelCmdline{
@@ -32,6 +54,10 @@ func TestUpdateRPi(t *testing.T) {
 					"irqaffinity=0",
 				},
 			},
+			firmware:   "console=serial0,115200 root=LABEL=writable nohz=off rootwait\n",
+			legacy:     "root=/dev/mmcblk0p2\n",
+			expectFile: "firmware",
+			expectCmd:  "console=serial0,115200 root=LABEL=writable nohz=on rootwait isolcpus=1-3 nohz_full=1-3 kthread_cpus=0 irqaffinity=0\n",
 			expectParts: []string{
 				"isolcpus=1-3",
 				"nohz=on",
@@ -40,14 +66,83 @@ func TestUpdateRPi(t *testing.T) {
 				"irqaffinity=0",
 			},
 		},
+		{
+			name: "Quoted values",
+			kcmdline: model.KernelCmdline{
+				Parameters: []string{"dyndbg=file foo.c +p"},
+			},
+			firmware:    `root=/dev/mmcblk0p2 dyndbg="module bar +p" rootwait` + "\n",
+			expectFile:  "firmware",
+			expectCmd:   `root=/dev/mmcblk0p2 dyndbg="file foo.c +p" rootwait` + "\n",
+			expectParts: []string{"Updated", "cmdline.txt"},
+		},
+		{
+			name: "Invalid value",
+			kcmdline: model.KernelCmdline{
+				Parameters: []string{`dyndbg=a"b`},
+			},
+			firmware:  "root=/dev/mmcblk0p2\n",
+			expectErr: "can't contain double quotes",
+		},
+		{
+			name: "Legacy boot partition",
+			kcmdline: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+			},
+			legacy:      "root=/dev/mmcblk0p2 rootwait\n",
+			expectFile:  "legacy",
+			expectCmd:   "root=/dev/mmcblk0p2 rootwait nohz=on\n",
+			expectParts: []string{"Updated", "cmdline.txt"},
+		},
+		{
+			name: "Command line too long",
+			kcmdline: model.KernelCmdline{
+				Parameters: []string{"foo=" + strings.Repeat("a", model.CommandLineSize())},
+			},
+			firmware:  "root=/dev/mmcblk0p2\n",
+			expectErr: "command line exceeds maximum length",
+		},
+		{
+			name: "Dry-run",
+			kcmdline: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+			},
+			firmware:    "root=/dev/mmcblk0p2\n",
+			dryRun:      true,
+			expectFile:  "firmware",
+			expectCmd:   "root=/dev/mmcblk0p2\n",
+			expectParts: []string{"Would update", "\troot=/dev/mmcblk0p2 nohz=on\n"},
+		},
 	}
 
+	savedFiles := rpiCmdlineFiles
+	t.Cleanup(func() { rpiCmdlineFiles = savedFiles })
+
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{
+				"firmware": filepath.Join(dir, "firmware-cmdline.txt"),
+				"legacy":   filepath.Join(dir, "cmdline.txt"),
+			}
+			rpiCmdlineFiles = []string{files["firmware"], files["legacy"]}
+			for name, content := range map[string]string{
+				"firmware": tc.firmware,
+				"legacy":   tc.legacy,
+			} {
+				if content == "" {
+					continue
+				}
+				if err := os.WriteFile(files[name], []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
 			cfg := &model.InternalConfig{
 				Data: model.Config{
 					KernelCmdline: tc.kcmdline,
 				},
+				DryRun: tc.dryRun,
 			}
 
 			result, err := UpdateRPi(cfg)
@@ -72,6 +167,60 @@ func TestUpdateRPi(t *testing.T) {
 					t.Errorf("expected result to contain %q, got %q", part, joined)
 				}
 			}
+
+			content, err := os.ReadFile(files[tc.expectFile])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tc.expectCmd {
+				t.Errorf("expected cmdline.txt %q, got %q", tc.expectCmd, string(content))
+			}
 		})
 	}
 }
+
+func TestUpdateAndResetRPi(t *testing.T) {
+	savedFiles := rpiCmdlineFiles
+	t.Cleanup(func() { rpiCmdlineFiles = savedFiles })
+
+	path := filepath.Join(t.TempDir(), "cmdline.txt")
+	rpiCmdlineFiles = []string{path}
+	original := "console=serial0,115200 nohz=off rootwait\n"
+	if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &model.InternalConfig{
+		Data: model.Config{
+			KernelCmdline: model.KernelCmdline{
+				Parameters: []string{"nohz=on", "isolcpus=1-3"},
+			},
+		},
+	}
+	if _, err := UpdateRPi(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Applying twice must not change anything
+	if _, err := UpdateRPi(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Nor lose the original content
+	bak, err := os.ReadFile(path + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bak) != original {
+		t.Errorf("expected %q in the backup, got %q", original, string(bak))
+	}
+
+	if _, err := ResetRPi(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != original {
+		t.Errorf("expected %q after reset, got %q", original, string(content))
+	}
+}
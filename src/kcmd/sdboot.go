@@ -0,0 +1,133 @@
+package kcmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/model"
+)
+
+// Command line sources used by kernel-install when /etc/kernel/cmdline is
+// missing, in order of precedence.
+// See: https://www.freedesktop.org/software/systemd/man/latest/kernel-install.html
+var (
+	usrLibKernelCmdline = "/usr/lib/kernel/cmdline"
+	procCmdline         = "/proc/cmdline"
+)
+
+// kernelInstallCmds returns the commands regenerating the boot loader
+// entries of the installed kernels, newest first, since the kernel to boot
+// next, e.g. the real-time one, may not be the running one. The commands
+// run on the target system, so they refer to systemBootDir.
+func kernelInstallCmds() []string {
+	versions, err := installedKernels(bootDir)
+	if err != nil || len(versions) == 0 {
+		return []string{"sudo kernel-install add <version> " + systemBootDir + "/vmlinuz-<version>"}
+	}
+	cmds := make([]string, len(versions))
+	for i, v := range versions {
+		cmds[i] = "sudo kernel-install add " + v + " " + systemBootDir + "/vmlinuz-" + v
+	}
+	return cmds
+}
+
+// UpdateSystemdBoot merges the kernel command line parameters into the
+// command line file read by kernel-install, keeping the other parameters.
+func UpdateSystemdBoot(cfg *model.InternalConfig) ([]string, error) {
+	if cfg.Data.KernelCmdline.IsEmpty() {
+		return nil, fmt.Errorf("no parameters to inject")
+	}
+
+	if err := cfg.Data.KernelCmdline.HasDuplicates(); err != nil {
+		return nil, fmt.Errorf("invalid new parameters: %v", err)
+	}
+
+	cmdlineFile := cfg.SystemdBootCfg.KernelCmdlineFile
+
+	current, err := os.ReadFile(cmdlineFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %v", cmdlineFile, err)
+	}
+
+	existing, err := sdbootCmdline(cmdlineFile)
+	if err != nil {
+		return nil, err
+	}
+
+	owned, err := readOwnedParams(cmdlineFile)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := cfg.Data.KernelCmdline.Tokens()
+	if err != nil {
+		return nil, fmt.Errorf("invalid new parameters: %v", err)
+	}
+	merged, newOwned := mergeOwnedParams(existing, owned, params,
+		cfg.Data.KernelCmdline.Remove)
+	if err := cfg.Data.KernelCmdline.ValidateCmdline(existing, merged); err != nil {
+		return nil, fmt.Errorf("error updating %s: %v", cmdlineFile, err)
+	}
+	content, err := renderCmdlineFile(merged)
+	if err != nil {
+		return nil, fmt.Errorf("error updating %s: %v", cmdlineFile, err)
+	}
+
+	if cfg.DryRun {
+		return DryRunConclusion("systemd-boot", cmdlineFile,
+			string(current), content), nil
+	}
+
+	if err := replaceOwnedFile(cmdlineFile, content, owned, newOwned,
+		writeCmdlineFile); err != nil {
+		return nil, err
+	}
+
+	return SystemdBootConclusion(cmdlineFile, strings.Join(params, " "),
+		removedParams(newOwned), kernelInstallCmds()), nil
+}
+
+// ResetSystemdBoot removes the kernel command line parameters set by rt-conf
+// from the kernel-install command line file, restoring the ones they replaced.
+func ResetSystemdBoot(cfg *model.InternalConfig) ([]string, error) {
+	return resetCmdlineFile("systemd-boot", cfg.SystemdBootCfg.KernelCmdlineFile,
+		cfg.DryRun, strings.Join(kernelInstallCmds(), "\n\t"))
+}
+
+// sdbootCmdline returns the command line kernel-install currently uses.
+// When there's no command line file yet, it falls back the same way
+// kernel-install does, so the existing parameters aren't lost.
+func sdbootCmdline(cmdlineFile string) ([]string, error) {
+	for _, path := range []string{cmdlineFile, usrLibKernelCmdline} {
+		params, err := readCmdlineFile(path)
+		if err == nil {
+			return params, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+	}
+
+	// The running kernel has nothing to do with an offline root filesystem
+	if offlineRoot != "" {
+		return nil, nil
+	}
+
+	params, err := readCmdlineFile(procCmdline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", procCmdline, err)
+	}
+
+	// Parameters set by the boot loader for the running kernel only
+	var filtered []string
+	for _, p := range params {
+		key := model.ParamKey(p)
+		if key == "BOOT_IMAGE" || key == "initrd" {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+	return filtered, nil
+}
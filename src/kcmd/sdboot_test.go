@@ -0,0 +1,194 @@
+package kcmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/canonical/rt-conf/src/model"
+)
+
+func TestUpdateSystemdBoot(t *testing.T) {
+	tests := []struct {
+		name      string
+		kcmd      model.KernelCmdline
+		etc       string
+		usrLib    string
+		proc      string
+		dryRun    bool
+		expected  string
+		expectErr string
+	}{
+		{
+			name:      "No params to inject",
+			expectErr: "no parameters to inject",
+		},
+		{
+			name: "Duplicate parameters with different values",
+			kcmd: model.KernelCmdline{
+				Parameters: []string{"nohz=on", "nohz=off"},
+			},
+			expectErr: "invalid new parameters",
+		},
+		{
+			name: "Merge into /etc/kernel/cmdline",
+			kcmd: model.KernelCmdline{
+				Parameters: []string{"nohz=on", "isolcpus=2-3"},
+			},
+			etc:      "root=UUID=1234 rw nohz=off\n",
+			usrLib:   "root=UUID=abcd\n",
+			expected: "root=UUID=1234 rw nohz=on isolcpus=2-3\n",
+		},
+		{
+			name: "Seed from /usr/lib/kernel/cmdline",
+			kcmd: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+			},
+			usrLib:   "root=UUID=abcd quiet\n",
+			proc:     "BOOT_IMAGE=/vmlinuz root=UUID=1234\n",
+			expected: "root=UUID=abcd quiet nohz=on\n",
+		},
+		{
+			name: "Seed from /proc/cmdline",
+			kcmd: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+			},
+			proc:     "BOOT_IMAGE=/vmlinuz initrd=\\initrd.img root=UUID=1234 quiet\n",
+			expected: "root=UUID=1234 quiet nohz=on\n",
+		},
+		{
+			name: "No command line source",
+			kcmd: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+			},
+			expectErr: "failed to read",
+		},
+		{
+			name: "Dry-run",
+			kcmd: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+			},
+			etc:      "root=UUID=1234\n",
+			dryRun:   true,
+			expected: "root=UUID=1234\n",
+		},
+	}
+
+	savedUsrLib, savedProc := usrLibKernelCmdline, procCmdline
+	t.Cleanup(func() {
+		usrLibKernelCmdline, procCmdline = savedUsrLib, savedProc
+	})
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			etcPath := filepath.Join(dir, "etc-cmdline")
+			usrLibKernelCmdline = filepath.Join(dir, "usr-lib-cmdline")
+			procCmdline = filepath.Join(dir, "proc-cmdline")
+			for path, content := range map[string]string{
+				etcPath:             tc.etc,
+				usrLibKernelCmdline: tc.usrLib,
+				procCmdline:         tc.proc,
+			} {
+				if content == "" {
+					continue
+				}
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			conf := &model.InternalConfig{
+				Data: model.Config{
+					KernelCmdline: tc.kcmd,
+				},
+				SystemdBootCfg: model.SystemdBoot{
+					KernelCmdlineFile: etcPath,
+				},
+				DryRun: tc.dryRun,
+			}
+
+			msgs, err := UpdateSystemdBoot(conf)
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(strings.Join(msgs, ""), "Bootloader: systemd-boot") {
+				t.Errorf("unexpected output: %v", msgs)
+			}
+
+			content, err := os.ReadFile(etcPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, string(content))
+			}
+		})
+	}
+}
+
+func TestResetSystemdBoot(t *testing.T) {
+	// No kernel found, the hint is generic
+	savedBootDir := bootDir
+	t.Cleanup(func() { bootDir = savedBootDir })
+	bootDir = t.TempDir()
+
+	path := filepath.Join(t.TempDir(), "cmdline")
+	if err := os.WriteFile(path, []byte("root=UUID=1234 nohz=on\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeOwnedParams(path, []ownedParam{{Param: "nohz=on"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &model.InternalConfig{
+		SystemdBootCfg: model.SystemdBoot{
+			KernelCmdlineFile: path,
+		},
+	}
+	msgs, err := ResetSystemdBoot(conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(msgs, ""), "sudo kernel-install add <version> /boot/vmlinuz-<version>") {
+		t.Errorf("expected kernel-install hint, got %v", msgs)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "root=UUID=1234\n" {
+		t.Errorf("unexpected content: %q", string(content))
+	}
+}
+
+func TestKernelInstallCmds(t *testing.T) {
+	// The commands run on the target system, even with --root
+	t.Cleanup(func() { SetRoot("") })
+	SetRoot(t.TempDir())
+	if err := os.MkdirAll(bootDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"6.8.0-45-generic", "6.8.0-100-realtime"} {
+		if err := os.WriteFile(filepath.Join(bootDir, "vmlinuz-"+v), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{
+		"sudo kernel-install add 6.8.0-100-realtime /boot/vmlinuz-6.8.0-100-realtime",
+		"sudo kernel-install add 6.8.0-45-generic /boot/vmlinuz-6.8.0-45-generic",
+	}
+	if cmds := kernelInstallCmds(); !reflect.DeepEqual(cmds, expected) {
+		t.Errorf("expected %q, got %q", expected, cmds)
+	}
+}
@@ -0,0 +1,208 @@
+package kcmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	confURL    = "http://localhost/v2/snaps/system/conf"
+	changesURL = "http://localhost/v2/changes/"
+	snapsURL   = "http://localhost/v2/snaps"
+
+	// defaultSnapdTimeout bounds the wait for a snapd change to complete
+	defaultSnapdTimeout = 5 * time.Minute
+)
+
+var (
+	snapdSocket = "/run/snapd.socket"
+
+	// changePollInterval is the delay between two polls of a snapd change
+	changePollInterval = 500 * time.Millisecond
+)
+
+type Result struct {
+	Msg string `json:"message"`
+	Knd string `json:"kind"`
+	Val string `json:"value"`
+}
+
+type SnapdResponse struct {
+	StatusCode int    `json:"status-code"`
+	Status     string `json:"status"`
+	Change     string `json:"change"`
+	Result     Result `json:"result"`
+	Type       string `json:"type"`
+
+	WarningCount     int       `json:"warning-count"`
+	WarningTimestamp time.Time `json:"warning-timestamp"`
+
+	Maintenance Result `json:"maintenance"`
+}
+
+// snapdChange is the state of an asynchronous snapd operation
+type snapdChange struct {
+	ID      string      `json:"id"`
+	Kind    string      `json:"kind"`
+	Summary string      `json:"summary"`
+	Status  string      `json:"status"`
+	Ready   bool        `json:"ready"`
+	Err     string      `json:"err"`
+	Tasks   []snapdTask `json:"tasks"`
+}
+
+type snapdTask struct {
+	Summary string   `json:"summary"`
+	Status  string   `json:"status"`
+	Log     []string `json:"log"`
+}
+
+// snapdError is an error returned by the snapd API
+type snapdError struct {
+	Status  string
+	Message string
+	Kind    string
+}
+
+func (e *snapdError) Error() string {
+	return fmt.Sprintf("snapd error: %s, %s", e.Status, e.Message)
+}
+
+// createTransport returns an HTTP transport that connects over a Unix socket
+func createTransport() *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", snapdSocket)
+		},
+	}
+}
+
+// sendRequest sends a request to Snapd API and returns the response body
+var sendRequest = func(ctx context.Context, method, url string, payload []byte) (*http.Response, error) {
+	client := &http.Client{Transport: createTransport()}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %v", err)
+	}
+	return resp, nil
+}
+
+// snapdRequest sends a request to the snapd API and decodes its response.
+// The result of the response is decoded into result, when not nil.
+func snapdRequest(ctx context.Context, method, url string, payload []byte, result any) (*SnapdResponse, error) {
+	resp, err := sendRequest(ctx, method, url, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error communicating with snapd: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The result depends on the request, only decode it as requested
+	var raw struct {
+		SnapdResponse
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing snapd response: %s", err)
+	}
+	snapResp := raw.SnapdResponse
+
+	if snapResp.StatusCode >= 400 {
+		_ = json.Unmarshal(raw.Result, &snapResp.Result)
+		return nil, &snapdError{
+			Status:  snapResp.Status,
+			Message: snapResp.Result.Msg,
+			Kind:    snapResp.Result.Knd,
+		}
+	}
+
+	if result != nil && len(raw.Result) > 0 {
+		if err := json.Unmarshal(raw.Result, result); err != nil {
+			return nil, fmt.Errorf("error parsing snapd response: %s", err)
+		}
+	}
+	return &snapResp, nil
+}
+
+// waitChange polls the snapd change id until it is ready, or until snapd
+// waits for a system restart to complete it, which is reported as pending.
+func waitChange(ctx context.Context, id string) (rebootPending bool, err error) {
+	for {
+		var chg snapdChange
+		resp, err := snapdRequest(ctx, "GET", changesURL+id, nil, &chg)
+		if ctx.Err() != nil {
+			return false, fmt.Errorf("snapd change %s not ready: %v", id, ctx.Err())
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to get snapd change %s: %v", id, err)
+		}
+
+		if chg.Status == "Wait" || resp.Maintenance.Knd == "system-restart" {
+			return true, nil
+		}
+		if chg.Ready {
+			if chg.Status != "Done" {
+				return false, changeError(chg)
+			}
+			return false, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, fmt.Errorf("snapd change %s not ready: %v", id, ctx.Err())
+		case <-time.After(changePollInterval):
+		}
+	}
+}
+
+// changeError describes why a snapd change did not complete
+func changeError(chg snapdChange) error {
+	if chg.Err != "" {
+		return fmt.Errorf("snapd change %s failed: %s", chg.ID, chg.Err)
+	}
+
+	var failed []string
+	for _, t := range chg.Tasks {
+		if t.Status != "Error" {
+			continue
+		}
+		msg := "- " + t.Summary
+		if len(t.Log) > 0 {
+			msg += ": " + t.Log[len(t.Log)-1]
+		}
+		failed = append(failed, msg)
+	}
+	if len(failed) == 0 {
+		return fmt.Errorf("snapd change %s failed with status %s",
+			chg.ID, chg.Status)
+	}
+	return fmt.Errorf("snapd change %s failed:\n%s", chg.ID,
+		strings.Join(failed, "\n"))
+}
+
+// isSnapdErrorKind reports whether err is a snapd error of the given kind
+func isSnapdErrorKind(err error, kind string) bool {
+	var e *snapdError
+	return errors.As(err, &e) && e.Kind == kind
+}
@@ -0,0 +1,187 @@
+package kcmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/rt-conf/src/model"
+)
+
+// fakeSnapd serves the snapd API on a Unix socket, replying to the
+// requests of each method and URI with the given responses in turn.
+// It returns the requests received, along with their body.
+func fakeSnapd(t *testing.T, responses map[string][]string) *[]string {
+	t.Helper()
+
+	var requests []string
+	socket := filepath.Join(t.TempDir(), "snapd.socket")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", socket, err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			key := r.Method + " " + r.URL.RequestURI()
+			body, _ := io.ReadAll(r.Body)
+			requests = append(requests, strings.TrimSpace(key+" "+string(body)))
+
+			replies := responses[key]
+			if len(replies) == 0 {
+				t.Errorf("unexpected request %s", key)
+				http.Error(w, "unexpected request", http.StatusInternalServerError)
+				return
+			}
+			if len(replies) > 1 {
+				responses[key] = replies[1:]
+			}
+			fmt.Fprint(w, replies[0])
+		}))
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+
+	savedSocket := snapdSocket
+	savedInterval := changePollInterval
+	snapdSocket = socket
+	changePollInterval = time.Millisecond
+	t.Cleanup(func() {
+		srv.Close()
+		snapdSocket = savedSocket
+		changePollInterval = savedInterval
+	})
+	return &requests
+}
+
+const (
+	changeAccepted = `{"type": "async", "status-code": 202, "status": "Accepted", "change": "42"}`
+	changeDoing    = `{"type": "sync", "status-code": 200, "status": "OK",
+		"result": {"id": "42", "status": "Doing", "ready": false}}`
+	changeDone = `{"type": "sync", "status-code": 200, "status": "OK",
+		"result": {"id": "42", "status": "Done", "ready": true}}`
+)
+
+func TestUpdateUbuntuCoreChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		changes  []string
+		timeout  time.Duration
+		expected string
+		err      string
+	}{
+		{
+			name:     "Change done",
+			changes:  []string{changeDoing, changeDone},
+			expected: "Successfully applied the changes.",
+		},
+		{
+			name: "Change waiting for a restart",
+			changes: []string{changeDoing, `{"type": "sync", "status-code": 200, "status": "OK",
+				"result": {"id": "42", "status": "Wait", "ready": false}}`},
+			expected: "waiting for a system restart",
+		},
+		{
+			name: "System restart pending",
+			changes: []string{`{"type": "sync", "status-code": 200, "status": "OK",
+				"result": {"id": "42", "status": "Doing", "ready": false},
+				"maintenance": {"kind": "system-restart", "message": "system is restarting"}}`},
+			expected: "waiting for a system restart",
+		},
+		{
+			name: "Change error",
+			changes: []string{`{"type": "sync", "status-code": 200, "status": "OK",
+				"result": {"id": "42", "status": "Error", "ready": true,
+				"err": "cannot perform the following tasks:\n- Run configure hook (invalid option)"}}`},
+			err: "snapd change 42 failed: cannot perform the following tasks",
+		},
+		{
+			name: "Task error",
+			changes: []string{`{"type": "sync", "status-code": 200, "status": "OK",
+				"result": {"id": "42", "status": "Error", "ready": true, "tasks": [
+					{"summary": "Run configure hook", "status": "Error", "log": ["first", "invalid option"]},
+					{"summary": "Update kernel command line", "status": "Hold"}]}}`},
+			err: "- Run configure hook: invalid option",
+		},
+		{
+			name:    "Change not found",
+			changes: []string{`{"type": "error", "status-code": 404, "status": "Not Found", "result": {"message": "cannot find change"}}`},
+			err:     "snapd error: Not Found, cannot find change",
+		},
+		{
+			name:    "Timeout",
+			changes: []string{changeDoing},
+			timeout: 20 * time.Millisecond,
+			err:     "snapd change 42 not ready: context deadline exceeded",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tempUCOwnership(t)
+			responses := ucResponses("", "")
+			responses["PUT /v2/snaps/system/conf"] = []string{changeAccepted}
+			responses["GET /v2/changes/42"] = tc.changes
+			fakeSnapd(t, responses)
+
+			cfg := &model.InternalConfig{
+				Data: model.Config{
+					KernelCmdline: model.KernelCmdline{
+						Parameters: []string{"isolcpus=1-3"},
+					},
+				},
+				SnapdTimeout: tc.timeout,
+			}
+			msgs, err := UpdateUbuntuCore(cfg)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(strings.Join(msgs, ""), tc.expected) {
+				t.Errorf("expected output to contain %q, got %v", tc.expected, msgs)
+			}
+		})
+	}
+}
+
+func TestGetSystemConf(t *testing.T) {
+	fakeSnapd(t, map[string][]string{
+		"GET /v2/snaps/system/conf?keys=" + dangerousCmdlineKey: {
+			`{"type": "sync", "status-code": 200, "status": "OK",
+				"result": {"system.kernel.dangerous-cmdline-append": "nohz=on"}}`,
+			`{"type": "error", "status-code": 400, "status": "Bad Request",
+				"result": {"kind": "option-not-found", "message": "snap \"core\" has no \"system.kernel.dangerous-cmdline-append\" configuration option"}}`,
+		},
+	})
+
+	cfg := &model.InternalConfig{}
+	ctx, cancel := snapdContext(cfg)
+	defer cancel()
+
+	value, err := getSystemConf(ctx, dangerousCmdlineKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != "nohz=on" {
+		t.Errorf("expected %q, got %q", "nohz=on", value)
+	}
+
+	value, err = getSystemConf(ctx, dangerousCmdlineKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != "" {
+		t.Errorf("expected unset option to be empty, got %q", value)
+	}
+}
@@ -0,0 +1,244 @@
+package kcmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/model"
+	"github.com/canonical/rt-conf/src/system"
+	"github.com/canonical/rt-conf/src/utils"
+)
+
+// States of a kernel command line parameter, see ParamStatus
+const (
+	StatusActive        = "active"
+	StatusPendingReboot = "pending reboot"
+	StatusMissing       = "missing"
+	StatusOverridden    = "overridden"
+)
+
+// grubCfgFile is the GRUB configuration generated by update-grub
+var grubCfgFile = "/boot/grub/grub.cfg"
+
+// ParamStatus is the state of a kernel command line parameter on the
+// running system. Running is the value set on the running kernel, if any.
+type ParamStatus struct {
+	Param   string
+	State   string
+	Running string
+}
+
+// kcmdConfigured returns the kernel command line parameters configured in
+// the bootloader, along with notes on the bootloader configuration.
+var kcmdConfigured = map[system.SystemType]func(*model.InternalConfig) ([]string, []string, error){
+	system.Rpi:         rpiConfigured,
+	system.Grub:        grubConfigured,
+	system.Uboot:       ubootConfigured,
+	system.UbuntuCore:  ucConfigured,
+	system.SystemdBoot: sdbootConfigured,
+}
+
+// KcmdStatus compares the kernel command line parameters of the
+// configuration with the ones of the running kernel and the bootloader.
+func KcmdStatus(c *model.InternalConfig) ([]string, error) {
+	utils.PrintTitle("Kernel Command Line Parameters")
+	if len(c.Data.KernelCmdline.Parameters) == 0 {
+		return nil, fmt.Errorf("no kernel command line parameters in the configuration")
+	}
+
+	running, err := readCmdlineFile(procCmdline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", procCmdline, err)
+	}
+
+	sys, err := bootloader(c)
+	if err != nil {
+		return nil, err
+	}
+	configuredKcmd, ok := kcmdConfigured[sys]
+	if !ok {
+		return nil, fmt.Errorf("unsupported bootloader: %v", sys)
+	}
+	configured, notes, err := configuredKcmd(c)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := paramStatuses(c.Data.KernelCmdline.Parameters, running, configured)
+	return StatusConclusion(statuses, notes), nil
+}
+
+// ReplayStatus compares the kernel command line parameters of the
+// configuration with a captured kernel command line, as if the bootloader
+// was configured with them.
+func ReplayStatus(c *model.InternalConfig, cmdline string) []string {
+	utils.PrintTitle("Kernel Command Line Parameters")
+	params := c.Data.KernelCmdline.Parameters
+	if len(params) == 0 {
+		return nil
+	}
+	statuses := paramStatuses(params, model.SplitCmdline(cmdline), params)
+	return StatusConclusion(statuses, nil)
+}
+
+// paramStatuses returns the state of each parameter, given the parameters
+// of the running kernel and the ones configured in the bootloader.
+func paramStatuses(params, running, configured []string) []ParamStatus {
+	statuses := make([]ParamStatus, 0, len(params))
+	for _, p := range params {
+		r, isRunning := lastWithKey(running, p)
+		c, isConfigured := lastWithKey(configured, p)
+
+		status := ParamStatus{Param: p, Running: r}
+		switch {
+		case isRunning && r == p:
+			status.State = StatusActive
+		case isConfigured && c == p:
+			status.State = StatusPendingReboot
+		case isRunning:
+			status.State = StatusOverridden
+		default:
+			status.State = StatusMissing
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// lastWithKey returns the last parameter setting the same kernel parameter
+// as p, which is the one taking effect.
+func lastWithKey(params []string, p string) (string, bool) {
+	for i := len(params) - 1; i >= 0; i-- {
+		if model.SameKey(params[i], p) {
+			return params[i], true
+		}
+	}
+	return "", false
+}
+
+func rpiConfigured(_ *model.InternalConfig) ([]string, []string, error) {
+	cmdlineFile, err := findRpiCmdline()
+	if err != nil {
+		return nil, nil, err
+	}
+	params, err := readCmdlineFile(cmdlineFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %v", cmdlineFile, err)
+	}
+	return params, nil, nil
+}
+
+func sdbootConfigured(cfg *model.InternalConfig) ([]string, []string, error) {
+	params, err := sdbootCmdline(cfg.SystemdBootCfg.KernelCmdlineFile)
+	return params, nil, err
+}
+
+func ubootConfigured(cfg *model.InternalConfig) ([]string, []string, error) {
+	extlinuxFile := cfg.UbootCfg.ExtlinuxFile
+	content, err := os.ReadFile(extlinuxFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, []string{"No extlinux.conf found, the parameters of the boot " +
+			"script can't be verified."}, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %v", extlinuxFile, err)
+	}
+
+	params, err := defaultExtlinuxAppend(string(content))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading %s: %v", extlinuxFile, err)
+	}
+	return params, nil, nil
+}
+
+func ucConfigured(cfg *model.InternalConfig) ([]string, []string, error) {
+	ctx, cancel := snapdContext(cfg)
+	defer cancel()
+
+	cmdline, err := getUCCmdline(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return flattenUCCmdline(cmdline), nil, nil
+}
+
+// grubConfigured returns the parameters of the first kernel of grub.cfg.
+// It notes when the parameters of the drop-in are missing from grub.cfg,
+// which means update-grub wasn't run after rt-conf.
+func grubConfigured(cfg *model.InternalConfig) ([]string, []string, error) {
+	dropIn, err := readGrubDropIn(cfg.GrubCfg.GrubDropInFile)
+	if err == nil && dropIn == nil {
+		dropIn, err = readGrubDropIn(grubScriptFile)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params, err := readGrubCfgCmdline(grubCfgFile)
+	if err != nil {
+		return dropIn, []string{fmt.Sprintf(
+			"Failed to read %s, using %s instead: %v",
+			grubCfgFile, cfg.GrubCfg.GrubDropInFile, err)}, nil
+	}
+
+	for _, p := range dropIn {
+		if c, ok := lastWithKey(params, p); !ok || c != p {
+			return params, []string{
+				fmt.Sprintf("%s doesn't contain the parameters of %s.",
+					grubCfgFile, cfg.GrubCfg.GrubDropInFile),
+				"Please run 'sudo update-grub' to apply them.",
+			}, nil
+		}
+	}
+	return params, nil, nil
+}
+
+// readGrubDropIn reads the parameters of the drop-in written by rt-conf, or
+// of the real-time kernel entry script.
+func readGrubDropIn(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		value, ok := strings.CutPrefix(line, "GRUB_CMDLINE_LINUX_DEFAULT=")
+		if !ok {
+			// The real-time kernel entry script sets it apart
+			value, ok = strings.CutPrefix(line, "rt_conf_cmdline=")
+		}
+		if !ok {
+			continue
+		}
+		value = strings.TrimPrefix(value, "\"")
+		value = strings.TrimSuffix(value, "\"")
+		value = strings.TrimPrefix(value, "${GRUB_CMDLINE_LINUX_DEFAULT}")
+		value = strings.TrimPrefix(value, grubFilterDefault)
+		return model.SplitCmdline(shellUnescaper.Replace(value)), nil
+	}
+	return nil, nil
+}
+
+// readGrubCfgCmdline reads the parameters of the first linux command of a
+// generated grub.cfg, which belongs to the default menu entry.
+func readGrubCfgCmdline(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := model.SplitCmdline(line)
+		if len(fields) < 2 || (fields[0] != "linux" && fields[0] != "linuxefi") {
+			continue
+		}
+		// Skip the kernel image
+		return fields[2:], nil
+	}
+	return nil, fmt.Errorf("no linux command found")
+}
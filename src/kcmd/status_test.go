@@ -0,0 +1,186 @@
+package kcmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/canonical/rt-conf/src/model"
+	"github.com/canonical/rt-conf/src/system"
+)
+
+func TestParamStatuses(t *testing.T) {
+	params := []string{"nohz=on", "isolcpus=1-3", "nohz_full=1-3", "quiet", "rcu_nocbs=1-3", "irqaffinity=0"}
+	running := []string{"BOOT_IMAGE=/vmlinuz", "quiet", "nohz=on", "isolcpus=1", "isolcpus=2", "rcu-nocbs=1-3"}
+	configured := []string{"quiet", "nohz=on", "isolcpus=1-3", "nohz_full=1-3"}
+
+	expected := []ParamStatus{
+		{Param: "nohz=on", State: StatusActive, Running: "nohz=on"},
+		{Param: "isolcpus=1-3", State: StatusPendingReboot, Running: "isolcpus=2"},
+		{Param: "nohz_full=1-3", State: StatusPendingReboot},
+		{Param: "quiet", State: StatusActive, Running: "quiet"},
+		{Param: "rcu_nocbs=1-3", State: StatusOverridden, Running: "rcu-nocbs=1-3"},
+		{Param: "irqaffinity=0", State: StatusMissing},
+	}
+
+	statuses := paramStatuses(params, running, configured)
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected %+v, got %+v", expected, statuses)
+	}
+}
+
+const grubCfgSample = `
+menuentry 'Ubuntu' --class ubuntu $menuentry_id_option 'gnulinux-simple' {
+	recordfail
+	linux	/boot/vmlinuz-6.8.0-1008-realtime root=UUID=1234 ro  quiet splash nohz=on isolcpus=1-3 $vt_handoff
+	initrd	/boot/initrd.img-6.8.0-1008-realtime
+}
+submenu 'Advanced options for Ubuntu' $menuentry_id_option 'gnulinux-advanced' {
+	menuentry 'Ubuntu, with Linux 6.8.0-1008-realtime (recovery mode)' {
+		linux	/boot/vmlinuz-6.8.0-1008-realtime root=UUID=1234 ro recovery nomodeset
+	}
+}
+`
+
+func TestGrubConfigured(t *testing.T) {
+	tests := []struct {
+		name     string
+		dropIn   string
+		grubCfg  string
+		expected []string
+		notes    string
+	}{
+		{
+			name:     "update-grub was run",
+			dropIn:   grubDropIn(model.Grub{Cmdline: "nohz=on isolcpus=1-3"}),
+			grubCfg:  grubCfgSample,
+			expected: []string{"root=UUID=1234", "ro", "quiet", "splash", "nohz=on", "isolcpus=1-3", "$vt_handoff"},
+		},
+		{
+			name:     "update-grub was not run",
+			dropIn:   grubDropIn(model.Grub{Cmdline: "nohz=on isolcpus=2-3"}),
+			grubCfg:  grubCfgSample,
+			expected: []string{"root=UUID=1234", "ro", "quiet", "splash", "nohz=on", "isolcpus=1-3", "$vt_handoff"},
+			notes:    "Please run 'sudo update-grub'",
+		},
+		{
+			name:     "No grub.cfg",
+			dropIn:   grubDropIn(model.Grub{Cmdline: "nohz=on"}),
+			expected: []string{"nohz=on"},
+			notes:    "Failed to read",
+		},
+	}
+
+	savedGrubCfg := grubCfgFile
+	t.Cleanup(func() { grubCfgFile = savedGrubCfg })
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dropInPath := filepath.Join(dir, "60_rt-conf.cfg")
+			if err := os.WriteFile(dropInPath, []byte(tc.dropIn), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			grubCfgFile = filepath.Join(dir, "grub.cfg")
+			if tc.grubCfg != "" {
+				if err := os.WriteFile(grubCfgFile, []byte(tc.grubCfg), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg := &model.InternalConfig{
+				GrubCfg: model.Grub{GrubDropInFile: dropInPath},
+			}
+			params, notes, err := grubConfigured(cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(params, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, params)
+			}
+			joined := strings.Join(notes, "\n")
+			if tc.notes == "" && joined != "" {
+				t.Errorf("expected no notes, got %q", joined)
+			}
+			if !strings.Contains(joined, tc.notes) {
+				t.Errorf("expected notes to contain %q, got %q", tc.notes, joined)
+			}
+		})
+	}
+}
+
+func TestKcmdStatus(t *testing.T) {
+	savedDetect := system.DetectSystem
+	savedConfigured := kcmdConfigured
+	savedProcCmdline := procCmdline
+	t.Cleanup(func() {
+		system.DetectSystem = savedDetect
+		kcmdConfigured = savedConfigured
+		procCmdline = savedProcCmdline
+	})
+
+	procCmdline = filepath.Join(t.TempDir(), "cmdline")
+	if err := os.WriteFile(procCmdline, []byte("root=/dev/sda1 nohz=on\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	system.DetectSystem = func() (system.SystemType, error) {
+		return system.Rpi, nil
+	}
+	kcmdConfigured = map[system.SystemType]func(*model.InternalConfig) ([]string, []string, error){
+		system.Rpi: func(_ *model.InternalConfig) ([]string, []string, error) {
+			return []string{"nohz=on", "isolcpus=1-3"}, nil, nil
+		},
+	}
+
+	cfg := &model.InternalConfig{
+		Data: model.Config{
+			KernelCmdline: model.KernelCmdline{
+				Parameters: []string{"nohz=on", "isolcpus=1-3"},
+			},
+		},
+	}
+	msgs, err := KcmdStatus(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"\tnohz=on       active\n",
+		"\tisolcpus=1-3  pending reboot\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(strings.Join(msgs, ""), e) {
+			t.Errorf("expected output to contain %q, got %q", e, msgs)
+		}
+	}
+
+	_, err = KcmdStatus(&model.InternalConfig{})
+	if err == nil || !strings.Contains(err.Error(), "no kernel command line parameters") {
+		t.Errorf("expected error for empty configuration, got %v", err)
+	}
+}
+
+func TestReplayStatus(t *testing.T) {
+	cfg := &model.InternalConfig{
+		Data: model.Config{
+			KernelCmdline: model.KernelCmdline{
+				Parameters: []string{"nohz=on", "isolcpus=1-3"},
+			},
+		},
+	}
+	msgs := ReplayStatus(cfg, "root=/dev/sda1 nohz=on isolcpus=1\n")
+	expected := []string{
+		"\tnohz=on       active\n",
+		"\tisolcpus=1-3  pending reboot (running: isolcpus=1)\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(strings.Join(msgs, ""), e) {
+			t.Errorf("expected output to contain %q, got %q", e, msgs)
+		}
+	}
+
+	if msgs := ReplayStatus(&model.InternalConfig{}, "nohz=on"); msgs != nil {
+		t.Errorf("expected no output without parameters, got %q", msgs)
+	}
+}
@@ -0,0 +1,183 @@
+package kcmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/model"
+)
+
+// UpdateUboot merges the kernel command line parameters into the append lines
+// of extlinux.conf. When there is no extlinux.conf, the system boots from a
+// boot script, so it returns a bootargs snippet to be added to it instead.
+func UpdateUboot(cfg *model.InternalConfig) ([]string, error) {
+	if cfg.Data.KernelCmdline.IsEmpty() {
+		return nil, fmt.Errorf("no parameters to inject")
+	}
+
+	if err := cfg.Data.KernelCmdline.HasDuplicates(); err != nil {
+		return nil, fmt.Errorf("invalid new parameters: %v", err)
+	}
+
+	params, err := cfg.Data.KernelCmdline.Tokens()
+	if err != nil {
+		return nil, fmt.Errorf("invalid new parameters: %v", err)
+	}
+	cmdline := strings.Join(params, " ")
+	extlinuxFile := cfg.UbootCfg.ExtlinuxFile
+
+	content, err := os.ReadFile(extlinuxFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if len(cfg.Data.KernelCmdline.Remove) > 0 {
+			log.Printf("Warning: Parameters can't be removed from the bootargs " +
+				"of a boot script, only appended")
+		}
+		return UbootScriptConclusion(cmdline), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", extlinuxFile, err)
+	}
+
+	owned, err := readOwnedParams(extlinuxFile)
+	if err != nil {
+		return nil, err
+	}
+
+	remove := cfg.Data.KernelCmdline.Remove
+	updated, newOwned, err := mergeExtlinuxAppend(string(content), owned, params, remove)
+	if err != nil {
+		return nil, fmt.Errorf("error updating %s: %v", extlinuxFile, err)
+	}
+
+	existing, err := defaultExtlinuxAppend(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", extlinuxFile, err)
+	}
+	merged, err := defaultExtlinuxAppend(updated)
+	if err != nil {
+		return nil, fmt.Errorf("error updating %s: %v", extlinuxFile, err)
+	}
+	if err := cfg.Data.KernelCmdline.ValidateCmdline(existing, merged); err != nil {
+		return nil, fmt.Errorf("error updating %s: %v", extlinuxFile, err)
+	}
+
+	if cfg.DryRun {
+		return DryRunConclusion("U-Boot", extlinuxFile,
+			string(content), updated), nil
+	}
+
+	if err := replaceOwnedFile(extlinuxFile, updated, owned, newOwned,
+		writeExtlinux); err != nil {
+		return nil, err
+	}
+
+	return UbootConclusion(extlinuxFile, cmdline,
+		removedParams(scopedParams(newOwned, "0"))), nil
+}
+
+// ResetUboot removes the kernel command line parameters set by rt-conf
+// from the append lines of extlinux.conf, restoring the ones they replaced.
+func ResetUboot(cfg *model.InternalConfig) ([]string, error) {
+	extlinuxFile := cfg.UbootCfg.ExtlinuxFile
+	owned, err := readOwnedParams(extlinuxFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(owned) == 0 {
+		return NothingToResetConclusion("U-Boot"), nil
+	}
+
+	content, err := os.ReadFile(extlinuxFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", extlinuxFile, err)
+	}
+
+	updated, err := editExtlinuxAppend(string(content), func(n int, args []string) []string {
+		return undoOwnedParams(args, scopedParams(owned, strconv.Itoa(n)))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating %s: %v", extlinuxFile, err)
+	}
+
+	if cfg.DryRun {
+		return DryRunConclusion("U-Boot", extlinuxFile,
+			string(content), updated), nil
+	}
+
+	if err := replaceOwnedFile(extlinuxFile, updated, owned, nil,
+		writeExtlinux); err != nil {
+		return nil, err
+	}
+
+	return ResetConclusion("U-Boot", extlinuxFile, ""), nil
+}
+
+// mergeExtlinuxAppend merges params into every append line of an
+// extlinux.conf, undoing the changes of a previous run first and dropping
+// the parameters matching remove. It returns the updated content and the new
+// ownership records, scoped to each append line.
+func mergeExtlinuxAppend(content string, owned []ownedParam, params, remove []string) (string, []ownedParam, error) {
+	var newOwned []ownedParam
+	updated, err := editExtlinuxAppend(content, func(n int, args []string) []string {
+		merged, lineOwned := mergeOwnedParams(args, scopedParams(owned, strconv.Itoa(n)), params, remove)
+		for _, o := range lineOwned {
+			o.Scope = strconv.Itoa(n)
+			newOwned = append(newOwned, o)
+		}
+		return merged
+	})
+	return updated, newOwned, err
+}
+
+// defaultExtlinuxAppend returns the parameters of the first append line of
+// an extlinux.conf, which belongs to the default label.
+func defaultExtlinuxAppend(content string) ([]string, error) {
+	var params []string
+	_, err := editExtlinuxAppend(content, func(n int, args []string) []string {
+		if n == 0 {
+			params = args
+		}
+		return args
+	})
+	return params, err
+}
+
+// editExtlinuxAppend replaces the parameters of every append line of an
+// extlinux.conf with the result of edit, which is given the line ordinal.
+func editExtlinuxAppend(content string, edit func(int, []string) []string) (string, error) {
+	lines := strings.Split(content, "\n")
+	n := 0
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		keyword, args := trimmed, ""
+		if idx := strings.IndexAny(trimmed, " \t"); idx != -1 {
+			keyword, args = trimmed[:idx], trimmed[idx+1:]
+		}
+		// extlinux keywords are case insensitive
+		if !strings.EqualFold(keyword, "append") {
+			continue
+		}
+		edited := strings.Join(edit(n, model.SplitCmdline(args)), " ")
+		n++
+		if len(edited) > model.CommandLineSize()-1 {
+			return "", fmt.Errorf(
+				"command line exceeds maximum length of %d bytes on %s",
+				model.CommandLineSize(), model.TargetArch())
+		}
+		indent := line[:len(line)-len(trimmed)]
+		lines[i] = indent + keyword + " " + edited
+	}
+
+	if n == 0 {
+		return "", fmt.Errorf("no append line found")
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// writeExtlinux replaces the content of extlinux.conf, keeping a copy of
+// the previous content in a backup file.
+var writeExtlinux = writeCmdlineFile
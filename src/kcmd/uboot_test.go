@@ -0,0 +1,241 @@
+package kcmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/canonical/rt-conf/src/model"
+)
+
+const extlinuxSample = `default l0
+menu title U-Boot menu
+prompt 0
+timeout 50
+
+label l0
+	menu label Ubuntu
+	linux /boot/vmlinuz
+	initrd /boot/initrd.img
+	append root=/dev/mmcblk0p2 rw nohz=off
+
+label l0r
+	menu label Ubuntu (rescue target)
+	linux /boot/vmlinuz
+	initrd /boot/initrd.img
+	APPEND root=/dev/mmcblk0p2 rw single
+`
+
+func TestMergeExtlinuxAppend(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		params    []string
+		expected  []string
+		expectErr string
+	}{
+		{
+			name:    "Merge into all append lines",
+			content: extlinuxSample,
+			params:  []string{"nohz=on", "isolcpus=1-3"},
+			expected: []string{
+				"\tappend root=/dev/mmcblk0p2 rw nohz=on isolcpus=1-3\n",
+				"\tAPPEND root=/dev/mmcblk0p2 rw single nohz=on isolcpus=1-3\n",
+			},
+		},
+		{
+			name:      "No append line",
+			content:   "label l0\n\tlinux /boot/vmlinuz\n",
+			params:    []string{"nohz=on"},
+			expectErr: "no append line found",
+		},
+		{
+			name:      "Command line too long",
+			content:   "append root=/dev/sda1\n",
+			params:    []string{"foo=" + strings.Repeat("a", model.CommandLineSize())},
+			expectErr: "command line exceeds maximum length",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, _, err := mergeExtlinuxAppend(tc.content, nil, tc.params, nil)
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, line := range tc.expected {
+				if !strings.Contains(got, line) {
+					t.Errorf("expected %q in:\n%s", line, got)
+				}
+			}
+			if strings.Contains(got, "nohz=off") {
+				t.Errorf("expected nohz=off to be replaced, got:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestUpdateUboot(t *testing.T) {
+	tests := []struct {
+		name         string
+		kcmd         model.KernelCmdline
+		extlinux     string
+		writeErr     error
+		expectErr    string
+		expectOutput string
+	}{
+		{
+			name:      "No params to inject",
+			kcmd:      model.KernelCmdline{},
+			expectErr: "no parameters to inject",
+		},
+		{
+			name: "Duplicate parameters with different values",
+			kcmd: model.KernelCmdline{
+				Parameters: []string{"nohz=on", "nohz=off"},
+			},
+			extlinux:  extlinuxSample,
+			expectErr: "invalid new parameters",
+		},
+		{
+			name: "No extlinux.conf",
+			kcmd: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+			},
+			expectOutput: `setenv bootargs "${bootargs} nohz=on"`,
+		},
+		{
+			name: "Write fails",
+			kcmd: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+			},
+			extlinux:  extlinuxSample,
+			writeErr:  fmt.Errorf("mock write failure"),
+			expectErr: "error updating",
+		},
+		{
+			name: "Success",
+			kcmd: model.KernelCmdline{
+				Parameters: []string{"nohz=on"},
+			},
+			extlinux:     extlinuxSample,
+			expectOutput: "Bootloader: U-Boot",
+		},
+	}
+
+	savedWriteExtlinux := writeExtlinux
+	t.Cleanup(func() { writeExtlinux = savedWriteExtlinux })
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			extlinuxPath := filepath.Join(t.TempDir(), "extlinux.conf")
+			if tc.extlinux != "" {
+				if err := os.WriteFile(extlinuxPath, []byte(tc.extlinux), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			writeExtlinux = func(_, _ string) error {
+				return tc.writeErr
+			}
+
+			conf := &model.InternalConfig{
+				Data: model.Config{
+					KernelCmdline: tc.kcmd,
+				},
+				UbootCfg: model.Uboot{
+					ExtlinuxFile: extlinuxPath,
+				},
+			}
+
+			msgs, err := UpdateUboot(conf)
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected error: %q, got: %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(strings.Join(msgs, ""), tc.expectOutput) {
+				t.Fatalf("expected output to contain %q, got %v", tc.expectOutput, msgs)
+			}
+		})
+	}
+}
+
+func TestWriteExtlinux(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "extlinux.conf")
+	if err := os.WriteFile(path, []byte(extlinuxSample), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeExtlinux(path, "append nohz=on\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("expected permissions to be kept, got %v", fi.Mode().Perm())
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "append nohz=on\n" {
+		t.Errorf("unexpected content: %q", string(content))
+	}
+}
+
+func TestUpdateAndResetUboot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "extlinux.conf")
+	if err := os.WriteFile(path, []byte(extlinuxSample), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &model.InternalConfig{
+		Data: model.Config{
+			KernelCmdline: model.KernelCmdline{
+				Parameters: []string{"nohz=on", "isolcpus=1-3"},
+			},
+		},
+		UbootCfg: model.Uboot{
+			ExtlinuxFile: path,
+		},
+	}
+
+	msgs, err := ResetUboot(conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(msgs, ""), "nothing to reset") {
+		t.Errorf("expected nothing to reset, got %v", msgs)
+	}
+
+	if _, err := UpdateUboot(conf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ResetUboot(conf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != extlinuxSample {
+		t.Errorf("expected original content after reset, got:\n%s", string(content))
+	}
+}
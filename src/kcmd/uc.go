@@ -1,108 +1,413 @@
 package kcmd
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net"
-	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
-	"time"
 
+	"github.com/canonical/go-snapctl/env"
 	"github.com/canonical/rt-conf/src/model"
+	"go.yaml.in/yaml/v4"
 )
 
 const (
-	snapdSocket = "/run/snapd.socket"
-	confURL     = "http://localhost/v2/snaps/system/conf"
-	jsonbody    = `
-{
-    "system":{
-        "kernel":{
-            "dangerous-cmdline-append":"%s"
-        }
-    }
-}`
+	cmdlineKey          = "system.kernel.cmdline-append"
+	dangerousCmdlineKey = "system.kernel.dangerous-cmdline-append"
+
+	ucBootloader = "Ubuntu Core managed"
 )
 
-type Result struct {
-	Msg string `json:"message"`
-	Knd string `json:"kind"`
-	Val string `json:"value"`
-}
+// ucCmdlineKeys are the system options appending to the kernel command line.
+// Parameters go to the first one when the gadget allows them.
+var ucCmdlineKeys = []string{cmdlineKey, dangerousCmdlineKey}
 
-type SnapdResponse struct {
-	StatusCode int    `json:"status-code"`
-	Status     string `json:"status"`
-	Change     string `json:"change"`
-	Result     Result `json:"result"`
-	Type       string `json:"type"`
+var (
+	// snapMountDir is where the gadget snap is mounted
+	snapMountDir = "/snap"
 
-	WarningCount     int       `json:"warning-count"`
-	WarningTimestamp time.Time `json:"warning-timestamp"`
+	// ucOwnershipPath is used to record the parameters set by rt-conf in the
+	// system options, see ownershipFile
+	ucOwnershipPath = filepath.Join(stateDir(), "ubuntu-core-cmdline")
+)
 
-	Maintenance Result `json:"maintenance"`
+// stateDir returns the directory where rt-conf keeps its state.
+func stateDir() string {
+	if dir := env.SnapData(); dir != "" {
+		return dir
+	}
+	return "/var/lib/rt-conf"
 }
 
-// createTransport returns an HTTP transport that connects over a Unix socket
-func createTransport() *http.Transport {
-	return &http.Transport{
-		DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-			return net.Dial("unix", snapdSocket)
-		},
-	}
+// cmdlineChanges reports how the kernel command line parameters compare to
+// the ones already set.
+type cmdlineChanges struct {
+	Added     []string
+	Changed   []string
+	Removed   []string
+	Unchanged []string
 }
 
-// sendRequest sends a request to Snapd API and returns the response body
-var sendRequest = func(method, url string, payload []byte) (*http.Response, error) {
-	client := &http.Client{Transport: createTransport()}
+// UpdateUbuntuCore merges the kernel command line parameters into the ones
+// set via the snapd system options. Parameters allowed by the gadget go to
+// system.kernel.cmdline-append, the others to
+// system.kernel.dangerous-cmdline-append.
+func UpdateUbuntuCore(cfg *model.InternalConfig) ([]string, error) {
+	if cfg.Data.KernelCmdline.IsEmpty() {
+		return nil, fmt.Errorf("no parameters to inject")
+	}
+	params, err := cfg.Data.KernelCmdline.Tokens()
+	if err != nil {
+		return nil, fmt.Errorf("invalid new parameters: %v", err)
+	}
 
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(payload))
+	ctx, cancel := snapdContext(cfg)
+	defer cancel()
+
+	current, err := getUCCmdline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	owned, err := readOwnedParams(ucOwnershipPath)
+	if err != nil {
+		return nil, err
+	}
+	allow, err := gadgetAllowList(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+		log.Printf("Warning: failed to read the kernel command line "+
+			"allowed by the gadget, using %s: %v", dangerousCmdlineKey, err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	remove := cfg.Data.KernelCmdline.Remove
+	updated, newOwned := mergeUCCmdline(current, owned, params, remove, allow)
+	changes := compareCmdline(current, params, remove)
+	if err := cfg.Data.KernelCmdline.ValidateCmdline(
+		flattenUCCmdline(current), flattenUCCmdline(updated)); err != nil {
+		return nil, err
+	}
 
-	resp, err := client.Do(req)
+	if cfg.DryRun {
+		return append(DryRunConclusion(ucBootloader, "the system options",
+			formatUCCmdline(current), formatUCCmdline(updated)),
+			CmdlineChangesConclusion(changes.Added, changes.Changed,
+				changes.Removed, changes.Unchanged)...), nil
+	}
+
+	rebootPending, applied, err := setUCCmdline(ctx, current, updated)
 	if err != nil {
-		return nil, fmt.Errorf("error making request: %v", err)
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ucOwnershipPath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v",
+			filepath.Dir(ucOwnershipPath), err)
+	}
+	if err := writeOwnedParams(ucOwnershipPath, newOwned); err != nil {
+		return nil, err
 	}
-	return resp, nil
+
+	if !applied {
+		return UbuntuCoreUpToDateConclusion(changes.Unchanged), nil
+	}
+	log.Println("Appended kernel cmdline: ", strings.Join(params, " "))
+
+	return UbuntuCoreConclusion(changes.Added, changes.Changed,
+		changes.Removed, changes.Unchanged, rebootPending), nil
 }
 
-func UpdateUbuntuCore(cfg *model.InternalConfig) ([]string, error) {
-	if len(cfg.Data.KernelCmdline.Parameters) == 0 {
-		return nil, fmt.Errorf("no parameters to inject")
+// ResetUbuntuCore removes the kernel command line parameters set by rt-conf
+// from the snapd system options, restoring the ones they replaced. Without
+// a record of them, as left by older versions of rt-conf, it clears
+// system.kernel.dangerous-cmdline-append instead.
+func ResetUbuntuCore(cfg *model.InternalConfig) ([]string, error) {
+	owned, err := readOwnedParams(ucOwnershipPath)
+	if err != nil {
+		return nil, err
 	}
-	kcmds := strings.Join(cfg.Data.KernelCmdline.Parameters, " ")
 
-	b := []byte(fmt.Sprintf(jsonbody, kcmds))
-	resp, err := sendRequest("PUT", confURL, b)
+	ctx, cancel := snapdContext(cfg)
+	defer cancel()
+
+	if len(owned) == 0 {
+		return resetUCDangerousCmdline(ctx, cfg)
+	}
+
+	current, err := getUCCmdline(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error communicating with snapd: %s", err)
+		return nil, err
+	}
+
+	updated := make(map[string][]string, len(ucCmdlineKeys))
+	for _, key := range ucCmdlineKeys {
+		updated[key] = undoOwnedParams(current[key], scopedParams(owned, key))
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	if cfg.DryRun {
+		return DryRunConclusion(ucBootloader, "the system options",
+			formatUCCmdline(current), formatUCCmdline(updated)), nil
+	}
+
+	if _, _, err := setUCCmdline(ctx, current, updated); err != nil {
+		return nil, err
+	}
+	if err := writeOwnedParams(ucOwnershipPath, nil); err != nil {
+		return nil, err
+	}
+
+	return ResetConclusion(ucBootloader, "the system options", ""), nil
+}
+
+// resetUCDangerousCmdline clears system.kernel.dangerous-cmdline-append,
+// where rt-conf set the parameters before recording them.
+func resetUCDangerousCmdline(ctx context.Context, cfg *model.InternalConfig) ([]string, error) {
+	current, err := getSystemConf(ctx, dangerousCmdlineKey)
 	if err != nil {
 		return nil, err
 	}
+	if current == "" {
+		return NothingToResetConclusion(ucBootloader), nil
+	}
 
-	var snapResp SnapdResponse
-	if err := json.Unmarshal(body, &snapResp); err != nil {
-		return nil, fmt.Errorf("error parsing snapd response: %s", err)
+	if cfg.DryRun {
+		return DryRunConclusion(ucBootloader, dangerousCmdlineKey,
+			current, ""), nil
 	}
 
-	if snapResp.StatusCode >= 400 {
-		return nil, fmt.Errorf("snapd error: %s, %s", snapResp.Status,
-			snapResp.Result.Msg)
+	log.Printf("Warning: no record of the parameters set by rt-conf in %s, "+
+		"clearing %s", ownershipFile(ucOwnershipPath), dangerousCmdlineKey)
+	if _, err := setSystemConf(ctx, map[string]string{dangerousCmdlineKey: ""}); err != nil {
+		return nil, err
 	}
 
-	log.Println("Appended kernel cmdline: ", kcmds)
+	return ResetConclusion(ucBootloader, dangerousCmdlineKey, ""), nil
+}
 
-	return UbuntuCoreConclusion(), nil
+// mergeUCCmdline merges params into the kernel command line system options,
+// undoing the changes of a previous run first. Parameters moving from one
+// option to the other are removed from the option they were in, as well as
+// the ones matching remove. It returns the merged options and the new
+// ownership records.
+func mergeUCCmdline(current map[string][]string, owned []ownedParam,
+	params, remove, allow []string,
+) (map[string][]string, []ownedParam) {
+	targets := make(map[string][]string, len(ucCmdlineKeys))
+	for _, p := range params {
+		key := dangerousCmdlineKey
+		if gadgetAllows(allow, p) {
+			key = cmdlineKey
+		}
+		targets[key] = append(targets[key], p)
+	}
+
+	updated := make(map[string][]string, len(ucCmdlineKeys))
+	var newOwned []ownedParam
+	for _, key := range ucCmdlineKeys {
+		base := undoOwnedParams(current[key], scopedParams(owned, key))
+
+		var kept, removed []string
+		for _, b := range base {
+			if (hasKey(params, b) && !hasKey(targets[key], b)) || model.RemovedBy(remove, b) {
+				removed = append(removed, b)
+				continue
+			}
+			kept = append(kept, b)
+		}
+
+		merged, keyOwned := mergeOwnedParams(kept, nil, targets[key], nil)
+		for _, r := range removed {
+			keyOwned = append(keyOwned, ownedParam{Replaced: r})
+		}
+		for _, o := range keyOwned {
+			o.Scope = key
+			newOwned = append(newOwned, o)
+		}
+		updated[key] = merged
+	}
+	return updated, newOwned
+}
+
+// hasKey reports whether params sets the same kernel parameter as p.
+func hasKey(params []string, p string) bool {
+	for _, q := range params {
+		if model.SameKey(p, q) {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenUCCmdline returns the parameters of all the kernel command line
+// system options, in the order they are appended.
+func flattenUCCmdline(cmdline map[string][]string) []string {
+	var all []string
+	for _, key := range ucCmdlineKeys {
+		all = append(all, cmdline[key]...)
+	}
+	return all
+}
+
+// compareCmdline compares params and the parameters to remove to the
+// parameters currently set.
+func compareCmdline(current map[string][]string, params, remove []string) cmdlineChanges {
+	all := flattenUCCmdline(current)
+
+	var changes cmdlineChanges
+	for _, c := range all {
+		if model.RemovedBy(remove, c) {
+			changes.Removed = append(changes.Removed, c)
+		}
+	}
+	for _, p := range params {
+		var previous []string
+		for _, c := range all {
+			if model.SameKey(c, p) {
+				previous = append(previous, c)
+			}
+		}
+		switch {
+		case len(previous) == 0:
+			changes.Added = append(changes.Added, p)
+		case len(previous) == 1 && previous[0] == p:
+			changes.Unchanged = append(changes.Unchanged, p)
+		default:
+			changes.Changed = append(changes.Changed,
+				strings.Join(previous, " ")+" -> "+p)
+		}
+	}
+	return changes
+}
+
+// formatUCCmdline renders the kernel command line system options.
+func formatUCCmdline(cmdline map[string][]string) string {
+	var sb strings.Builder
+	for _, key := range ucCmdlineKeys {
+		sb.WriteString(key + "=" + strings.Join(cmdline[key], " ") + "\n")
+	}
+	return sb.String()
+}
+
+// getUCCmdline reads the parameters of the kernel command line system options.
+func getUCCmdline(ctx context.Context) (map[string][]string, error) {
+	cmdline := make(map[string][]string, len(ucCmdlineKeys))
+	for _, key := range ucCmdlineKeys {
+		value, err := getSystemConf(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		cmdline[key] = model.SplitCmdline(value)
+	}
+	return cmdline, nil
+}
+
+// setUCCmdline sets the kernel command line system options that differ from
+// current and waits for snapd to apply them. It reports whether snapd is
+// waiting for a system restart and whether anything was changed.
+func setUCCmdline(ctx context.Context, current, updated map[string][]string) (rebootPending, applied bool, err error) {
+	values := map[string]string{}
+	for _, key := range ucCmdlineKeys {
+		value := strings.Join(updated[key], " ")
+		if value != strings.Join(current[key], " ") {
+			values[key] = value
+		}
+	}
+	if len(values) == 0 {
+		return false, false, nil
+	}
+
+	rebootPending, err = setSystemConf(ctx, values)
+	return rebootPending, true, err
+}
+
+// snapdContext returns the context bounding the requests to snapd.
+func snapdContext(cfg *model.InternalConfig) (context.Context, context.CancelFunc) {
+	timeout := cfg.SnapdTimeout
+	if timeout <= 0 {
+		timeout = defaultSnapdTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// setSystemConf sets system configuration options and waits for snapd to
+// apply them. It reports whether snapd is waiting for a system restart to
+// complete the change.
+func setSystemConf(ctx context.Context, values map[string]string) (bool, error) {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return false, fmt.Errorf("error encoding snapd request: %v", err)
+	}
+	resp, err := snapdRequest(ctx, "PUT", confURL, b, nil)
+	if err != nil {
+		return false, err
+	}
+	if resp.Change == "" {
+		return false, nil
+	}
+	return waitChange(ctx, resp.Change)
+}
+
+// getSystemConf reads a string system configuration option through the
+// snapd API. Unset options are reported as empty.
+func getSystemConf(ctx context.Context, key string) (string, error) {
+	conf := map[string]any{}
+	_, err := snapdRequest(ctx, "GET", confURL+"?keys="+key, nil, &conf)
+	if isSnapdErrorKind(err, "option-not-found") {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	value, _ := conf[key].(string)
+	return value, nil
+}
+
+// gadgetAllowList returns the kernel command line parameters allowed by the
+// gadget snap, listed under kernel-cmdline.allow in its gadget.yaml.
+func gadgetAllowList(ctx context.Context) ([]string, error) {
+	var snaps []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	if _, err := snapdRequest(ctx, "GET", snapsURL, nil, &snaps); err != nil {
+		return nil, err
+	}
+
+	for _, snap := range snaps {
+		if snap.Type != "gadget" {
+			continue
+		}
+		path := filepath.Join(snapMountDir, snap.Name, "current", "meta", "gadget.yaml")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		var gadget struct {
+			KernelCmdline struct {
+				Allow []string `yaml:"allow"`
+			} `yaml:"kernel-cmdline"`
+		}
+		if err := yaml.Unmarshal(data, &gadget); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		return gadget.KernelCmdline.Allow, nil
+	}
+	return nil, nil
+}
+
+// gadgetAllows reports whether the kernel parameter p is allowed by one of
+// the gadget entries, which are either "param", "param=value" or "param=*".
+func gadgetAllows(allow []string, p string) bool {
+	key, value, hasValue := strings.Cut(model.UnquoteParam(p), "=")
+	for _, a := range allow {
+		aKey, aValue, aHasValue := strings.Cut(model.UnquoteParam(a), "=")
+		if aKey != key || aHasValue != hasValue {
+			continue
+		}
+		if !hasValue || aValue == "*" || aValue == value {
+			return true
+		}
+	}
+	return false
 }
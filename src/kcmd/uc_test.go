@@ -1,9 +1,16 @@
 package kcmd
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
@@ -11,6 +18,9 @@ import (
 )
 
 func TestUpdateUbuntuCore(t *testing.T) {
+	savedSendRequest := sendRequest
+	t.Cleanup(func() { sendRequest = savedSendRequest })
+
 	tests := []struct {
 		name     string
 		cfg      model.InternalConfig
@@ -109,11 +119,21 @@ func TestUpdateUbuntuCore(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			sendRequest = func(_, _ string, _ []byte) (*http.Response, error) {
+			tempUCOwnership(t)
+
+			var body []byte
+			if tc.mockResp != nil {
+				body, _ = io.ReadAll(tc.mockResp.Body)
+			}
+			// Every request to snapd gets the same response
+			sendRequest = func(_ context.Context, _, _ string, _ []byte) (*http.Response, error) {
 				if tc.mockErr != nil {
 					return nil, tc.mockErr
 				}
-				return tc.mockResp, nil
+				return &http.Response{
+					StatusCode: tc.mockResp.StatusCode,
+					Body:       io.NopCloser(bytes.NewReader(body)),
+				}, nil
 			}
 
 			msgs, err := UpdateUbuntuCore(&tc.cfg)
@@ -143,3 +163,451 @@ func TestUpdateUbuntuCore(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateUbuntuCoreDryRun(t *testing.T) {
+	savedSendRequest := sendRequest
+	t.Cleanup(func() { sendRequest = savedSendRequest })
+
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		expected []string
+		err      string
+	}{
+		{
+			name:   "Current value is reported",
+			status: 200,
+			body: `{
+				"status": "OK",
+				"status-code": 200,
+				"result": {"system.kernel.dangerous-cmdline-append": "nohz=off"}
+			}`,
+			expected: []string{
+				"Would update the system options",
+				"\t" + dangerousCmdlineKey + "=nohz=off\n",
+				"\t" + dangerousCmdlineKey + "=nohz=off isolcpus=1-3\n",
+				"Added:\n\tisolcpus=1-3\n",
+			},
+		},
+		{
+			name:   "Unset option",
+			status: 400,
+			body: `{
+				"status": "Bad Request",
+				"status-code": 400,
+				"result": {"message": "no option", "kind": "option-not-found"}
+			}`,
+			expected: []string{
+				"\t" + dangerousCmdlineKey + "=\n",
+				"\t" + dangerousCmdlineKey + "=isolcpus=1-3\n",
+			},
+		},
+		{
+			name:   "Snapd error",
+			status: 500,
+			body: `{
+				"status": "Internal Server Error",
+				"status-code": 500,
+				"result": {"message": "boom"}
+			}`,
+			err: "snapd error: Internal Server Error, boom",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tempUCOwnership(t)
+			sendRequest = func(_ context.Context, method, url string, _ []byte) (*http.Response, error) {
+				if method != "GET" {
+					t.Fatalf("dry-run must only read, got %s %s", method, url)
+				}
+				return &http.Response{
+					StatusCode: tc.status,
+					Body:       io.NopCloser(strings.NewReader(tc.body)),
+				}, nil
+			}
+
+			cfg := model.InternalConfig{
+				Data: model.Config{
+					KernelCmdline: model.KernelCmdline{
+						Parameters: []string{"isolcpus=1-3"},
+					},
+				},
+				DryRun: true,
+			}
+			msgs, err := UpdateUbuntuCore(&cfg)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			joined := strings.Join(msgs, "")
+			for _, e := range tc.expected {
+				if !strings.Contains(joined, e) {
+					t.Errorf("expected output to contain %q, got:\n%s", e, joined)
+				}
+			}
+		})
+	}
+}
+
+// tempUCOwnership records the parameters set by rt-conf in a temporary
+// directory.
+func tempUCOwnership(t *testing.T) {
+	t.Helper()
+	saved := ucOwnershipPath
+	ucOwnershipPath = filepath.Join(t.TempDir(), "ubuntu-core-cmdline")
+	t.Cleanup(func() { ucOwnershipPath = saved })
+}
+
+// ucResponses returns the snapd responses to the requests reading the
+// kernel command line system options, unset when empty, on a system
+// without a gadget snap.
+func ucResponses(cmdline, dangerous string) map[string][]string {
+	option := func(key, value string) []string {
+		if value == "" {
+			return []string{`{"type": "error", "status-code": 400, "status": "Bad Request",
+				"result": {"kind": "option-not-found", "message": "no option"}}`}
+		}
+		return []string{fmt.Sprintf(`{"type": "sync", "status-code": 200, "status": "OK",
+			"result": {%q: %q}}`, key, value)}
+	}
+	return map[string][]string{
+		"GET /v2/snaps/system/conf?keys=" + cmdlineKey:          option(cmdlineKey, cmdline),
+		"GET /v2/snaps/system/conf?keys=" + dangerousCmdlineKey: option(dangerousCmdlineKey, dangerous),
+		"GET /v2/snaps": {`{"type": "sync", "status-code": 200, "status": "OK", "result": []}`},
+	}
+}
+
+func TestUpdateUbuntuCoreMerge(t *testing.T) {
+	tests := []struct {
+		name      string
+		cmdline   string
+		dangerous string
+		params    []string
+		put       string
+		expected  []string
+	}{
+		{
+			name:      "Merge with existing parameters",
+			cmdline:   "quiet",
+			dangerous: "nohz=off console=ttyS0",
+			params:    []string{"nohz=on", "isolcpus=1-3", "console=ttyS0"},
+			put:       `PUT /v2/snaps/system/conf {"system.kernel.dangerous-cmdline-append":"nohz=on console=ttyS0 isolcpus=1-3"}`,
+			expected: []string{
+				"Added:\n\tisolcpus=1-3\n",
+				"Changed:\n\tnohz=off -> nohz=on\n",
+				"Unchanged:\n\tconsole=ttyS0\n",
+				"Successfully applied the changes.\n",
+			},
+		},
+		{
+			name:      "Already up to date",
+			dangerous: "nohz=on",
+			params:    []string{"nohz=on"},
+			expected: []string{
+				"Unchanged:\n\tnohz=on\n",
+				"The kernel command line is already up to date.\n",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tempUCOwnership(t)
+			responses := ucResponses(tc.cmdline, tc.dangerous)
+			responses["PUT /v2/snaps/system/conf"] = []string{
+				`{"type": "sync", "status-code": 200, "status": "OK", "result": null}`,
+			}
+			requests := fakeSnapd(t, responses)
+
+			cfg := &model.InternalConfig{
+				Data: model.Config{
+					KernelCmdline: model.KernelCmdline{
+						Parameters: tc.params,
+					},
+				},
+			}
+			msgs, err := UpdateUbuntuCore(cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			joined := strings.Join(msgs, "")
+			for _, e := range tc.expected {
+				if !strings.Contains(joined, e) {
+					t.Errorf("expected output to contain %q, got:\n%s", e, joined)
+				}
+			}
+
+			var put string
+			for _, r := range *requests {
+				if strings.HasPrefix(r, "PUT") {
+					put = r
+				}
+			}
+			if put != tc.put {
+				t.Errorf("expected request %q, got %q", tc.put, put)
+			}
+		})
+	}
+}
+
+func TestResetUbuntuCore(t *testing.T) {
+	restored := `PUT /v2/snaps/system/conf {"system.kernel.cmdline-append":"quiet",` +
+		`"system.kernel.dangerous-cmdline-append":"nohz=off console=ttyS0"}`
+	tests := []struct {
+		name      string
+		owned     []ownedParam
+		dangerous string
+		put       string
+		request   string
+		expected  string
+		err       string
+	}{
+		{
+			name:     "Nothing set",
+			expected: "nothing to reset",
+		},
+		{
+			name: "Remove rt-conf parameters",
+			owned: []ownedParam{
+				{Param: "nohz=on", Replaced: "nohz=off", Scope: dangerousCmdlineKey},
+				{Param: "isolcpus=1-3", Scope: cmdlineKey},
+			},
+			dangerous: "nohz=on console=ttyS0",
+			put:       `{"status-code": 200, "status": "OK", "result": null}`,
+			request:   restored,
+			expected:  "Removed the kernel command line parameters",
+		},
+		{
+			name:      "No record",
+			dangerous: "nohz=on console=ttyS0",
+			put:       `{"status-code": 200, "status": "OK", "result": null}`,
+			request:   `PUT /v2/snaps/system/conf {"system.kernel.dangerous-cmdline-append":""}`,
+			expected:  "Removed the kernel command line parameters",
+		},
+		{
+			name: "Snapd rejects the change",
+			owned: []ownedParam{
+				{Param: "nohz=on", Scope: dangerousCmdlineKey},
+			},
+			dangerous: "nohz=on console=ttyS0",
+			put:       `{"status-code": 400, "status": "Bad Request", "result": {"message": "invalid"}}`,
+			err:       "snapd error: Bad Request, invalid",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tempUCOwnership(t)
+			if err := writeOwnedParams(ucOwnershipPath, tc.owned); err != nil {
+				t.Fatal(err)
+			}
+			responses := ucResponses("quiet isolcpus=1-3", tc.dangerous)
+			responses["PUT /v2/snaps/system/conf"] = []string{tc.put}
+			requests := fakeSnapd(t, responses)
+
+			msgs, err := ResetUbuntuCore(&model.InternalConfig{})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(strings.Join(msgs, ""), tc.expected) {
+				t.Errorf("expected output to contain %q, got %v", tc.expected, msgs)
+			}
+
+			var put string
+			for _, r := range *requests {
+				if strings.HasPrefix(r, "PUT") {
+					put = r
+				}
+			}
+			if put != tc.request {
+				t.Errorf("expected request %q, got %q", tc.request, put)
+			}
+			if owned, _ := readOwnedParams(ucOwnershipPath); owned != nil {
+				t.Errorf("expected record to be removed, got %v", owned)
+			}
+		})
+	}
+}
+
+func TestMergeUCCmdline(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  map[string][]string
+		owned    []ownedParam
+		params   []string
+		remove   []string
+		allow    []string
+		expected map[string][]string
+	}{
+		{
+			name: "Allowed parameters go to cmdline-append",
+			current: map[string][]string{
+				cmdlineKey:          {"quiet"},
+				dangerousCmdlineKey: {"console=ttyS0"},
+			},
+			params: []string{"isolcpus=1-3", "nohz=on"},
+			allow:  []string{"isolcpus=*"},
+			expected: map[string][]string{
+				cmdlineKey:          {"quiet", "isolcpus=1-3"},
+				dangerousCmdlineKey: {"console=ttyS0", "nohz=on"},
+			},
+		},
+		{
+			name: "Parameter moved to cmdline-append",
+			current: map[string][]string{
+				dangerousCmdlineKey: {"isolcpus=1", "console=ttyS0"},
+			},
+			params: []string{"isolcpus=1-3"},
+			allow:  []string{"isolcpus=*"},
+			expected: map[string][]string{
+				cmdlineKey:          {"isolcpus=1-3"},
+				dangerousCmdlineKey: {"console=ttyS0"},
+			},
+		},
+		{
+			name: "Parameter dropped from the configuration",
+			current: map[string][]string{
+				cmdlineKey:          {"isolcpus=1-3"},
+				dangerousCmdlineKey: {"console=ttyS0", "nohz=on"},
+			},
+			owned: []ownedParam{
+				{Param: "isolcpus=1-3", Scope: cmdlineKey},
+				{Replaced: "isolcpus=1", Scope: dangerousCmdlineKey},
+				{Param: "nohz=on", Scope: dangerousCmdlineKey},
+			},
+			params: []string{"nohz=on"},
+			expected: map[string][]string{
+				dangerousCmdlineKey: {"console=ttyS0", "isolcpus=1", "nohz=on"},
+			},
+		},
+		{
+			name: "Removed parameters",
+			current: map[string][]string{
+				cmdlineKey:          {"quiet", "splash"},
+				dangerousCmdlineKey: {"console=ttyS0", "intel_pstate=active"},
+			},
+			params: []string{"nohz=on"},
+			remove: []string{"quiet", "intel-pstate"},
+			expected: map[string][]string{
+				cmdlineKey:          {"splash"},
+				dangerousCmdlineKey: {"console=ttyS0", "nohz=on"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			updated, owned := mergeUCCmdline(tc.current, tc.owned, tc.params, tc.remove, tc.allow)
+			for _, key := range ucCmdlineKeys {
+				if strings.Join(updated[key], " ") != strings.Join(tc.expected[key], " ") {
+					t.Errorf("expected %s=%q, got %q", key, tc.expected[key], updated[key])
+				}
+			}
+
+			// Undoing the changes restores the options, removed parameters
+			// are appended back
+			for _, key := range ucCmdlineKeys {
+				restored := undoOwnedParams(updated[key], scopedParams(owned, key))
+				base := undoOwnedParams(tc.current[key], scopedParams(tc.owned, key))
+				slices.Sort(restored)
+				slices.Sort(base)
+				if !slices.Equal(restored, base) {
+					t.Errorf("expected %s=%q after undo, got %q", key, base, restored)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareCmdline(t *testing.T) {
+	current := map[string][]string{
+		cmdlineKey:          {"quiet", "isolcpus=1"},
+		dangerousCmdlineKey: {"nohz=on", "rcu-nocbs=1"},
+	}
+	changes := compareCmdline(current,
+		[]string{"isolcpus=1-3", "nohz=on", "rcu_nocbs=2", "irqaffinity=0"},
+		[]string{"quiet"})
+
+	expected := cmdlineChanges{
+		Added:     []string{"irqaffinity=0"},
+		Changed:   []string{"isolcpus=1 -> isolcpus=1-3", "rcu-nocbs=1 -> rcu_nocbs=2"},
+		Removed:   []string{"quiet"},
+		Unchanged: []string{"nohz=on"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+}
+
+func TestGadgetAllows(t *testing.T) {
+	allow := []string{"isolcpus=*", "nohz=on", "quiet"}
+	tests := []struct {
+		param    string
+		expected bool
+	}{
+		{"isolcpus=1-3", true},
+		{"isolcpus", false},
+		{"nohz=on", true},
+		{"nohz=off", false},
+		{"quiet", true},
+		{"quiet=1", false},
+		{"splash", false},
+	}
+	for _, tc := range tests {
+		if got := gadgetAllows(allow, tc.param); got != tc.expected {
+			t.Errorf("gadgetAllows(%q) = %v, expected %v", tc.param, got, tc.expected)
+		}
+	}
+}
+
+func TestGadgetAllowList(t *testing.T) {
+	savedMountDir := snapMountDir
+	snapMountDir = t.TempDir()
+	t.Cleanup(func() { snapMountDir = savedMountDir })
+
+	meta := filepath.Join(snapMountDir, "pc", "current", "meta")
+	if err := os.MkdirAll(meta, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	gadget := `
+volumes:
+  pc:
+    bootloader: grub
+kernel-cmdline:
+  allow:
+    - isolcpus=*
+    - nohz=on
+`
+	if err := os.WriteFile(filepath.Join(meta, "gadget.yaml"), []byte(gadget), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fakeSnapd(t, map[string][]string{
+		"GET /v2/snaps": {`{"type": "sync", "status-code": 200, "status": "OK", "result": [
+			{"name": "core24", "type": "base"},
+			{"name": "pc", "type": "gadget"}]}`},
+	})
+
+	allow, err := gadgetAllowList(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"isolcpus=*", "nohz=on"}
+	if !reflect.DeepEqual(allow, expected) {
+		t.Errorf("expected %q, got %q", expected, allow)
+	}
+}
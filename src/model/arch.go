@@ -0,0 +1,127 @@
+package model
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// commandLineSizes holds the maximum kernel command line length, including
+// the terminating null byte, of the architectures supported by rt-conf.
+// See the COMMAND_LINE_SIZE macro in the kernel source code:
+// - arch/x86/include/asm/setup.h
+// - arch/arm64/include/uapi/asm/setup.h
+// - arch/arm/include/uapi/asm/setup.h
+// - arch/riscv/include/uapi/asm/setup.h
+// - arch/powerpc/include/uapi/asm/setup.h
+// - arch/s390/include/uapi/asm/setup.h
+var commandLineSizes = map[string]int{
+	"amd64":   2048,
+	"arm64":   2048,
+	"armhf":   1024,
+	"riscv64": 1024,
+	"ppc64el": 2048,
+	"s390x":   4096,
+}
+
+// targetArch is the architecture the kernel command line is validated for
+var targetArch = HostArch()
+
+// HostArch returns the architecture of the running kernel, named like the
+// Debian architectures. It is the one of the kernel rather than the one of
+// rt-conf, e.g. arm64 for an armhf build running on a 64-bit kernel, since
+// the kernel parses the command line. It falls back to the architecture of
+// rt-conf when uname fails.
+func HostArch() string {
+	machine, err := unameMachine()
+	if err != nil {
+		return goArch(runtime.GOARCH)
+	}
+	return debianArch(machine)
+}
+
+// unameMachine returns the machine hardware name reported by uname(2).
+var unameMachine = func() (string, error) {
+	var u syscall.Utsname
+	if err := syscall.Uname(&u); err != nil {
+		return "", err
+	}
+	var b []byte
+	for _, c := range u.Machine {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b), nil
+}
+
+// debianArch maps a machine hardware name of the kernel, e.g. x86_64, to the
+// Debian architecture name. Unknown names are returned unchanged.
+func debianArch(machine string) string {
+	switch {
+	case machine == "x86_64":
+		return "amd64"
+	case machine == "aarch64" || machine == "arm64":
+		return "arm64"
+	case strings.HasPrefix(machine, "arm"):
+		return "armhf"
+	case machine == "ppc64le":
+		return "ppc64el"
+	case machine == "i386" || machine == "i686":
+		return "i386"
+	}
+	return machine
+}
+
+// goArch maps a Go architecture to the Debian architecture name.
+func goArch(arch string) string {
+	switch arch {
+	case "arm":
+		return "armhf"
+	case "ppc64le":
+		return "ppc64el"
+	case "386":
+		return "i386"
+	}
+	return arch
+}
+
+// TargetArch returns the architecture the kernel command line is validated
+// for, the one of the running system unless set by SetTargetArch.
+func TargetArch() string {
+	return targetArch
+}
+
+// SetTargetArch sets the architecture the kernel command line is validated
+// for, e.g. to validate a configuration for another system.
+func SetTargetArch(arch string) error {
+	if _, ok := commandLineSizes[arch]; !ok {
+		return fmt.Errorf("unsupported architecture %q, expected one of %s",
+			arch, strings.Join(SupportedArchs(), ", "))
+	}
+	targetArch = arch
+	return nil
+}
+
+// SupportedArchs returns the architectures supported by rt-conf.
+func SupportedArchs() []string {
+	archs := make([]string, 0, len(commandLineSizes))
+	for arch := range commandLineSizes {
+		archs = append(archs, arch)
+	}
+	slices.Sort(archs)
+	return archs
+}
+
+// CommandLineSize returns the maximum kernel command line length of the
+// target architecture, including the terminating null byte. It defaults to
+// the one of amd64 and arm64 on the other architectures.
+func CommandLineSize() int {
+	if size, ok := commandLineSizes[targetArch]; ok {
+		return size
+	}
+	return commandLineSizes["amd64"]
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSetTargetArch(t *testing.T) {
+	saved := targetArch
+	t.Cleanup(func() { targetArch = saved })
+
+	tests := []struct {
+		arch string
+		size int
+		err  string
+	}{
+		{arch: "amd64", size: 2048},
+		{arch: "arm64", size: 2048},
+		{arch: "armhf", size: 1024},
+		{arch: "riscv64", size: 1024},
+		{arch: "ppc64el", size: 2048},
+		{arch: "s390x", size: 4096},
+		{arch: "i386", err: `unsupported architecture "i386"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.arch, func(t *testing.T) {
+			targetArch = "amd64"
+			err := SetTargetArch(tc.arch)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, err)
+				}
+				if TargetArch() != "amd64" {
+					t.Errorf("expected the target architecture to be unchanged, got %s", TargetArch())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if TargetArch() != tc.arch {
+				t.Errorf("expected target architecture %s, got %s", tc.arch, TargetArch())
+			}
+			if CommandLineSize() != tc.size {
+				t.Errorf("expected command line size %d, got %d", tc.size, CommandLineSize())
+			}
+		})
+	}
+}
+
+func TestValidateTargetArch(t *testing.T) {
+	saved := targetArch
+	t.Cleanup(func() { targetArch = saved })
+
+	long := KernelCmdline{Parameters: []string{"foo=" + strings.Repeat("a", 1500)}}
+	intel := KernelCmdline{Parameters: []string{"intel_idle.max_cstate=0"}}
+
+	targetArch = "amd64"
+	if err := long.validateParameterFormat(); err != nil {
+		t.Errorf("unexpected error on amd64: %v", err)
+	}
+	if err := intel.validateParameterValues(); err != nil {
+		t.Errorf("unexpected error on amd64: %v", err)
+	}
+
+	targetArch = "armhf"
+	if err := long.validateParameterFormat(); err == nil ||
+		!strings.Contains(err.Error(), "maximum length of 1024 bytes on armhf") {
+		t.Errorf("expected length error on armhf, got %v", err)
+	}
+	if err := intel.validateParameterValues(); err == nil ||
+		!strings.Contains(err.Error(), "not supported on armhf") {
+		t.Errorf("expected architecture error on armhf, got %v", err)
+	}
+}
+
+func TestHostArch(t *testing.T) {
+	saved := unameMachine
+	t.Cleanup(func() { unameMachine = saved })
+
+	tests := []struct {
+		machine  string
+		err      error
+		expected string
+	}{
+		{machine: "x86_64", expected: "amd64"},
+		{machine: "aarch64", expected: "arm64"},
+		{machine: "armv7l", expected: "armhf"},
+		{machine: "armv8l", expected: "armhf"},
+		{machine: "riscv64", expected: "riscv64"},
+		{machine: "ppc64le", expected: "ppc64el"},
+		{machine: "s390x", expected: "s390x"},
+		{machine: "i686", expected: "i386"},
+		{machine: "mips", expected: "mips"},
+		{err: errors.New("uname failed"), expected: goArch(runtime.GOARCH)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expected+"/"+tc.machine, func(t *testing.T) {
+			unameMachine = func() (string, error) {
+				return tc.machine, tc.err
+			}
+			if arch := HostArch(); arch != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, arch)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParamKey returns the name of a kernel parameter, the part before "=".
+func ParamKey(p string) string {
+	key, _, _ := strings.Cut(p, "=")
+	return key
+}
+
+// normalizeKey makes parameter names comparable, the kernel treats dashes
+// and underscores in parameter names as equivalent.
+func normalizeKey(key string) string {
+	return strings.ReplaceAll(key, "-", "_")
+}
+
+// SameKey reports whether the parameters a and b set the same kernel parameter.
+func SameKey(a, b string) bool {
+	return normalizeKey(ParamKey(a)) == normalizeKey(ParamKey(b))
+}
+
+// SplitCmdline splits a kernel command line into parameters, the way the
+// kernel does: parameters are separated by whitespace, except inside double
+// quotes. The quotes are kept, so joining the parameters with spaces gives
+// back an equivalent command line.
+func SplitCmdline(cmdline string) []string {
+	var params []string
+	var sb strings.Builder
+	inQuote := false
+	for _, c := range cmdline {
+		if c == '"' {
+			inQuote = !inQuote
+		}
+		if isSpace(c) && !inQuote {
+			if sb.Len() > 0 {
+				params = append(params, sb.String())
+				sb.Reset()
+			}
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	if sb.Len() > 0 {
+		params = append(params, sb.String())
+	}
+	return params
+}
+
+// QuoteParam renders a parameter for the kernel command line. Values
+// containing whitespace are quoted, e.g. dyndbg="file foo.c +p". The kernel
+// has no escaping, so values can't contain double quotes other than the
+// surrounding ones, nor characters outside of printable ASCII.
+func QuoteParam(p string) (string, error) {
+	for _, c := range p {
+		if c > '~' || (c < ' ' && c != '\t') {
+			return "", fmt.Errorf("parameter %q contains an invalid character %q", p, c)
+		}
+	}
+
+	key, value, hasValue := strings.Cut(p, "=")
+	if strings.ContainsFunc(key, func(c rune) bool { return c == '"' || isSpace(c) }) {
+		return "", fmt.Errorf("invalid parameter name: %q", key)
+	}
+	if !hasValue {
+		return p, nil
+	}
+
+	inner := value
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		inner = value[1 : len(value)-1]
+	}
+	if strings.Contains(inner, `"`) {
+		return "", fmt.Errorf("value of %q can't contain double quotes: %q", key, value)
+	}
+	if inner != value || !strings.ContainsFunc(inner, isSpace) {
+		return p, nil
+	}
+	return key + `="` + inner + `"`, nil
+}
+
+// UnquoteParam returns a parameter with the quotes of its value removed,
+// as the kernel passes it to the parameter handlers.
+func UnquoteParam(p string) string {
+	key, value, hasValue := strings.Cut(p, "=")
+	if !hasValue || len(value) < 2 || !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) {
+		return p
+	}
+	return key + "=" + value[1:len(value)-1]
+}
+
+func isSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// RemovedBy reports whether the parameter p is dropped by one of the remove
+// entries, which are either a parameter name, matching any value, or a
+// whole parameter.
+func RemovedBy(remove []string, p string) bool {
+	for _, r := range remove {
+		if !SameKey(r, p) {
+			continue
+		}
+		if !strings.Contains(r, "=") || UnquoteParam(r) == UnquoteParam(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeParams merges params into an existing list of kernel parameters.
+// Existing parameters with the same name are replaced in place, the first
+// occurrence keeps its position and later ones are dropped. Parameters not
+// present yet are appended.
+func MergeParams(existing, params []string) []string {
+	replacements := make(map[string]string, len(params))
+	var order []string
+	for _, p := range params {
+		key := normalizeKey(ParamKey(p))
+		if _, ok := replacements[key]; !ok {
+			order = append(order, key)
+		}
+		replacements[key] = p
+	}
+
+	merged := make([]string, 0, len(existing)+len(params))
+	placed := make(map[string]bool, len(params))
+	for _, p := range existing {
+		key := normalizeKey(ParamKey(p))
+		replacement, ok := replacements[key]
+		if !ok {
+			merged = append(merged, p)
+			continue
+		}
+		if !placed[key] {
+			merged = append(merged, replacement)
+			placed[key] = true
+		}
+	}
+
+	for _, key := range order {
+		if !placed[key] {
+			merged = append(merged, replacements[key])
+		}
+	}
+	return merged
+}
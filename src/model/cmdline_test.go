@@ -0,0 +1,273 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParamKey(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"isolcpus=1-3", "isolcpus"},
+		{"quiet", "quiet"},
+		{"console=ttyS0,115200", "console"},
+		{"foo=bar=baz", "foo"},
+		{"", ""},
+	}
+
+	for _, tc := range tests {
+		if got := ParamKey(tc.input); got != tc.expected {
+			t.Errorf("ParamKey(%q) = %q; want %q", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestSplitCmdline(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"", nil},
+		{"  quiet\tnohz=on\n", []string{"quiet", "nohz=on"}},
+		{`dyndbg="file foo.c +p" quiet`, []string{`dyndbg="file foo.c +p"`, "quiet"}},
+		{`"foo=a b" bar`, []string{`"foo=a b"`, "bar"}},
+		{`foo="a b`, []string{`foo="a b`}},
+	}
+
+	for _, tc := range tests {
+		if got := SplitCmdline(tc.input); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("SplitCmdline(%q) = %q; want %q", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestQuoteParam(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{input: "quiet", expected: "quiet"},
+		{input: "isolcpus=1-3", expected: "isolcpus=1-3"},
+		{input: "dyndbg=file foo.c +p", expected: `dyndbg="file foo.c +p"`},
+		{input: `dyndbg="file foo.c +p"`, expected: `dyndbg="file foo.c +p"`},
+		{input: `foo="bar"`, expected: `foo="bar"`},
+		{input: "foo=$(reboot)", expected: "foo=$(reboot)"},
+		{input: `foo=a"b`, err: "can't contain double quotes"},
+		{input: `foo="a" b"`, err: "can't contain double quotes"},
+		{input: "foo=a\nb", err: "invalid character"},
+		{input: "foo=é", err: "invalid character"},
+		{input: `fo"o=bar`, err: "invalid parameter name"},
+	}
+
+	for _, tc := range tests {
+		got, err := QuoteParam(tc.input)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("QuoteParam(%q): expected error containing %q, got %v", tc.input, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("QuoteParam(%q): unexpected error: %v", tc.input, err)
+		}
+		if got != tc.expected {
+			t.Errorf("QuoteParam(%q) = %q; want %q", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestSameKey(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"nohz=on", "nohz=off", true},
+		{"nohz", "nohz=on", true},
+		{"rcu_nocbs=1", "rcu-nocbs=2", true},
+		{"nohz=on", "nohz_full=1", false},
+	}
+
+	for _, tc := range tests {
+		if got := SameKey(tc.a, tc.b); got != tc.expected {
+			t.Errorf("SameKey(%q, %q) = %v; want %v", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
+
+func TestMergeParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		params   []string
+		expected []string
+	}{
+		{
+			name:     "Append to empty",
+			existing: nil,
+			params:   []string{"isolcpus=1-3", "nohz=on"},
+			expected: []string{"isolcpus=1-3", "nohz=on"},
+		},
+		{
+			name:     "Keep unrelated parameters",
+			existing: []string{"root=/dev/mmcblk0p2", "rw"},
+			params:   []string{"nohz=on"},
+			expected: []string{"root=/dev/mmcblk0p2", "rw", "nohz=on"},
+		},
+		{
+			name:     "Replace in place",
+			existing: []string{"quiet", "nohz=off", "splash"},
+			params:   []string{"nohz=on"},
+			expected: []string{"quiet", "nohz=on", "splash"},
+		},
+		{
+			name:     "Drop repeated occurrences",
+			existing: []string{"isolcpus=1", "quiet", "isolcpus=2"},
+			params:   []string{"isolcpus=3"},
+			expected: []string{"isolcpus=3", "quiet"},
+		},
+		{
+			name:     "Dashes and underscores are equivalent",
+			existing: []string{"rcu-nocbs=1"},
+			params:   []string{"rcu_nocbs=2-3"},
+			expected: []string{"rcu_nocbs=2-3"},
+		},
+		{
+			name:     "Flag replaces key with value",
+			existing: []string{"threadirqs=1"},
+			params:   []string{"threadirqs"},
+			expected: []string{"threadirqs"},
+		},
+		{
+			name:     "Already present",
+			existing: []string{"nohz=on"},
+			params:   []string{"nohz=on"},
+			expected: []string{"nohz=on"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := MergeParams(tc.existing, tc.params)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestRemovedBy(t *testing.T) {
+	remove := []string{"quiet", "intel-pstate", "console=ttyS0", `dyndbg="file foo.c +p"`}
+	tests := []struct {
+		param    string
+		expected bool
+	}{
+		{"quiet", true},
+		{"quiet=1", true},
+		{"splash", false},
+		{"intel_pstate=active", true},
+		{"console=ttyS0", true},
+		{"console=tty0", false},
+		{"dyndbg=file foo.c +p", true},
+	}
+
+	for _, tc := range tests {
+		if got := RemovedBy(remove, tc.param); got != tc.expected {
+			t.Errorf("RemovedBy(%q) = %v; want %v", tc.param, got, tc.expected)
+		}
+	}
+}
+
+func TestValidateRemove(t *testing.T) {
+	tests := []struct {
+		name string
+		k    KernelCmdline
+		err  string
+	}{
+		{
+			name: "Valid",
+			k:    KernelCmdline{Parameters: []string{"nohz=on"}, Remove: []string{"quiet", "console=ttyS0"}},
+		},
+		{
+			name: "Removed by name",
+			k:    KernelCmdline{Parameters: []string{"intel_pstate=disable"}, Remove: []string{"intel-pstate"}},
+			err:  `parameter "intel_pstate=disable" is both set and removed by "intel-pstate"`,
+		},
+		{
+			name: "Removed by value",
+			k:    KernelCmdline{Parameters: []string{"nohz=on"}, Remove: []string{"nohz=on"}},
+			err:  "is both set and removed",
+		},
+		{
+			name: "Other value removed",
+			k:    KernelCmdline{Parameters: []string{"nohz=on"}, Remove: []string{"nohz=off"}},
+		},
+		{
+			name: "Invalid name",
+			k:    KernelCmdline{Remove: []string{"1quiet"}},
+			err:  "invalid parameter name to remove",
+		},
+		{
+			name: "Empty",
+			k:    KernelCmdline{Remove: []string{"=1"}},
+			err:  "empty parameter to remove",
+		},
+	}
+	for _, c := range []string{"$", "`", ";", "|", "(", ")"} {
+		tests = append(tests, struct {
+			name string
+			k    KernelCmdline
+			err  string
+		}{
+			name: "Invalid character " + c,
+			k:    KernelCmdline{Remove: []string{"quiet" + c + "x=1"}},
+			err:  "invalid parameter name to remove",
+		})
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.k.validateRemove()
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateParameterName(t *testing.T) {
+	tests := []struct {
+		param string
+		valid bool
+	}{
+		{"nohz=on", true},
+		{"intel_idle.max_cstate=0", true},
+		{"rcu-nocbs=1", true},
+		{"a$(touch${IFS}/tmp/pwned)=1", false},
+		{"a`id`=1", false},
+		{"a;reboot", false},
+		{"a|id=1", false},
+		{"a(=1", false},
+		{"a)=1", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.param, func(t *testing.T) {
+			err := KernelCmdline{Parameters: []string{tc.param}}.validateParameterFormat()
+			if tc.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tc.valid && (err == nil || !strings.Contains(err.Error(), "invalid parameter name")) {
+				t.Fatalf("expected an invalid parameter name error, got %v", err)
+			}
+		})
+	}
+}
@@ -59,23 +59,31 @@ func (c *Config) LoadSnapOptions() error {
 	value, err := snapctl.Get(
 		"kernel-cmdline",
 		"irq-tuning",
+		"irq-on-no-match",
+		"irq-housekeeping",
 		"cpu-governance",
 	).Document().Run()
 	if err != nil {
 		return fmt.Errorf("failed to get snap option: %v", err)
 	}
+	return c.applySnapOptions(value)
+}
 
+// applySnapOptions overrides the objects set in value, the snap options as
+// a JSON document.
+func (c *Config) applySnapOptions(value string) error {
 	var confOptions Config
 
 	// Unmarshal json using YAML unmarshaler
 	// This works because YAML is a superset of JSON
-	err = yaml.Unmarshal([]byte(value), &confOptions)
+	err := yaml.Unmarshal([]byte(value), &confOptions)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal snap options: %v", err)
 	}
 
-	// reject kernel command line arguments
-	if len(confOptions.KernelCmdline.Parameters) > 0 {
+	// reject any kernel command line field
+	k := confOptions.KernelCmdline
+	if !k.IsEmpty() || k.Grub != (GrubOptions{}) || k.Bootloader != "" {
 		return fmt.Errorf("kernel-cmdline snap option is not supported, use the config file instead")
 	}
 
@@ -86,6 +94,12 @@ func (c *Config) LoadSnapOptions() error {
 	if len(confOptions.CpuGovernance) > 0 {
 		c.CpuGovernance = confOptions.CpuGovernance
 	}
+	if confOptions.IRQOnNoMatch != "" {
+		c.IRQOnNoMatch = confOptions.IRQOnNoMatch
+	}
+	if confOptions.IRQHousekeeping != (IRQs{}) {
+		c.IRQHousekeeping = confOptions.IRQHousekeeping
+	}
 
 	err = c.Validate()
 	if err != nil {
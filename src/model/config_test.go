@@ -131,3 +131,52 @@ kernel-cmdline:
 		})
 	}
 }
+
+func TestApplySnapOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		err     string
+	}{
+		{
+			name:    "No options",
+			options: `{}`,
+		},
+		{
+			name:    "Kernel cmdline parameters",
+			options: `{"kernel-cmdline": {"parameters": ["nohz=on"]}}`,
+			err:     "kernel-cmdline snap option is not supported",
+		},
+		{
+			name:    "Kernel cmdline remove",
+			options: `{"kernel-cmdline": {"remove": ["quiet"]}}`,
+			err:     "kernel-cmdline snap option is not supported",
+		},
+		{
+			name:    "Kernel cmdline bootloader",
+			options: `{"kernel-cmdline": {"bootloader": "grub"}}`,
+			err:     "kernel-cmdline snap option is not supported",
+		},
+		{
+			name:    "Kernel cmdline grub",
+			options: `{"kernel-cmdline": {"grub": {"default-rt-kernel": true}}}`,
+			err:     "kernel-cmdline snap option is not supported",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var c Config
+			err := c.applySnapOptions(tc.options)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
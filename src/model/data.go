@@ -3,12 +3,29 @@ package model
 import (
 	"fmt"
 	"regexp"
+	"time"
 )
 
 type InternalConfig struct {
 	Data Config
 
-	GrubCfg Grub
+	GrubCfg        Grub
+	UbootCfg       Uboot
+	SystemdBootCfg SystemdBoot
+
+	// DryRun reports the changes that would be applied without writing them
+	DryRun bool
+
+	// SnapdTimeout bounds the wait for snapd to apply a change
+	SnapdTimeout time.Duration
+
+	// Bootloader overrides the detected bootloader and the one of the
+	// configuration, see system.SystemNames
+	Bootloader string
+
+	// TryNextBoot applies the kernel command line to the next boot only,
+	// until it is committed
+	TryNextBoot bool
 }
 
 type (
@@ -20,6 +37,25 @@ type Config struct {
 	KernelCmdline KernelCmdline `yaml:"kernel-cmdline"`
 	Interrupts    Interrupts    `yaml:"irq-tuning"`
 	CpuGovernance PwrMgmt       `yaml:"cpu-governance"`
+
+	// IRQOnNoMatch is what to do when an IRQ tuning rule matches no IRQ,
+	// unless set by the rule
+	IRQOnNoMatch string `yaml:"irq-on-no-match"`
+
+	// IRQHousekeeping moves the IRQs off some CPUs, before the IRQ tuning
+	// rules apply
+	IRQHousekeeping IRQs `yaml:"irq-housekeeping"`
+}
+
+// OnNoMatch returns what to do when the IRQ tuning rule matches no IRQ.
+func (c Config) OnNoMatch(rule IRQTuning) string {
+	switch {
+	case rule.OnNoMatch != "":
+		return rule.OnNoMatch
+	case c.IRQOnNoMatch != "":
+		return c.IRQOnNoMatch
+	}
+	return OnNoMatchFail
 }
 
 // Regex for valid snap options from snapd:
@@ -31,6 +67,12 @@ func (c Config) Validate() error {
 	if err != nil {
 		return fmt.Errorf("failed to validate kernel cmdline: %v", err)
 	}
+	if err := validateOnNoMatch(c.IRQOnNoMatch); err != nil {
+		return fmt.Errorf("failed to validate irq-on-no-match: %v", err)
+	}
+	if err := c.IRQHousekeeping.Validate(); err != nil {
+		return fmt.Errorf("failed to validate irq-housekeeping: %v", err)
+	}
 	for label, irq := range c.Interrupts {
 		if !validRuleName.MatchString(label) {
 			return fmt.Errorf("invalid rule name: %q", label)
@@ -51,6 +93,9 @@ func (c Config) Validate() error {
 				"failed to validate cpu governance rule #%s: %s", label, err)
 		}
 	}
+	if err := c.warnOverlaps(); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -71,6 +71,25 @@ func TestValidate(t *testing.T) {
 			},
 			err: errors.New("invalid rule name"),
 		},
+		{
+			name: "Invalid on-no-match of IRQ tuning rule",
+			cfg: &Config{
+				Interrupts: Interrupts{
+					"foo": {
+						CPUs:      "0",
+						OnNoMatch: "skip",
+					},
+				},
+			},
+			err: errors.New("invalid on-no-match: \"skip\""),
+		},
+		{
+			name: "Invalid irq-on-no-match",
+			cfg: &Config{
+				IRQOnNoMatch: "skip",
+			},
+			err: errors.New("failed to validate irq-on-no-match"),
+		},
 		{
 			name: "Invalid CPU governance rule",
 			cfg: &Config{
@@ -117,3 +136,26 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestOnNoMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   string
+		rule     string
+		expected string
+	}{
+		{"default", "", "", OnNoMatchFail},
+		{"global", OnNoMatchWarn, "", OnNoMatchWarn},
+		{"rule", "", OnNoMatchIgnore, OnNoMatchIgnore},
+		{"rule over global", OnNoMatchWarn, OnNoMatchFail, OnNoMatchFail},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := Config{IRQOnNoMatch: tc.global}
+			if got := c.OnNoMatch(IRQTuning{OnNoMatch: tc.rule}); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
@@ -11,6 +11,22 @@ import (
 type Grub struct {
 	GrubDropInFile string
 	Cmdline        string
+	// Filter lists the parameters dropped from the default command lines,
+	// see RemovedBy
+	Filter []string
+	// Default is the GRUB_DEFAULT entry, left unchanged when empty
+	Default string
+	// RTKernelOnly applies the command line to the real-time kernel entry
+	// only, see GrubOptions
+	RTKernelOnly bool
+}
+
+type Uboot struct {
+	ExtlinuxFile string
+}
+
+type SystemdBoot struct {
+	KernelCmdlineFile string
 }
 
 type Core interface {
@@ -2,26 +2,49 @@ package model
 
 import (
 	"fmt"
-	"os"
+	"math"
+	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/canonical/rt-conf/src/cpulists"
+	"github.com/canonical/rt-conf/src/sysfs"
 )
 
-// TODO: THis needs to be superseed in the unit tests
+// The IRQ interfaces of the kernel, read and written through sysfs.Root
 const (
 	SysKernelIRQ = "/sys/kernel/irq"
 	ProcIRQ      = "/proc/irq"
 )
 
-var readDir = func(name string) ([]os.DirEntry, error) {
-	return os.ReadDir(name)
+// What to do when an IRQ tuning rule matches no IRQ, e.g. when a NIC is
+// absent. The default is OnNoMatchFail.
+const (
+	OnNoMatchFail   = "fail"
+	OnNoMatchWarn   = "warn"
+	OnNoMatchIgnore = "ignore"
+)
+
+// validateOnNoMatch checks an on-no-match value, empty for the default.
+func validateOnNoMatch(value string) error {
+	switch value {
+	case "", OnNoMatchFail, OnNoMatchWarn, OnNoMatchIgnore:
+		return nil
+	}
+	return fmt.Errorf("invalid on-no-match: %q, expected one of %s, %s, %s",
+		value, OnNoMatchFail, OnNoMatchWarn, OnNoMatchIgnore)
 }
 
 type IRQTuning struct {
 	CPUs   string    `yaml:"cpus"`
 	Filter IRQFilter `yaml:"filter"`
+	// OnNoMatch overrides Config.IRQOnNoMatch for this rule
+	OnNoMatch string `yaml:"on-no-match"`
+	// Priority orders the rules, the highest applies last and wins
+	Priority int `yaml:"priority"`
+
+	position int // position in the file, see Interrupts.UnmarshalYAML
 }
 
 func (c IRQTuning) Validate() error {
@@ -29,6 +52,9 @@ func (c IRQTuning) Validate() error {
 	if err != nil {
 		return fmt.Errorf("IRQFilter validation failed: %v", err)
 	}
+	if err := validateOnNoMatch(c.OnNoMatch); err != nil {
+		return err
+	}
 	_, err = cpulists.Parse(c.CPUs)
 	if err != nil {
 		return fmt.Errorf("invalid cpus: %v", err)
@@ -36,35 +62,180 @@ func (c IRQTuning) Validate() error {
 	return nil
 }
 
+// IRQFilter matches the IRQs matching all of its fields and blocks set
 type IRQFilter struct {
+	// Number is a list of IRQ numbers and ranges, e.g. 0-15,32. The IRQs
+	// missing on the system match nothing.
+	Number   string `yaml:"number" validation:"numbers"`
 	Actions  string `yaml:"actions" validation:"regex"`
 	ChipName string `yaml:"chip-name" validation:"regex"`
 	Name     string `yaml:"name" validation:"regex"`
 	Type     string `yaml:"type" validation:"regex"`
+	// Hwirq is a list of hardware IRQ numbers and ranges, e.g. 0-15,32
+	Hwirq  string `yaml:"hwirq" validation:"numbers"`
+	Wakeup string `yaml:"wakeup" validation:"wakeup"`
+	// The PCI devices, by address (BDF) and driver, and the network
+	// interfaces whose MSI and legacy IRQs are matched
+	PCIDevice    string `yaml:"pci-device" validation:"regex"`
+	PCIDriver    string `yaml:"pci-driver" validation:"regex"`
+	NetInterface string `yaml:"net-interface" validation:"regex"`
+
+	// All matches the IRQs matching all of the filters, Any the ones
+	// matching at least one of them, and Not the ones not matching it
+	All []IRQFilter `yaml:"all"`
+	Any []IRQFilter `yaml:"any"`
+	Not *IRQFilter  `yaml:"not"`
+}
+
+// IsEmpty reports whether the filter matches all the IRQs.
+func (c IRQFilter) IsEmpty() bool {
+	return reflect.DeepEqual(c, IRQFilter{})
 }
 
+// String describes the fields and blocks set, e.g.
+// {actions: nvme, not: {name: edge}}.
+func (c IRQFilter) String() string {
+	var fields []string
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("yaml")
+		switch value := v.Field(i).Interface().(type) {
+		case string:
+			if value != "" {
+				fields = append(fields, fmt.Sprintf("%s: %s", name, value))
+			}
+		case []IRQFilter:
+			if len(value) > 0 {
+				filters := make([]string, len(value))
+				for j, f := range value {
+					filters[j] = f.String()
+				}
+				fields = append(fields, fmt.Sprintf("%s: [%s]", name,
+					strings.Join(filters, ", ")))
+			}
+		case *IRQFilter:
+			if value != nil {
+				fields = append(fields, fmt.Sprintf("%s: %s", name, value))
+			}
+		}
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+// ParseNumbers parses a list of numbers in the CPU lists format, e.g.
+// 0-15,32. Unlike CPU lists, the numbers have no upper bound, so "all" and
+// N are not accepted.
+func ParseNumbers(s string) (cpulists.CPUs, error) {
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "all" || strings.Contains(item, "N") {
+			return nil, fmt.Errorf("invalid number list %q: all and N have no upper bound", s)
+		}
+	}
+	return cpulists.ParseForCPUs(s, math.MaxInt)
+}
+
+// IRQs moves all the movable IRQs off the IsolateCPU CPUs, to the
+// IRQHandler ones, without filters. When only one of them is set, the other
+// one is the rest of the CPUs.
 type IRQs struct {
 	IsolateCPU string `yaml:"remove-from-cpus"`
 	IRQHandler string `yaml:"handle-on-cpus"`
 }
 
+// CPUs returns the CPUs to remove the IRQs from, and the ones handling them.
+func (c IRQs) CPUs() (remove cpulists.CPUs, handle cpulists.CPUs, err error) {
+	if c.IsolateCPU != "" {
+		if remove, err = cpulists.Parse(c.IsolateCPU); err != nil {
+			return nil, nil, fmt.Errorf("invalid remove-from-cpus: %v", err)
+		}
+	}
+	if c.IRQHandler != "" {
+		if handle, err = cpulists.Parse(c.IRQHandler); err != nil {
+			return nil, nil, fmt.Errorf("invalid handle-on-cpus: %v", err)
+		}
+	}
+
+	all, err := cpulists.Parse("all")
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case remove == nil:
+		remove = make(cpulists.CPUs)
+		for cpu := range all {
+			if !handle[cpu] {
+				remove[cpu] = true
+			}
+		}
+	case handle == nil:
+		handle = make(cpulists.CPUs)
+		for cpu := range all {
+			if !remove[cpu] {
+				handle[cpu] = true
+			}
+		}
+	}
+	return remove, handle, nil
+}
+
+func (c IRQs) Validate() error {
+	if c == (IRQs{}) {
+		return nil
+	}
+	remove, handle, err := c.CPUs()
+	if err != nil {
+		return err
+	}
+	if len(handle) == 0 {
+		return fmt.Errorf("no CPU left to handle the IRQs")
+	}
+	var both []int
+	for cpu := range remove {
+		if handle[cpu] {
+			both = append(both, cpu)
+		}
+	}
+	if len(both) > 0 {
+		return fmt.Errorf("CPUs %s are both in remove-from-cpus and handle-on-cpus",
+			cpulists.GenCPUlist(both))
+	}
+	return nil
+}
+
 func (c IRQFilter) Validate() error {
-	return Validate(c, c.validateIRQField)
+	if err := Validate(c, c.validateIRQField); err != nil {
+		return err
+	}
+	blocks := map[string][]IRQFilter{"all": c.All, "any": c.Any}
+	if c.Not != nil {
+		blocks["not"] = []IRQFilter{*c.Not}
+	}
+	for _, name := range []string{"all", "any", "not"} {
+		for _, f := range blocks[name] {
+			// An empty filter would match all the IRQs, or none with not
+			if f.IsEmpty() {
+				return fmt.Errorf("empty filter in %s", name)
+			}
+			if err := f.Validate(); err != nil {
+				return fmt.Errorf("in %s: %v", name, err)
+			}
+		}
+	}
+	return nil
 }
 
 // TODO: Validate mutual exclusive cpu lists
 
 func (c IRQFilter) validateIRQField(name string, value string, tag string) error {
 	switch tag {
-	case "cpulist":
-		num, err := GetHigherIRQ()
-		if err != nil {
-			return err
+	case "numbers":
+		if _, err := ParseNumbers(value); err != nil {
+			return fmt.Errorf("on field %v: %v", name, err)
 		}
-		_, err = cpulists.ParseForCPUs(value, num)
-		if err != nil {
-			return fmt.Errorf("on field %v: invalid irq list: %v", name,
-				err)
+	case "wakeup":
+		if value != "enabled" && value != "disabled" {
+			return fmt.Errorf("on field %v: invalid wakeup state: %q, expected enabled or disabled",
+				name, value)
 		}
 	case "regex":
 		_, err := regexp.Compile(value)
@@ -78,7 +249,7 @@ func (c IRQFilter) validateIRQField(name string, value string, tag string) error
 }
 
 func GetHigherIRQ() (int, error) {
-	files, err := readDir(SysKernelIRQ)
+	files, err := sysfs.Root.ReadDir(SysKernelIRQ)
 	if err != nil {
 		return 0, err
 	}
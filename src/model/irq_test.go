@@ -3,7 +3,12 @@ package model
 import (
 	"errors"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/canonical/rt-conf/src/cpulists"
+	"github.com/canonical/rt-conf/src/sysfs"
+	"go.yaml.in/yaml/v4"
 )
 
 func TestIRQTuningValidate(t *testing.T) {
@@ -78,6 +83,85 @@ func TestIRQTuningValidate(t *testing.T) {
 	}
 }
 
+func TestIRQFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  IRQFilter
+		wantErr bool
+	}{
+		{"numbers", IRQFilter{Number: "0-3,24"}, false},
+		// Not validated against the IRQs of the system
+		{"numbers beyond the highest IRQ", IRQFilter{Number: "100000"}, false},
+		{"invalid numbers", IRQFilter{Number: "0-N"}, true},
+		{"hwirq", IRQFilter{Hwirq: "0-15,524288"}, false},
+		{"invalid hwirq", IRQFilter{Hwirq: "15-0"}, true},
+		{"wakeup", IRQFilter{Wakeup: "disabled"}, false},
+		{"invalid wakeup", IRQFilter{Wakeup: "yes"}, true},
+		{"devices", IRQFilter{PCIDevice: `0000:01:00\..`, PCIDriver: "igb", NetInterface: "eth0"}, false},
+		{"invalid net interface", IRQFilter{NetInterface: "(eth"}, true},
+		{"blocks", IRQFilter{Any: []IRQFilter{{Actions: "iwlwifi"}, {Actions: "e1000e"}},
+			Not: &IRQFilter{All: []IRQFilter{{Number: "0-1"}, {Type: "edge"}}}}, false},
+		{"invalid regex in any", IRQFilter{Any: []IRQFilter{{Actions: "nvme"}, {Name: "(?!a)"}}}, true},
+		{"invalid numbers in not", IRQFilter{Not: &IRQFilter{Number: "3-1"}}, true},
+		{"empty filter in all", IRQFilter{All: []IRQFilter{{}}}, true},
+		{"empty not", IRQFilter{Actions: "nvme", Not: &IRQFilter{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("IRQFilter.Validate() error = %v, wantErr %v",
+					err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIRQFilterYAML(t *testing.T) {
+	var filter IRQFilter
+	err := yaml.Unmarshal([]byte(`
+chip-name: "PCI-MSI"
+any:
+  - actions: "iwlwifi"
+  - pci-driver: "e1000e"
+not:
+  name: "edge"
+`), &filter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "{chip-name: PCI-MSI, any: [{actions: iwlwifi}, {pci-driver: e1000e}], not: {name: edge}}"
+	if got := filter.String(); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestParseNumbers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected map[int]bool
+	}{
+		{"3,10-12,524288", map[int]bool{3: true, 4: false, 10: true, 12: true, 13: false, 524288: true}},
+		{"1, 3", map[int]bool{1: true, 2: false, 3: true}},
+		{"0-7:2/4", map[int]bool{0: true, 1: true, 2: false, 4: true, 5: true, 6: false}},
+	}
+	for _, tc := range tests {
+		numbers, err := ParseNumbers(tc.input)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tc.input, err)
+		}
+		for n, expected := range tc.expected {
+			if numbers[n] != expected {
+				t.Errorf("%q: expected %d in the list to be %v", tc.input, n, expected)
+			}
+		}
+	}
+	for _, invalid := range []string{"", "a", "1-", "-1", "5-2", "1,,2", "all", "0-N"} {
+		if _, err := ParseNumbers(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
 type mockDirEntry struct {
 	name  string
 	isDir bool
@@ -99,7 +183,20 @@ func (m *mockDirEntry) Info() (os.FileInfo, error) {
 	return nil, nil
 }
 
+// mockFS lists the same entries for any directory
+type mockFS struct {
+	sysfs.FS
+	entries []os.DirEntry
+	err     error
+}
+
+func (m mockFS) ReadDir(_ string) ([]os.DirEntry, error) {
+	return m.entries, m.err
+}
+
 func TestGetHigherIRQ(t *testing.T) {
+	t.Cleanup(func() { sysfs.Root = sysfs.Dir("/") })
+
 	tests := []struct {
 		name       string
 		entries    []os.DirEntry
@@ -143,9 +240,7 @@ func TestGetHigherIRQ(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			readDir = func(_ string) ([]os.DirEntry, error) {
-				return tc.entries, tc.err
-			}
+			sysfs.Root = mockFS{entries: tc.entries, err: tc.err}
 			num, err := GetHigherIRQ()
 			if tc.err != nil {
 				if err == nil {
@@ -165,3 +260,71 @@ func TestGetHigherIRQ(t *testing.T) {
 		})
 	}
 }
+
+func TestIRQsValidate(t *testing.T) {
+	setupCPUs(t, "0-7")
+
+	tests := []struct {
+		name   string
+		c      IRQs
+		remove string
+		handle string
+		err    string
+	}{
+		{
+			name:   "remove only",
+			c:      IRQs{IsolateCPU: "2-7"},
+			remove: "2-7",
+			handle: "0-1",
+		},
+		{
+			name:   "handle only",
+			c:      IRQs{IRQHandler: "0"},
+			remove: "1-7",
+			handle: "0",
+		},
+		{
+			name:   "both",
+			c:      IRQs{IsolateCPU: "4-7", IRQHandler: "0-1"},
+			remove: "4-7",
+			handle: "0-1",
+		},
+		{
+			name: "overlapping",
+			c:    IRQs{IsolateCPU: "1-7", IRQHandler: "0-1"},
+			err:  "CPUs 1 are both in remove-from-cpus and handle-on-cpus",
+		},
+		{
+			name: "all removed",
+			c:    IRQs{IsolateCPU: "0-7"},
+			err:  "no CPU left to handle the IRQs",
+		},
+		{
+			name: "invalid CPU list",
+			c:    IRQs{IRQHandler: "0-8"},
+			err:  "invalid handle-on-cpus",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.c.Validate()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			remove, handle, _ := tc.c.CPUs()
+			if got := cpulists.GenCPUlist(remove.List()); got != tc.remove {
+				t.Errorf("expected remove-from-cpus %s, got %s", tc.remove, got)
+			}
+			if got := cpulists.GenCPUlist(handle.List()); got != tc.handle {
+				t.Errorf("expected handle-on-cpus %s, got %s", tc.handle, got)
+			}
+		})
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"slices"
 	"strings"
 
-	"github.com/canonical/rt-conf/src/cpulists"
+	"github.com/canonical/rt-conf/src/system"
 )
 
 var isolcpuFlags = []string{"domain", "nohz", "managed_irq"}
@@ -14,21 +15,33 @@ var isolcpuFlags = []string{"domain", "nohz", "managed_irq"}
 // KernelCmdline represents the kernel command line options.
 type KernelCmdline struct {
 	Parameters []string `yaml:"parameters"`
+	// Remove lists parameters to drop from the existing command line,
+	// either by name or as name=value to only drop that setting
+	Remove []string `yaml:"remove"`
+	// Grub holds the options only used with GRUB
+	Grub GrubOptions `yaml:"grub"`
+	// Bootloader overrides the detected bootloader, see system.SystemNames
+	Bootloader string `yaml:"bootloader"`
 }
 
-const (
-	// Maximum kernel command line characters length per architecture.
-	// See COMMAND_LINE_SIZE macro in kernel source code:
-	// - arch/x86/include/asm/setup.h
-	// - arch/arm64/include/uapi/asm/setup.h
-	// The value 2048 is defined for amd64 and arm64
-	CommandLineSize = 2048
-)
+// GrubOptions select the kernel GRUB boots with the parameters.
+type GrubOptions struct {
+	// DefaultRTKernel makes the newest real-time kernel the default entry
+	DefaultRTKernel bool `yaml:"default-rt-kernel"`
+	// RTKernelOnly applies the parameters to a dedicated entry booting the
+	// newest real-time kernel, made the default one, instead of all kernels
+	RTKernelOnly bool `yaml:"rt-kernel-only"`
+}
+
+// IsEmpty reports whether there is no parameter to set nor remove.
+func (k KernelCmdline) IsEmpty() bool {
+	return len(k.Parameters) == 0 && len(k.Remove) == 0
+}
 
 // Regex for valid parameter names
 // - must start with letters
 // - can contain: letters, digits, underscores, dots, hyphens
-var validName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9\._\-]*`)
+var validName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9._-]*$`)
 
 // validateParameterFormat performs syntax validation by checking kernel parameter formatting rules
 func (k KernelCmdline) validateParameterFormat() error {
@@ -36,10 +49,11 @@ func (k KernelCmdline) validateParameterFormat() error {
 	for _, p := range k.Parameters {
 		// Total length includes spaces between parameters so +1 for each param
 		// unless it's the last one, but we are not checking that here, which gives us
-		// a pratical limit of CommandLineSize -1 characters.
+		// a pratical limit of CommandLineSize() - 1 characters.
 		totalLen += len(p) + 1
-		if totalLen > CommandLineSize {
-			return fmt.Errorf("command line exceeds maximum length of %d bytes", CommandLineSize)
+		if totalLen > CommandLineSize() {
+			return fmt.Errorf("command line exceeds maximum length of %d bytes on %s",
+				CommandLineSize(), TargetArch())
 		}
 
 		keyValue := strings.SplitN(p, "=", 2)
@@ -53,49 +67,97 @@ func (k KernelCmdline) validateParameterFormat() error {
 		if !validName.MatchString(key) {
 			return fmt.Errorf("invalid parameter name: %q", key)
 		}
+
+		// Values containing whitespace get quoted on the command line
+		token, err := QuoteParam(p)
+		if err != nil {
+			return err
+		}
+		totalLen += len(token) - len(p)
+		if totalLen > CommandLineSize() {
+			return fmt.Errorf("command line exceeds maximum length of %d bytes on %s",
+				CommandLineSize(), TargetArch())
+		}
 	}
 
 	return nil
 }
 
+// Tokens returns the parameters as written on the kernel command line,
+// with the values containing whitespace quoted, see QuoteParam.
+func (k KernelCmdline) Tokens() ([]string, error) {
+	tokens := make([]string, 0, len(k.Parameters))
+	for _, p := range k.Parameters {
+		token, err := QuoteParam(p)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// validateRemove checks the parameters to remove, which can't be set at
+// the same time.
+func (k KernelCmdline) validateRemove() error {
+	for _, r := range k.Remove {
+		key := ParamKey(r)
+		if key == "" {
+			return fmt.Errorf("empty parameter to remove detected")
+		}
+		if !validName.MatchString(key) {
+			return fmt.Errorf("invalid parameter name to remove: %q", key)
+		}
+		if _, err := QuoteParam(r); err != nil {
+			return err
+		}
+		for _, p := range k.Parameters {
+			if RemovedBy([]string{r}, p) {
+				return fmt.Errorf("parameter %q is both set and removed by %q", p, r)
+			}
+		}
+	}
+	return nil
+}
+
 // Validate performs comprehensive validation
 func (k KernelCmdline) Validate() error {
+	if k.Bootloader != "" {
+		if _, err := system.ParseSystemType(k.Bootloader); err != nil {
+			return err
+		}
+	}
 	if err := k.validateParameterFormat(); err != nil {
 		return err
 	}
-	return k.validateParameterValues()
+	if err := k.validateRemove(); err != nil {
+		return err
+	}
+	if err := k.validateParameterValues(); err != nil {
+		return err
+	}
+	return k.validateConsistency()
 }
 
-// validateParameterValues performs semantic validation on known parameters for specific rules
+// validateParameterValues performs semantic validation of the parameters
+// known to rt-conf, see kernelParams
 func (k KernelCmdline) validateParameterValues() error {
+	arch := TargetArch()
 	for _, p := range k.Parameters {
+		key, value, hasValue := strings.Cut(UnquoteParam(p), "=")
 
-		// Split parameter into key and value
-		parts := strings.SplitN(p, "=", 2)
-		if len(parts) < 2 {
-			// No value, skip validation for boolean parameters
+		def, ok := LookupParam(key)
+		if archs, family := familyArchs(key); !ok && family && !slices.Contains(archs, arch) {
+			log.Printf("Warning: Parameter %q only applies to %s, not to %s",
+				key, strings.Join(archs, ", "), arch)
 			continue
 		}
-
-		key := parts[0]
-		value := parts[1]
-
-		// Validate parameters based on key
-		switch key {
-		case "kthread_cpus", "irqaffinity", "rcu_nocbs", "nohz_full":
-			if _, err := cpulists.Parse(value); err != nil {
-				return fmt.Errorf("%q does not contain a valid CPU List: %q: %v", key, value, err)
-			}
-		case "isolcpus":
-			if _, _, err := cpulists.ParseWithFlags(value, isolcpuFlags); err != nil {
-				return fmt.Errorf("%q has an invalid value: %q: %v", key, value, err)
-			}
-		case "nohz":
-			if value != "on" && value != "off" {
-				return fmt.Errorf("%q must be set to either 'on' or 'off', got %q", key, value)
-			}
-		default:
+		if !ok {
 			log.Printf("Warning: Parameter %q not recognized by rt-conf; skipping specific validation", key)
+			continue
+		}
+		if err := def.validate(key, value, hasValue, arch); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -119,13 +181,14 @@ func (k KernelCmdline) HasDuplicates() error {
 			value = ""
 		}
 
-		if existingValue, exists := params[key]; exists {
+		// Dashes and underscores set the same parameter, see normalizeKey
+		if existingValue, exists := params[normalizeKey(key)]; exists {
 			// Allow duplicate parameters with the same value
 			if existingValue != value {
 				return fmt.Errorf("duplicate parameter %q with different values: %q and %q", key, existingValue, value)
 			}
 		} else {
-			params[key] = value
+			params[normalizeKey(key)] = value
 		}
 	}
 	return nil
@@ -262,6 +262,26 @@ func TestKcmdDuplicates(t *testing.T) {
 			},
 			ExpectErr: true,
 		},
+		{
+			Name: "Duplicated parameter spelled with a dash",
+			Cfg: model.KernelCmdline{
+				Parameters: []string{
+					"intel-pstate=active",
+					"intel_pstate=disable",
+				},
+			},
+			ExpectErr: true,
+		},
+		{
+			Name: "Same parameter spelled with a dash",
+			Cfg: model.KernelCmdline{
+				Parameters: []string{
+					"intel-pstate=active",
+					"intel_pstate=active",
+				},
+			},
+			ExpectErr: false,
+		},
 		{
 			Name: "Tag parameter duplicated",
 			Cfg: model.KernelCmdline{
@@ -362,6 +382,22 @@ func TestKcmdValidation(t *testing.T) {
 			},
 			ExpectErr: false,
 		},
+		{
+			Name: "valid bootloader",
+			Cfg: model.KernelCmdline{
+				Parameters: []string{"nohz"},
+				Bootloader: "grub",
+			},
+			ExpectErr: false,
+		},
+		{
+			Name: "invalid bootloader",
+			Cfg: model.KernelCmdline{
+				Parameters: []string{"nohz"},
+				Bootloader: "lilo",
+			},
+			ExpectErr: true,
+		},
 		{
 			Name: "valid not validated parameter",
 			Cfg: model.KernelCmdline{
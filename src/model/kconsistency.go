@@ -0,0 +1,213 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/cpulists"
+)
+
+// cpuIssue is an inconsistency between the CPU isolation parameters.
+// Fatal issues are rejected, the others are reported as warnings.
+type cpuIssue struct {
+	Fatal bool
+	Msg   string
+}
+
+// cpuLists returns the CPUs of the last parameter setting key, along with
+// the flags of isolcpus. Unset or invalid values return nil CPUs, invalid
+// values are reported by validateParameterValues.
+func (k KernelCmdline) cpuLists(key string, totalCPUs int) (cpulists.CPUs, cpulists.Flags) {
+	for i := len(k.Parameters) - 1; i >= 0; i-- {
+		pKey, value, hasValue := strings.Cut(k.Parameters[i], "=")
+		if normalizeKey(pKey) != key {
+			continue
+		}
+		if !hasValue {
+			return nil, nil
+		}
+		if key == "isolcpus" {
+			cpus, flags, err := cpulists.ParseWithFlagsForCPUs(value, isolcpuFlags, totalCPUs)
+			if err != nil {
+				return nil, nil
+			}
+			return cpus, flags
+		}
+		cpus, err := cpulists.ParseForCPUs(value, totalCPUs)
+		if err != nil {
+			return nil, nil
+		}
+		return cpus, nil
+	}
+	return nil, nil
+}
+
+// cpuConsistency checks the relationships between the CPU isolation
+// parameters, on a system with totalCPUs CPUs.
+func (k KernelCmdline) cpuConsistency(totalCPUs int) []cpuIssue {
+	isolcpus, isolFlags := k.cpuLists("isolcpus", totalCPUs)
+	nohzFull, _ := k.cpuLists("nohz_full", totalCPUs)
+	rcuNocbs, _ := k.cpuLists("rcu_nocbs", totalCPUs)
+	irqaffinity, _ := k.cpuLists("irqaffinity", totalCPUs)
+	kthreadCPUs, _ := k.cpuLists("kthread_cpus", totalCPUs)
+
+	var issues []cpuIssue
+	warn := func(format string, args ...any) {
+		issues = append(issues, cpuIssue{Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if isolcpus != nil && nohzFull != nil && !sameCPUs(isolcpus, nohzFull) {
+		if isolFlags["nohz"] {
+			// The kernel keeps the first of both and ignores the other one
+			issues = append(issues, cpuIssue{Fatal: true, Msg: fmt.Sprintf(
+				"isolcpus with the nohz flag (%s) and nohz_full=%s must contain the same CPUs, "+
+					"the kernel ignores nohz_full otherwise",
+				formatCPUs(isolcpus), formatCPUs(nohzFull))})
+		} else {
+			warn("isolcpus=%s and nohz_full=%s contain different CPUs: the CPUs "+
+				"isolated from the scheduler still get the timer tick, or the other way around",
+				formatCPUs(isolcpus), formatCPUs(nohzFull))
+		}
+	}
+
+	// The kernel offloads the nohz_full CPUs by itself unless rcu_nocbs is set
+	if nohzFull != nil && rcuNocbs != nil {
+		if missing := cpusNotIn(nohzFull, rcuNocbs); missing != nil {
+			warn("nohz_full CPUs %s are not in rcu_nocbs=%s: RCU callbacks "+
+				"still interrupt them", formatCPUs(missing), formatCPUs(rcuNocbs))
+		}
+	}
+
+	isolated := make(cpulists.CPUs)
+	for cpu := range isolcpus {
+		isolated[cpu] = true
+	}
+	for cpu := range nohzFull {
+		isolated[cpu] = true
+	}
+
+	if overlap := cpusIn(irqaffinity, isolated); overlap != nil {
+		warn("irqaffinity=%s contains the isolated CPUs %s: interrupts can be "+
+			"routed to them", formatCPUs(irqaffinity), formatCPUs(overlap))
+	}
+	if overlap := cpusIn(kthreadCPUs, isolated); overlap != nil {
+		warn("kthread_cpus=%s contains the isolated CPUs %s: kernel threads can "+
+			"run on them", formatCPUs(kthreadCPUs), formatCPUs(overlap))
+	}
+
+	if len(isolated) > 0 && len(isolated) >= totalCPUs {
+		warn("all the %d CPUs are isolated, leaving no housekeeping CPU: the "+
+			"kernel falls back to the boot CPU", totalCPUs)
+	}
+	return issues
+}
+
+// validateConsistency rejects the fatal inconsistencies between the CPU
+// isolation parameters and logs the other ones as warnings.
+func (k KernelCmdline) validateConsistency() error {
+	if !slices.ContainsFunc(k.Parameters, isCPUListParam) {
+		return nil
+	}
+	totalCPUs, err := cpulists.TotalCPUs()
+	if err != nil {
+		return fmt.Errorf("failed to get total available CPUs: %v", err)
+	}
+	for _, issue := range k.cpuConsistency(totalCPUs) {
+		if issue.Fatal {
+			return fmt.Errorf("%s", issue.Msg)
+		}
+		log.Printf("Warning: %s", issue.Msg)
+	}
+	return nil
+}
+
+// ValidateCmdline validates the effective kernel command line, the result
+// of applying the configuration to the existing parameters. Inconsistencies
+// already reported for the configuration are skipped.
+func (k KernelCmdline) ValidateCmdline(existing, final []string) error {
+	for _, r := range k.Remove {
+		if !slices.ContainsFunc(existing, func(p string) bool {
+			return RemovedBy([]string{r}, p)
+		}) {
+			log.Printf("Warning: Parameter %q to remove is not on the kernel command line", r)
+		}
+	}
+
+	if len(strings.Join(final, " ")) > CommandLineSize()-1 {
+		return fmt.Errorf("command line exceeds maximum length of %d bytes on %s",
+			CommandLineSize(), TargetArch())
+	}
+
+	effective := KernelCmdline{Parameters: final}
+	if !slices.ContainsFunc(final, isCPUListParam) {
+		return nil
+	}
+	totalCPUs, err := cpulists.TotalCPUs()
+	if err != nil {
+		return fmt.Errorf("failed to get total available CPUs: %v", err)
+	}
+
+	reported := make(map[string]bool)
+	for _, issue := range k.cpuConsistency(totalCPUs) {
+		reported[issue.Msg] = true
+	}
+	for _, issue := range effective.cpuConsistency(totalCPUs) {
+		if reported[issue.Msg] {
+			continue
+		}
+		if issue.Fatal {
+			return fmt.Errorf("resulting kernel command line: %s", issue.Msg)
+		}
+		log.Printf("Warning: resulting kernel command line: %s", issue.Msg)
+	}
+	return nil
+}
+
+// isCPUListParam reports whether p sets a parameter taking a CPU list.
+func isCPUListParam(p string) bool {
+	def, ok := LookupParam(ParamKey(p))
+	return ok && def.Type == ParamCPUList
+}
+
+// cpusIn returns the CPUs of a also in b, nil if none.
+func cpusIn(a, b cpulists.CPUs) cpulists.CPUs {
+	var in cpulists.CPUs
+	for cpu := range a {
+		if b[cpu] {
+			if in == nil {
+				in = make(cpulists.CPUs)
+			}
+			in[cpu] = true
+		}
+	}
+	return in
+}
+
+// cpusNotIn returns the CPUs of a missing from b, nil if none.
+func cpusNotIn(a, b cpulists.CPUs) cpulists.CPUs {
+	var out cpulists.CPUs
+	for cpu := range a {
+		if !b[cpu] {
+			if out == nil {
+				out = make(cpulists.CPUs)
+			}
+			out[cpu] = true
+		}
+	}
+	return out
+}
+
+func sameCPUs(a, b cpulists.CPUs) bool {
+	return len(a) == len(b) && cpusNotIn(a, b) == nil
+}
+
+func formatCPUs(cpus cpulists.CPUs) string {
+	list := make([]int, 0, len(cpus))
+	for cpu := range cpus {
+		list = append(list, cpu)
+	}
+	slices.Sort(list)
+	return cpulists.GenCPUlist(list)
+}
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCPUConsistency(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []string
+		fatal  bool
+		issues []string
+	}{
+		{
+			name:   "Consistent isolation",
+			params: []string{"isolcpus=2-7", "nohz_full=2-7", "rcu_nocbs=2-7", "irqaffinity=0-1", "kthread_cpus=0-1"},
+		},
+		{
+			name:   "No CPU isolation",
+			params: []string{"quiet", "threadirqs"},
+		},
+		{
+			name:   "nohz_full without rcu_nocbs",
+			params: []string{"nohz_full=2-7"},
+		},
+		{
+			name:   "nohz_full not in rcu_nocbs",
+			params: []string{"nohz_full=2-3", "rcu_nocbs=0-1"},
+			issues: []string{"nohz_full CPUs 2-3 are not in rcu_nocbs=0-1"},
+		},
+		{
+			name:   "irqaffinity overlaps isolcpus",
+			params: []string{"isolcpus=2-7", "irqaffinity=0-3"},
+			issues: []string{"irqaffinity=0-3 contains the isolated CPUs 2-3"},
+		},
+		{
+			name:   "kthread_cpus overlaps nohz_full",
+			params: []string{"nohz_full=4-7", "kthread_cpus=0,4"},
+			issues: []string{"kthread_cpus=0,4 contains the isolated CPUs 4"},
+		},
+		{
+			name:   "isolcpus nohz flag differs from nohz_full",
+			params: []string{"isolcpus=nohz,2-7", "nohz_full=4-7"},
+			fatal:  true,
+			issues: []string{"isolcpus with the nohz flag (2-7) and nohz_full=4-7 must contain the same CPUs"},
+		},
+		{
+			name:   "isolcpus with several flags differs from nohz_full",
+			params: []string{"isolcpus=managed_irq,nohz,domain,2-7", "nohz_full=4-7"},
+			fatal:  true,
+			issues: []string{"isolcpus with the nohz flag (2-7) and nohz_full=4-7"},
+		},
+		{
+			name:   "isolcpus nohz flag matches nohz_full",
+			params: []string{"isolcpus=nohz,2-7", "nohz_full=2-7"},
+		},
+		{
+			name:   "isolcpus differs from nohz_full",
+			params: []string{"isolcpus=domain,2-3", "nohz-full=2-7"},
+			issues: []string{"isolcpus=2-3 and nohz_full=2-7 contain different CPUs"},
+		},
+		{
+			name:   "No housekeeping CPU",
+			params: []string{"isolcpus=0-3", "nohz_full=4-N"},
+			issues: []string{
+				"isolcpus=0-3 and nohz_full=4-7 contain different CPUs",
+				"all the 8 CPUs are isolated, leaving no housekeeping CPU",
+			},
+		},
+		{
+			name:   "Last parameter wins",
+			params: []string{"rcu_nocbs=0-1", "nohz_full=2-3", "rcu_nocbs=2-3"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			k := KernelCmdline{Parameters: tc.params}
+			issues := k.cpuConsistency(8)
+			if len(issues) != len(tc.issues) {
+				t.Fatalf("expected %d issues, got %+v", len(tc.issues), issues)
+			}
+			for i, issue := range issues {
+				if !strings.Contains(issue.Msg, tc.issues[i]) {
+					t.Errorf("expected issue containing %q, got %q", tc.issues[i], issue.Msg)
+				}
+				if issue.Fatal != tc.fatal {
+					t.Errorf("expected fatal %v, got %v: %s", tc.fatal, issue.Fatal, issue.Msg)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/cpulists"
+)
+
+// ParamType is the type of the value of a kernel parameter
+type ParamType int
+
+const (
+	// ParamFlag takes no value
+	ParamFlag ParamType = iota
+	// ParamBool takes a boolean, as parsed by kstrtobool
+	ParamBool
+	// ParamInt takes a non-negative integer, within Min and Max
+	ParamInt
+	// ParamEnum takes one of Values
+	ParamEnum
+	// ParamEnumList takes a comma separated list of Values
+	ParamEnumList
+	// ParamCPUList takes a CPU list, optionally preceded by some of Flags
+	ParamCPUList
+	// ParamString takes any non-empty value
+	ParamString
+)
+
+// ParamDef defines a kernel parameter known to rt-conf.
+// See: https://docs.kernel.org/admin-guide/kernel-parameters.html
+type ParamDef struct {
+	Type ParamType
+	// Values allowed for enums
+	Values []string
+	// Flags allowed before a CPU list, e.g. isolcpus=domain,1-3
+	Flags []string
+	// Range of integers, no maximum when Max is 0
+	Min, Max int
+	// Architectures supporting the parameter, all when empty
+	Archs []string
+}
+
+// Architecture specific parameters
+var (
+	x86   = []string{"amd64"}
+	arm   = []string{"arm64", "armhf"}
+	arm64 = []string{"arm64"}
+)
+
+// kernelParams holds the definitions of the kernel parameters known to
+// rt-conf, indexed by name with dashes replaced by underscores.
+var kernelParams = map[string]ParamDef{
+	// CPU isolation
+	"isolcpus":      {Type: ParamCPUList, Flags: isolcpuFlags},
+	"nohz":          {Type: ParamEnum, Values: []string{"on", "off"}},
+	"nohz_full":     {Type: ParamCPUList},
+	"kthread_cpus":  {Type: ParamCPUList},
+	"irqaffinity":   {Type: ParamCPUList},
+	"rcu_nocbs":     {Type: ParamCPUList},
+	"rcu_nocb_poll": {Type: ParamFlag},
+	"skew_tick":     {Type: ParamInt, Max: 1},
+	"threadirqs":    {Type: ParamFlag},
+	"preempt":       {Type: ParamEnum, Values: []string{"none", "voluntary", "full", "lazy"}},
+
+	// RCU
+	"rcutree.kthread_prio":            {Type: ParamInt, Max: 99},
+	"rcupdate.rcu_cpu_stall_suppress": {Type: ParamBool},
+	"rcupdate.rcu_normal_after_boot":  {Type: ParamBool},
+
+	// Power management and idle states
+	"idle":                  {Type: ParamEnum, Values: []string{"poll", "halt", "nomwait"}, Archs: x86},
+	"processor.max_cstate":  {Type: ParamInt, Max: 9, Archs: x86},
+	"intel_idle.max_cstate": {Type: ParamInt, Archs: x86},
+	"cpuidle.off":           {Type: ParamInt, Max: 1},
+	"cpufreq.default_governor": {Type: ParamEnum, Values: []string{
+		"performance", "powersave", "userspace", "ondemand", "conservative", "schedutil",
+	}},
+	"intel_pstate": {Type: ParamEnum, Values: []string{
+		"disable", "active", "passive", "force", "no_hwp", "hwp_only",
+		"per_cpu_perf_limits", "support_acpi_ppc", "no_cas",
+	}, Archs: x86},
+	"amd_pstate": {Type: ParamEnum, Values: []string{"disable", "passive", "active", "guided"}, Archs: x86},
+
+	// Timers and clocks
+	"tsc": {Type: ParamEnum, Values: []string{
+		"reliable", "noirqtime", "unstable", "nowatchdog", "recalibrate", "watchdog",
+	}, Archs: x86},
+	"clocksource": {Type: ParamString},
+	"hpet":        {Type: ParamEnum, Values: []string{"disable", "force", "verbose", "nocheck"}, Archs: x86},
+
+	// Watchdogs and lockup detectors
+	"nosoftlockup":     {Type: ParamFlag},
+	"nowatchdog":       {Type: ParamFlag},
+	"softlockup_panic": {Type: ParamInt, Max: 1},
+	"nmi_watchdog":     {Type: ParamEnumList, Values: []string{"0", "1", "panic", "nopanic"}},
+
+	// Machine checks, auditing and mitigations
+	"mce": {Type: ParamEnum, Values: []string{
+		"off", "no_cmci", "dont_log_ce", "ignore_ce", "bootlog", "nobootlog",
+		"bios_cmci_threshold", "recovery",
+	}, Archs: x86},
+	"audit":       {Type: ParamEnum, Values: []string{"0", "1", "off", "on"}},
+	"mitigations": {Type: ParamEnumList, Values: []string{"off", "auto", "nosmt"}},
+	"nosmt":       {Type: ParamFlag},
+
+	// Memory
+	"transparent_hugepage":      {Type: ParamEnum, Values: []string{"always", "madvise", "never"}},
+	"numa_balancing":            {Type: ParamEnum, Values: []string{"enable", "disable"}},
+	"hugepages":                 {Type: ParamInt},
+	"workqueue.power_efficient": {Type: ParamBool},
+
+	// Interrupt controllers
+	"irqchip.gicv3_nolpi":      {Type: ParamFlag, Archs: arm},
+	"irqchip.gicv3_pseudo_nmi": {Type: ParamInt, Max: 1, Archs: arm64},
+}
+
+// paramFamilies maps the prefixes of architecture specific parameters to
+// the architectures supporting them, to warn about the parameters unknown
+// to rt-conf that don't apply to the target architecture.
+var paramFamilies = map[string][]string{
+	"intel_idle.":  x86,
+	"intel_pstate": x86,
+	"amd_pstate":   x86,
+	"intel_iommu":  x86,
+	"amd_iommu":    x86,
+	"kvm_intel.":   x86,
+	"kvm_amd.":     x86,
+	"irqchip.gic":  arm,
+	"arm64.":       arm64,
+	"kvm_arm.":     arm64,
+}
+
+// familyArchs returns the architectures supporting the family of the
+// parameter key, if it belongs to an architecture specific one.
+func familyArchs(key string) ([]string, bool) {
+	for prefix, archs := range paramFamilies {
+		if strings.HasPrefix(normalizeKey(key), normalizeKey(prefix)) {
+			return archs, true
+		}
+	}
+	return nil, false
+}
+
+// LookupParam returns the definition of a kernel parameter known to rt-conf.
+func LookupParam(key string) (ParamDef, bool) {
+	def, ok := kernelParams[normalizeKey(key)]
+	return def, ok
+}
+
+// SupportsArch reports whether the parameter is supported on arch.
+func (d ParamDef) SupportsArch(arch string) bool {
+	return len(d.Archs) == 0 || slices.Contains(d.Archs, arch)
+}
+
+// validate checks the value of the parameter key, on the architecture arch.
+// Parameters set without a value are left to the kernel.
+func (d ParamDef) validate(key, value string, hasValue bool, arch string) error {
+	if !d.SupportsArch(arch) {
+		return fmt.Errorf("%q is not supported on %s, only on %s",
+			key, arch, strings.Join(d.Archs, ", "))
+	}
+	if !hasValue {
+		return nil
+	}
+
+	switch d.Type {
+	case ParamFlag:
+		return fmt.Errorf("%q does not take a value, got %q", key, value)
+	case ParamBool:
+		if _, err := parseKernelBool(value); err != nil {
+			return fmt.Errorf("%q must be a boolean, got %q", key, value)
+		}
+	case ParamInt:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < d.Min || (d.Max != 0 && n > d.Max) {
+			if d.Max != 0 {
+				return fmt.Errorf("%q must be an integer between %d and %d, got %q",
+					key, d.Min, d.Max, value)
+			}
+			return fmt.Errorf("%q must be an integer of at least %d, got %q",
+				key, d.Min, value)
+		}
+	case ParamEnum:
+		if !slices.Contains(d.Values, value) {
+			return fmt.Errorf("%q must be one of %s, got %q",
+				key, strings.Join(d.Values, ", "), value)
+		}
+	case ParamEnumList:
+		for _, v := range strings.Split(value, ",") {
+			if !slices.Contains(d.Values, v) {
+				return fmt.Errorf("%q must be a comma separated list of %s, got %q",
+					key, strings.Join(d.Values, ", "), value)
+			}
+		}
+	case ParamCPUList:
+		if len(d.Flags) > 0 {
+			if _, _, err := cpulists.ParseWithFlags(value, d.Flags); err != nil {
+				return fmt.Errorf("%q has an invalid value: %q: %v", key, value, err)
+			}
+			return nil
+		}
+		if _, err := cpulists.Parse(value); err != nil {
+			return fmt.Errorf("%q does not contain a valid CPU List: %q: %v", key, value, err)
+		}
+	case ParamString:
+		if value == "" {
+			return fmt.Errorf("%q must not be empty", key)
+		}
+	}
+	return nil
+}
+
+// parseKernelBool parses a boolean the way the kernel kstrtobool does.
+func parseKernelBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "y", "yes", "on", "true":
+		return true, nil
+	case "0", "n", "no", "off", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", value)
+}
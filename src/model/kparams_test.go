@@ -0,0 +1,110 @@
+package model
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParamDefValidate(t *testing.T) {
+	tests := []struct {
+		param string
+		arch  string
+		err   string
+	}{
+		{param: "rcu_nocb_poll"},
+		{param: "rcu_nocb_poll=1", err: "does not take a value"},
+		{param: "threadirqs"},
+		{param: "nosoftlockup"},
+		{param: "skew_tick=1"},
+		{param: "skew_tick=2", err: "must be an integer between 0 and 1"},
+		{param: "rcutree.kthread_prio=99"},
+		{param: "rcutree.kthread_prio=-1", err: "must be an integer between 0 and 99"},
+		{param: "intel_idle.max_cstate=0"},
+		{param: "intel_idle.max_cstate=off", err: "must be an integer of at least 0"},
+		{param: "processor.max_cstate=1"},
+		{param: "processor.max_cstate=10", err: "between 0 and 9"},
+		{param: "idle=poll"},
+		{param: "idle=mwait", err: "must be one of poll, halt, nomwait"},
+		{param: "intel_pstate=disable"},
+		{param: "intel_pstate=disable", arch: "arm64", err: "not supported on arm64, only on amd64"},
+		{param: "tsc=reliable"},
+		{param: "tsc=stable", err: "must be one of"},
+		{param: "nmi_watchdog=0"},
+		{param: "nmi_watchdog=panic,1"},
+		{param: "nmi_watchdog=2", err: "comma separated list of 0, 1, panic, nopanic"},
+		{param: "mce=off"},
+		{param: "mce=on", err: "must be one of"},
+		{param: "audit=0"},
+		{param: "audit=disabled", err: "must be one of"},
+		{param: "preempt=full"},
+		{param: "preempt=rt", err: "must be one of none, voluntary, full, lazy"},
+		{param: "mitigations=auto,nosmt"},
+		{param: "rcupdate.rcu_normal_after_boot=Y"},
+		{param: "rcupdate.rcu_normal_after_boot=maybe", err: "must be a boolean"},
+		{param: "clocksource=tsc"},
+		{param: "clocksource=", err: "must not be empty"},
+		{param: "rcu-nocbs=0"},
+		{param: "rcu_nocbs=a", err: "does not contain a valid CPU List"},
+		{param: "isolcpus=domain,0"},
+		{param: "isolcpus=nohz,domain,managed_irq,0"},
+		{param: "isolcpus=nohz,nohz,0", err: "duplicate flag: nohz"},
+		{param: "isolcpus=foo,1-3", err: "has an invalid value"},
+		{param: "irqchip.gicv3_pseudo_nmi=1", arch: "arm64"},
+		{param: "irqchip.gicv3_pseudo_nmi=1", arch: "armhf", err: "not supported on armhf, only on arm64"},
+		{param: "irqchip.gicv3_nolpi", arch: "armhf"},
+		{param: "irqchip.gicv3_nolpi", err: "not supported on amd64, only on arm64, armhf"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.param, func(t *testing.T) {
+			key, value, hasValue := strings.Cut(tc.param, "=")
+			def, ok := LookupParam(key)
+			if !ok {
+				t.Fatalf("parameter %q not found", key)
+			}
+			arch := tc.arch
+			if arch == "" {
+				arch = "amd64"
+			}
+
+			err := def.validate(key, value, hasValue, arch)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestFamilyArchs(t *testing.T) {
+	tests := []struct {
+		key    string
+		archs  []string
+		family bool
+	}{
+		{key: "intel_idle.states_off", archs: x86, family: true},
+		{key: "kvm-intel.nested", archs: x86, family: true},
+		{key: "irqchip.gicv2_force_probe", archs: arm, family: true},
+		{key: "foo"},
+	}
+
+	for _, tc := range tests {
+		archs, family := familyArchs(tc.key)
+		if family != tc.family || !slices.Equal(archs, tc.archs) {
+			t.Errorf("familyArchs(%q): expected %v %v, got %v %v",
+				tc.key, tc.archs, tc.family, archs, family)
+		}
+	}
+}
+
+func TestLookupParamUnknown(t *testing.T) {
+	if _, ok := LookupParam("amd_iommu"); ok {
+		t.Error("expected amd_iommu to be unknown")
+	}
+}
@@ -27,6 +27,10 @@ type CpuGovernanceRule struct {
 	ScalGov string `yaml:"scaling-governor"`
 	MinFreq string `yaml:"min-freq"`
 	MaxFreq string `yaml:"max-freq"`
+	// Priority orders the rules, the highest applies last and wins
+	Priority int `yaml:"priority"`
+
+	position int // position in the file, see PwrMgmt.UnmarshalYAML
 }
 
 func (c CpuGovernanceRule) Validate() error {
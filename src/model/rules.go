@@ -0,0 +1,175 @@
+package model
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/cpulists"
+	"go.yaml.in/yaml/v4"
+)
+
+// The rules of irq-tuning and cpu-governance apply by priority, from the
+// lowest to the highest, then in file order. The last rule applied to an
+// IRQ or a CPU takes precedence. snapd returns the snap options with their
+// keys sorted, so the rules set via snap options apply by name.
+
+// rankedRule is a rule with a priority and a position in the file
+type rankedRule interface {
+	rank() (priority int, position int)
+}
+
+func (c IRQTuning) rank() (int, int)         { return c.Priority, c.position }
+func (c CpuGovernanceRule) rank() (int, int) { return c.Priority, c.position }
+
+// ruleNames returns the names of the rules in the order they apply. Rules
+// on the same position, e.g. not read from a file, apply by name.
+func ruleNames[R rankedRule](rules map[string]R) []string {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		pa, oa := rules[a].rank()
+		pb, ob := rules[b].rank()
+		return cmp.Or(cmp.Compare(pa, pb), cmp.Compare(oa, ob), strings.Compare(a, b))
+	})
+	return names
+}
+
+// Names returns the names of the IRQ tuning rules in the order they apply.
+func (r Interrupts) Names() []string {
+	return ruleNames(r)
+}
+
+// Names returns the names of the CPU governance rules in the order they
+// apply.
+func (p PwrMgmt) Names() []string {
+	return ruleNames(p)
+}
+
+// keyPositions returns the position of each key of a YAML mapping.
+func keyPositions(value *yaml.Node) map[string]int {
+	positions := make(map[string]int)
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		positions[value.Content[i].Value] = i/2 + 1
+	}
+	return positions
+}
+
+// UnmarshalYAML keeps the file order of the rules.
+func (r *Interrupts) UnmarshalYAML(value *yaml.Node) error {
+	var rules map[string]IRQTuning
+	if err := value.Decode(&rules); err != nil {
+		return err
+	}
+	for name, pos := range keyPositions(value) {
+		rule := rules[name]
+		rule.position = pos
+		rules[name] = rule
+	}
+	*r = rules
+	return nil
+}
+
+// UnmarshalYAML keeps the file order of the rules.
+func (p *PwrMgmt) UnmarshalYAML(value *yaml.Node) error {
+	var rules map[string]CpuGovernanceRule
+	if err := value.Decode(&rules); err != nil {
+		return err
+	}
+	for name, pos := range keyPositions(value) {
+		rule := rules[name]
+		rule.position = pos
+		rules[name] = rule
+	}
+	*p = rules
+	return nil
+}
+
+// Settings returns the cpufreq settings set by the rule.
+func (c CpuGovernanceRule) Settings() []string {
+	var settings []string
+	if c.ScalGov != "" {
+		settings = append(settings, "scaling-governor")
+	}
+	if c.MinFreq != "" {
+		settings = append(settings, "min-freq")
+	}
+	if c.MaxFreq != "" {
+		settings = append(settings, "max-freq")
+	}
+	return settings
+}
+
+// Overlaps describes the CPU governance rules setting the same settings of
+// the same CPUs, and the one taking precedence.
+func (p PwrMgmt) Overlaps() ([]string, error) {
+	names := p.Names()
+	cpus := make(map[string]cpulists.CPUs, len(names))
+	for _, name := range names {
+		parsed, err := cpulists.Parse(p[name].CPUs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpus of rule %s: %v", name, err)
+		}
+		cpus[name] = parsed
+	}
+
+	var overlaps []string
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			var shared []int
+			for cpu := range cpus[a] {
+				if cpus[b][cpu] {
+					shared = append(shared, cpu)
+				}
+			}
+			if len(shared) == 0 {
+				continue
+			}
+			for _, s := range p[a].Settings() {
+				if slices.Contains(p[b].Settings(), s) {
+					overlaps = append(overlaps, fmt.Sprintf(
+						"CPU governance rules %s and %s both set the %s of CPUs %s, %s takes precedence",
+						a, b, s, cpulists.GenCPUlist(shared), b))
+				}
+			}
+		}
+	}
+	return overlaps, nil
+}
+
+// Overlaps describes the IRQ tuning rules known to match the same IRQs,
+// whatever the IRQs of the system: the ones with the same filter, or with an
+// empty one matching all the IRQs. The other overlaps are reported when
+// applying the rules.
+func (r Interrupts) Overlaps() []string {
+	names := r.Names()
+	var overlaps []string
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			fa, fb := r[a].Filter, r[b].Filter
+			if reflect.DeepEqual(fa, fb) || fa.IsEmpty() || fb.IsEmpty() {
+				overlaps = append(overlaps, fmt.Sprintf(
+					"IRQ tuning rules %s and %s match the same IRQs, %s takes precedence",
+					a, b, b))
+			}
+		}
+	}
+	return overlaps
+}
+
+// warnOverlaps logs the overlapping rules of the configuration.
+func (c Config) warnOverlaps() error {
+	overlaps, err := c.CpuGovernance.Overlaps()
+	if err != nil {
+		return err
+	}
+	for _, o := range append(c.Interrupts.Overlaps(), overlaps...) {
+		log.Printf("Warning: %s", o)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/rt-conf/src/sysfs"
+	"go.yaml.in/yaml/v4"
+)
+
+func TestRuleNames(t *testing.T) {
+	var c Config
+	err := yaml.Unmarshal([]byte(`
+irq-tuning:
+  zeta:
+    cpus: "0"
+  alpha:
+    cpus: "1"
+    priority: 1
+  mu:
+    cpus: "0"
+cpu-governance:
+  zeta:
+    cpus: "0"
+    priority: -1
+  alpha:
+    cpus: "1"
+`), &c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// By priority, then in file order
+	if names := c.Interrupts.Names(); !reflect.DeepEqual(names, []string{"zeta", "mu", "alpha"}) {
+		t.Errorf("unexpected IRQ tuning rules order: %v", names)
+	}
+	if names := c.CpuGovernance.Names(); !reflect.DeepEqual(names, []string{"zeta", "alpha"}) {
+		t.Errorf("unexpected CPU governance rules order: %v", names)
+	}
+
+	// By name, when not read from a file
+	rules := Interrupts{"zeta": {}, "alpha": {}, "mu": {Priority: -1}}
+	if names := rules.Names(); !reflect.DeepEqual(names, []string{"mu", "alpha", "zeta"}) {
+		t.Errorf("unexpected IRQ tuning rules order: %v", names)
+	}
+}
+
+// setupCPUs makes the CPU lists validated for a system with the present
+// CPUs, e.g. 0-3.
+func setupCPUs(t *testing.T, present string) {
+	t.Helper()
+	root := t.TempDir()
+	file := filepath.Join(root, "sys/devices/system/cpu/present")
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(present+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sysfs.SetRoot(root)
+	t.Cleanup(func() { sysfs.SetRoot("") })
+}
+
+func TestPwrMgmtOverlaps(t *testing.T) {
+	setupCPUs(t, "0-3")
+
+	rules := PwrMgmt{
+		"all":      {CPUs: "0-1", ScalGov: "powersave", position: 1},
+		"rt":       {CPUs: "1", ScalGov: "performance", MaxFreq: "2GHz", position: 2},
+		"max-freq": {CPUs: "0-1", MaxFreq: "1GHz", position: 3},
+	}
+	overlaps, err := rules.Overlaps()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"CPU governance rules all and rt both set the scaling-governor of CPUs 1, rt takes precedence",
+		"CPU governance rules rt and max-freq both set the max-freq of CPUs 1, max-freq takes precedence",
+	}
+	if !reflect.DeepEqual(overlaps, expected) {
+		t.Errorf("expected %q, got %q", expected, overlaps)
+	}
+}
+
+func TestInterruptsOverlaps(t *testing.T) {
+	rules := Interrupts{
+		"nvme":     {Filter: IRQFilter{Actions: "nvme"}, position: 1},
+		"eth0":     {Filter: IRQFilter{Actions: "eth0"}, position: 2},
+		"nvme-too": {Filter: IRQFilter{Actions: "nvme"}, position: 3},
+	}
+	expected := []string{
+		"IRQ tuning rules nvme and nvme-too match the same IRQs, nvme-too takes precedence",
+	}
+	if overlaps := rules.Overlaps(); !reflect.DeepEqual(overlaps, expected) {
+		t.Errorf("expected %q, got %q", expected, overlaps)
+	}
+
+	// Filters with the same blocks too
+	rules["not-nvme"] = IRQTuning{Filter: IRQFilter{Not: &IRQFilter{Actions: "nvme"}}, position: 4}
+	rules["not-nvme-too"] = IRQTuning{Filter: IRQFilter{Not: &IRQFilter{Actions: "nvme"}}, position: 5}
+	expected = append(expected,
+		"IRQ tuning rules not-nvme and not-nvme-too match the same IRQs, not-nvme-too takes precedence")
+	if overlaps := rules.Overlaps(); !reflect.DeepEqual(overlaps, expected) {
+		t.Errorf("expected %q, got %q", expected, overlaps)
+	}
+
+	rules["all"] = IRQTuning{position: 6}
+	if overlaps := rules.Overlaps(); len(overlaps) != 7 {
+		t.Errorf("expected the empty filter to overlap every rule, got %q", overlaps)
+	}
+}
@@ -3,37 +3,46 @@ package pwrmgmt
 import (
 	"fmt"
 	"log"
-	"os"
 	"strconv"
+	"strings"
 
 	"github.com/canonical/rt-conf/src/cpulists"
 	"github.com/canonical/rt-conf/src/model"
+	"github.com/canonical/rt-conf/src/sysfs"
 	"github.com/canonical/rt-conf/src/utils"
 )
 
-type ReaderWriter struct {
-	ScalingGovernorPath string
-	MinFreqPath         string
-	MaxFreqPath         string
-}
+// The cpufreq interfaces of each CPU, read and written through sysfs.Root
+// See: https://docs.kernel.org/admin-guide/pm/cpufreq.html
+const (
+	scalingGovernorPath = "/sys/devices/system/cpu/cpu%d/cpufreq/scaling_governor"
+	minFreqPath         = "/sys/devices/system/cpu/cpu%d/cpufreq/scaling_min_freq"
+	maxFreqPath         = "/sys/devices/system/cpu/cpu%d/cpufreq/scaling_max_freq"
+)
 
-var pwrmgmtReaderWriter = ReaderWriter{
-	ScalingGovernorPath: "/sys/devices/system/cpu/cpu%d/cpufreq/scaling_governor",
-	MinFreqPath:         "/sys/devices/system/cpu/cpu%d/cpufreq/scaling_min_freq",
-	MaxFreqPath:         "/sys/devices/system/cpu/cpu%d/cpufreq/scaling_max_freq",
+type ReaderWriter struct {
+	// DryRun only reports the values that would be written
+	DryRun bool
 }
 
 func writeOnly(path string, data string) error {
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
-	if err != nil {
-		return fmt.Errorf("error opening %s: %v", path, err)
+	if err := sysfs.Root.WriteFile(path, []byte(data)); err != nil {
+		return fmt.Errorf("error writing to %s: %v", path, err)
 	}
-	defer f.Close()
+	return nil
+}
 
-	_, err = f.Write([]byte(data))
+// write writes data to path, or reports it along with the current value
+// when in dry-run mode.
+func (w ReaderWriter) write(path string, data string) error {
+	if !w.DryRun {
+		return writeOnly(path, data)
+	}
+	current, err := sysfs.Root.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("error writing to %s: %v", path, err)
+		return fmt.Errorf("error reading %s: %v", path, err)
 	}
+	utils.LogPlannedWrite(path, strings.TrimSpace(string(current)), data)
 	return nil
 }
 
@@ -41,9 +50,9 @@ func (w ReaderWriter) WriteScalingGov(sclgov string, cpu int) error {
 	if sclgov == "" {
 		return nil // No scaling governor set, nothing to write
 	}
-	scalingGovFile := fmt.Sprintf(w.ScalingGovernorPath, cpu)
+	scalingGovFile := fmt.Sprintf(scalingGovernorPath, cpu)
 
-	err := writeOnly(scalingGovFile, sclgov)
+	err := w.write(scalingGovFile, sclgov)
 	if err != nil {
 		return fmt.Errorf("error writing to %s: %v", scalingGovFile, err)
 	}
@@ -52,16 +61,16 @@ func (w ReaderWriter) WriteScalingGov(sclgov string, cpu int) error {
 
 func (w ReaderWriter) WriteCPUFreq(freqMin, freqMax, cpu int) error {
 	if freqMin != -1 {
-		minFreqSysfs := fmt.Sprintf(w.MinFreqPath, cpu)
-		if err := writeOnly(minFreqSysfs,
+		minFreqSysfs := fmt.Sprintf(minFreqPath, cpu)
+		if err := w.write(minFreqSysfs,
 			strconv.Itoa(freqMin)); err != nil {
 			return fmt.Errorf("error writing to %s: %v", minFreqSysfs, err)
 		}
 	}
 
 	if freqMax != -1 {
-		maxFreqSysfs := fmt.Sprintf(w.MaxFreqPath, cpu)
-		if err := writeOnly(maxFreqSysfs,
+		maxFreqSysfs := fmt.Sprintf(maxFreqPath, cpu)
+		if err := w.write(maxFreqSysfs,
 			strconv.Itoa(freqMax)); err != nil {
 			return fmt.Errorf("error writing to %s: %v", maxFreqSysfs, err)
 		}
@@ -76,16 +85,20 @@ func ApplyPwrConfig(config *model.InternalConfig) error {
 		log.Println("No CPU governance rules found in config")
 		return nil
 	}
-	return pwrmgmtReaderWriter.applyPwrConfig(config.Data.CpuGovernance)
+	wr := ReaderWriter{DryRun: config.DryRun}
+	return wr.applyPwrConfig(config.Data.CpuGovernance)
 }
 
 // Apply changes based on YAML config
 func (wr ReaderWriter) applyPwrConfig(
 	rules model.PwrMgmt,
 ) error {
-	// Range over all CPU governance rules
-	for label, sclgov := range rules {
-
+	// owners are the rules setting each setting of each CPU, the last one
+	// applied takes precedence
+	owners := make(map[string]map[int]string)
+	names := rules.Names()
+	for _, label := range names {
+		sclgov := rules[label]
 		log.Printf("Rule: %s \n", label)
 		cpus, err := cpulists.Parse(sclgov.CPUs)
 		if err != nil {
@@ -100,34 +113,78 @@ func (wr ReaderWriter) applyPwrConfig(
 					label, cpu, err)
 			}
 			setCpus = append(setCpus, cpu)
+			for _, setting := range sclgov.Settings() {
+				if owners[setting] == nil {
+					owners[setting] = make(map[int]string)
+				}
+				owners[setting][cpu] = label
+			}
 		}
-		logChanges(setCpus, sclgov.MinFreq, sclgov.MaxFreq, sclgov.ScalGov)
+		logChanges(setCpus, sclgov.MinFreq, sclgov.MaxFreq, sclgov.ScalGov,
+			wr.DryRun)
 	}
+	logPrecedence(owners, names)
 
 	return nil
 }
 
-func logChanges(cpus []int, minFreq, maxFreq, scalingGov string) {
+// logPrecedence reports the rule finally setting each setting of each CPU,
+// when several rules apply.
+func logPrecedence(owners map[string]map[int]string, rules []string) {
+	if len(rules) < 2 {
+		return
+	}
+
+	msgs := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		var owned []string
+		for _, setting := range []string{"scaling-governor", "min-freq", "max-freq"} {
+			var cpus []int
+			for cpu, owner := range owners[setting] {
+				if owner == rule {
+					cpus = append(cpus, cpu)
+				}
+			}
+			if len(cpus) > 0 {
+				owned = append(owned, fmt.Sprintf("the %s of CPUs %s", setting,
+					cpulists.GenCPUlist(cpus)))
+			}
+		}
+		if len(owned) == 0 {
+			msgs = append(msgs, fmt.Sprintf("Rule %s owns no setting", rule))
+			continue
+		}
+		msgs = append(msgs, fmt.Sprintf("Rule %s owns %s", rule, strings.Join(owned, ", ")))
+	}
+	log.Println("Precedence:")
+	utils.LogTreeStyle(msgs)
+}
+
+func logChanges(cpus []int, minFreq, maxFreq, scalingGov string, dryRun bool) {
 	pluralSuffix := "s"
 	if len(cpus) == 1 {
 		pluralSuffix = ""
 	}
 	cpuList := cpulists.GenCPUlist(cpus)
+	verb := "Set"
+	if dryRun {
+		verb = "Would set"
+	}
 
 	var msg []string
 	if scalingGov != "" {
 		msg = append(msg,
-			fmt.Sprintf("Set scaling governance of CPU%s %s to %s", pluralSuffix,
+			fmt.Sprintf("%s scaling governance of CPU%s %s to %s", verb, pluralSuffix,
 				cpuList, scalingGov))
 	}
 	if minFreq != "" {
 		msg = append(msg,
-			fmt.Sprintf("Set min frequency of CPU%s %s to %s", pluralSuffix,
+			fmt.Sprintf("%s min frequency of CPU%s %s to %s", verb, pluralSuffix,
 				cpuList, minFreq))
 	}
 	if maxFreq != "" {
 		msg = append(msg,
-			fmt.Sprintf("Set max frequency of CPU%s %s to %s", pluralSuffix,
+			fmt.Sprintf("%s max frequency of CPU%s %s to %s", verb, pluralSuffix,
 				cpuList, maxFreq))
 	}
 
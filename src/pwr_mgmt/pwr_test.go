@@ -4,67 +4,59 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/canonical/rt-conf/src/cpulists"
 	"github.com/canonical/rt-conf/src/model"
+	"github.com/canonical/rt-conf/src/sysfs"
+	"go.yaml.in/yaml/v4"
 )
 
-// setupTempDirWithFiles creates a temporary directory and then creates n files
-// named "0", "1", ..., "n-1" inside that directory. It fails the test if any
-// error occurs.
-func setupTempDirWithFiles(t *testing.T, prvRule string, maxCpus int) string {
+// setupSysfsRoot makes the tuners use a temporary root holding maxCpus CPUs
+// and their cpufreq files, with prvRule as scaling governor. It fails the test
+// if any error occurs.
+func setupSysfsRoot(t *testing.T, prvRule string, maxCpus int) string {
 	t.Helper()
 
-	tempDir, err := os.MkdirTemp("", "tempfiles-")
-	if err != nil {
-		t.Fatalf("failed to create temp directory: %v", err)
-	}
+	root := t.TempDir()
+	sysfs.SetRoot(root)
+	t.Cleanup(func() { sysfs.SetRoot("") })
 
-	// Clean up the temp directory after the test finishes.
-	t.Cleanup(func() {
-		if err := os.RemoveAll(tempDir); err != nil {
-			t.Fatalf("failed to remove temp directory: %v", err)
-		}
-	})
-
-	// Create files from 0 to n-1.
-	for i := range maxCpus {
-		filename := strconv.Itoa(i)
-		cpuPath := filepath.Join(tempDir, filename)
-		if err := os.Mkdir(cpuPath, 0o755); err != nil {
-			t.Fatalf("failed to create directory %s: %v", cpuPath, err)
+	if maxCpus > 0 {
+		present := filepath.Join(root, "sys/devices/system/cpu/present")
+		if err := os.MkdirAll(filepath.Dir(present), 0o755); err != nil {
+			t.Fatalf("failed to create directory of %s: %v", present, err)
 		}
-
-		scalGov := filepath.Join(cpuPath, "scalgov")
-		fscalGov, err := os.Create(scalGov)
-		if err != nil {
-			t.Fatalf("failed to create file %s: %v", scalGov, err)
+		content := fmt.Sprintf("0-%d\n", maxCpus-1)
+		if err := os.WriteFile(present, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to create file %s: %v", present, err)
 		}
+	}
 
-		nb, err := fscalGov.Write([]byte(prvRule))
-		if err != nil {
-			t.Fatalf("failed to write to file %s: %v", scalGov, err)
+	for cpu := range maxCpus {
+		files := map[string]string{
+			scalingGovernorPath: prvRule,
+			minFreqPath:         "0",
+			maxFreqPath:         "0",
 		}
-		if nb != len(prvRule) {
-			t.Fatalf("number of written bytes doesn't match on file %s",
-				scalGov)
-		}
-		if err := fscalGov.Close(); err != nil {
-			t.Fatalf("failed to close file %s: %v", scalGov, err)
-		}
-
-		for _, file := range []string{"maxfreq", "minfreq"} {
-			filePath := filepath.Join(cpuPath, file)
-			if err := os.WriteFile(filePath, []byte("0"), 0o644); err != nil {
+		for path, content := range files {
+			filePath := cpufreqFile(root, path, cpu)
+			if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+				t.Fatalf("failed to create directory of %s: %v", filePath, err)
+			}
+			if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
 				t.Fatalf("failed to create file %s: %v", filePath, err)
 			}
 		}
 	}
 
-	return tempDir
+	return root
+}
+
+// cpufreqFile returns the path of a cpufreq file of cpu under root
+func cpufreqFile(root, path string, cpu int) string {
+	return filepath.Join(root, fmt.Sprintf(path, cpu))
 }
 
 func TestPwrMgmt(t *testing.T) {
@@ -187,14 +179,9 @@ func TestPwrMgmt(t *testing.T) {
 
 	for index, tc := range happyCases {
 		t.Run(fmt.Sprintf("case-%d", index), func(t *testing.T) {
-			basePath := setupTempDirWithFiles(t, tc.prevRule, tc.maxCpus)
-
-			// Create a new ReaderWriter instance with the base path
-			pwrmgmtReaderWriter.ScalingGovernorPath = basePath + "/%d/scalgov"
-			pwrmgmtReaderWriter.MinFreqPath = basePath + "/%d/minfreq"
-			pwrmgmtReaderWriter.MaxFreqPath = basePath + "/%d/maxfreq"
+			basePath := setupSysfsRoot(t, tc.prevRule, tc.maxCpus)
 
-			err := pwrmgmtReaderWriter.applyPwrConfig(tc.d)
+			err := ReaderWriter{}.applyPwrConfig(tc.d)
 			if err != nil {
 				t.Fatalf("error: %v", err)
 			}
@@ -207,7 +194,7 @@ func TestPwrMgmt(t *testing.T) {
 				}
 				for cpu := range parsedCpus {
 					content, err := os.ReadFile(
-						filepath.Join(basePath, strconv.Itoa(cpu), "scalgov"))
+						cpufreqFile(basePath, scalingGovernorPath, cpu))
 					if err != nil {
 						t.Fatalf("error reading file: %v", err)
 					}
@@ -450,9 +437,13 @@ func TestApplyRuleUnhappy(t *testing.T) {
 		},
 	}
 
+	// No cpufreq files to write
+	sysfs.SetRoot(t.TempDir())
+	t.Cleanup(func() { sysfs.SetRoot("") })
+
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			err := pwrmgmtReaderWriter.applyRule(0, tc.sclgov)
+			err := ReaderWriter{}.applyRule(0, tc.sclgov)
 			if err == nil {
 				t.Fatalf(
 					"expected error when processing %+v got nil", tc.sclgov,
@@ -461,3 +452,80 @@ func TestApplyRuleUnhappy(t *testing.T) {
 		})
 	}
 }
+
+func TestPwrMgmtDryRun(t *testing.T) {
+	basePath := setupSysfsRoot(t, "powersave", 1)
+
+	wr := ReaderWriter{DryRun: true}
+
+	rules := model.PwrMgmt{
+		"foo": {
+			CPUs:    "0",
+			ScalGov: "performance",
+			MinFreq: "1GHz",
+			MaxFreq: "2GHz",
+		},
+	}
+	if err := wr.applyPwrConfig(rules); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		scalingGovernorPath: "powersave",
+		minFreqPath:         "0",
+		maxFreqPath:         "0",
+	}
+	for file, value := range expected {
+		content, err := os.ReadFile(cpufreqFile(basePath, file, 0))
+		if err != nil {
+			t.Fatalf("error reading file: %v", err)
+		}
+		if string(content) != value {
+			t.Errorf("dry-run must not write, %s changed to %q",
+				file, string(content))
+		}
+	}
+}
+
+func TestPwrMgmtDryRunMissingFile(t *testing.T) {
+	setupSysfsRoot(t, "", 0)
+
+	wr := ReaderWriter{DryRun: true}
+	err := wr.WriteScalingGov("performance", 0)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "error reading") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+func TestPwrMgmtPrecedence(t *testing.T) {
+	basePath := setupSysfsRoot(t, "balanced", 2)
+
+	var rules model.PwrMgmt
+	// Both rules set the governor of CPU 1, the last one in the file wins
+	if err := yaml.Unmarshal([]byte(`
+all:
+  cpus: "0-1"
+  scaling-governor: "powersave"
+rt:
+  cpus: "1"
+  scaling-governor: "performance"
+`), &rules); err != nil {
+		t.Fatal(err)
+	}
+	if err := (ReaderWriter{}).applyPwrConfig(rules); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for cpu, expected := range []string{"powersave", "performance"} {
+		content, err := os.ReadFile(cpufreqFile(basePath, scalingGovernorPath, cpu))
+		if err != nil {
+			t.Fatalf("error reading file: %v", err)
+		}
+		if string(content) != expected {
+			t.Errorf("expected %q on CPU %d, got %q", expected, cpu, content)
+		}
+	}
+}
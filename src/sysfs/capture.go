@@ -0,0 +1,190 @@
+package sysfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/canonical/rt-conf/src/debug"
+)
+
+// captured are the files read by rt-conf, as path.Match patterns
+var captured = []string{
+	"/proc/cmdline",
+	"/proc/irq/default_smp_affinity",
+	"/proc/irq/*/smp_affinity_list",
+	"/sys/kernel/irq/*/*",
+	"/sys/devices/system/cpu/present",
+	"/sys/bus/pci/devices/*/msi_irqs/*",
+	"/sys/bus/pci/devices/*/uevent",
+	"/sys/bus/pci/devices/*/irq",
+	"/sys/class/net/*/device/msi_irqs/*",
+	"/sys/class/net/*/device/irq",
+	"/sys/devices/system/cpu/cpu*/cpufreq/scaling_*",
+}
+
+// Snapshot is the content of the files captured from an FS, by path
+type Snapshot map[string][]byte
+
+// Capture snapshots the files of fs read by rt-conf. Missing and unreadable
+// files are skipped, e.g. cpufreq on virtual machines.
+func Capture(fs FS) (Snapshot, error) {
+	s := make(Snapshot)
+	for _, pattern := range captured {
+		names, err := glob(fs, pattern, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			content, err := fs.ReadFile(name)
+			if err != nil {
+				debug.Printf("Skipping %s: %v", name, err)
+				continue
+			}
+			s[name] = content
+		}
+	}
+	return s, nil
+}
+
+// glob returns the names of the files of fs matching pattern, or of its
+// directories when dirs is set.
+func glob(fs FS, pattern string, dirs bool) ([]string, error) {
+	dir, file := path.Split(pattern)
+	dir = path.Clean(dir)
+
+	parents := []string{dir}
+	if strings.ContainsAny(dir, "*?[") {
+		var err error
+		if parents, err = glob(fs, dir, true); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.ContainsAny(file, "*?[") {
+		for i, d := range parents {
+			parents[i] = path.Join(d, file)
+		}
+		return parents, nil
+	}
+
+	var names []string
+	for _, d := range parents {
+		entries, err := fs.ReadDir(d)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", d, err)
+		}
+		for _, entry := range entries {
+			if match, _ := path.Match(file, entry.Name()); !match {
+				continue
+			}
+			// Directories are symlinks in sysfs, e.g. cpufreq
+			isDir := entry.IsDir() || entry.Type()&os.ModeSymlink != 0
+			if isDir == dirs {
+				names = append(names, path.Join(d, entry.Name()))
+			}
+		}
+	}
+	return names, nil
+}
+
+// paths returns the paths of the snapshot, sorted.
+func (s Snapshot) paths() []string {
+	paths := make([]string, 0, len(s))
+	for p := range s {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// WriteTar writes the snapshot to w as a tar archive.
+func (s Snapshot) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, p := range s.paths() {
+		hdr := &tar.Header{
+			Name: strings.TrimPrefix(p, "/"),
+			Mode: 0o644,
+			Size: int64(len(s[p])),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(s[p]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ReadTar reads a snapshot written by WriteTar.
+func ReadTar(r io.Reader) (Snapshot, error) {
+	s := make(Snapshot)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the capture: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		var content bytes.Buffer
+		if _, err := io.Copy(&content, tr); err != nil {
+			return nil, fmt.Errorf("failed to read %s from the capture: %v", hdr.Name, err)
+		}
+		// Cleaning the absolute path keeps the files under the root
+		s[path.Clean("/"+hdr.Name)] = content.Bytes()
+	}
+}
+
+// Extract writes the files of the snapshot under dir, so that Dir(dir)
+// reads them.
+func (s Snapshot) Extract(dir string) error {
+	for _, p := range s.paths() {
+		file := filepath.Join(dir, p)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, s[p], 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Change is a file whose content differs from the one of the snapshot
+type Change struct {
+	Path string
+	Old  string
+	New  string
+}
+
+// Diff returns the files of the snapshot whose content differs in fs.
+func (s Snapshot) Diff(fs FS) ([]Change, error) {
+	var changes []Change
+	for _, p := range s.paths() {
+		content, err := fs.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		before := strings.TrimSpace(string(s[p]))
+		after := strings.TrimSpace(string(content))
+		if before != after {
+			changes = append(changes, Change{Path: p, Old: before, New: after})
+		}
+	}
+	return changes, nil
+}
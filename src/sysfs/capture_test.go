@@ -0,0 +1,112 @@
+package sysfs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// setupFixture writes files, by path, under a temporary root.
+func setupFixture(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for p, content := range files {
+		file := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestCapture(t *testing.T) {
+	root := setupFixture(t, map[string]string{
+		"/proc/cmdline":                                            "quiet nohz=on\n",
+		"/proc/irq/7/smp_affinity_list":                            "0-3\n",
+		"/proc/irq/7/spurious":                                     "count 0\n",
+		"/sys/kernel/irq/7/actions":                                "eth0\n",
+		"/sys/kernel/irq/7/chip_name":                              "PCI-MSI\n",
+		"/sys/devices/system/cpu/present":                          "0-3\n",
+		"/sys/devices/system/cpu/cpufreq/policy0/scaling_governor": "powersave\n",
+		"/sys/devices/system/cpu/cpufreq/policy0/cpuinfo_max_freq": "4000000\n",
+	})
+	// The cpufreq directory of a CPU is a symlink to its policy
+	if err := os.MkdirAll(filepath.Join(root, "sys/devices/system/cpu/cpu0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../cpufreq/policy0",
+		filepath.Join(root, "sys/devices/system/cpu/cpu0/cpufreq")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Capture(Dir(root))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := Snapshot{
+		"/proc/cmdline":                                         []byte("quiet nohz=on\n"),
+		"/proc/irq/7/smp_affinity_list":                         []byte("0-3\n"),
+		"/sys/kernel/irq/7/actions":                             []byte("eth0\n"),
+		"/sys/kernel/irq/7/chip_name":                           []byte("PCI-MSI\n"),
+		"/sys/devices/system/cpu/present":                       []byte("0-3\n"),
+		"/sys/devices/system/cpu/cpu0/cpufreq/scaling_governor": []byte("powersave\n"),
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	s := Snapshot{
+		"/proc/irq/7/smp_affinity_list": []byte("0-3\n"),
+		"/proc/irq/8/smp_affinity_list": []byte("0-3\n"),
+	}
+
+	var archive bytes.Buffer
+	if err := s.WriteTar(&archive); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	read, err := ReadTar(&archive)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(read, s) {
+		t.Fatalf("expected %q, got %q", s, read)
+	}
+
+	overlay := t.TempDir()
+	if err := read.Extract(overlay); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := Dir(overlay).WriteFile("/proc/irq/8/smp_affinity_list", []byte("1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	changes, err := read.Diff(Dir(overlay))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Change{{Path: "/proc/irq/8/smp_affinity_list", Old: "0-3", New: "1"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+}
+
+func TestReadTarOutsideRoot(t *testing.T) {
+	var archive bytes.Buffer
+	if err := (Snapshot{"/../../etc/passwd": []byte("x")}).WriteTar(&archive); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ReadTar(&archive)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := s["/etc/passwd"]; !ok || len(s) != 1 {
+		t.Errorf("expected the file to be kept under the root, got %q", s)
+	}
+}
@@ -0,0 +1,59 @@
+// Package sysfs reads and writes the kernel interfaces of procfs and sysfs
+// used by the runtime tuners, under a root which is the one of the running
+// system by default.
+package sysfs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// FS reads and writes the files of /proc and /sys. Names are the absolute
+// paths of the running system, e.g. /proc/irq.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]os.DirEntry, error)
+	// WriteFile writes data to the existing file name, the kernel interfaces
+	// can't be created.
+	WriteFile(name string, data []byte) error
+}
+
+// Dir is the FS of a directory, e.g. a captured sysfs tree or a container's
+// root, holding the proc and sys directories.
+type Dir string
+
+func (d Dir) path(name string) string {
+	return filepath.Join(string(d), name)
+}
+
+func (d Dir) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(d.path(name))
+}
+
+func (d Dir) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(d.path(name))
+}
+
+func (d Dir) WriteFile(name string, data []byte) error {
+	f, err := os.OpenFile(d.path(name), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Root is the FS the runtime tuners read and write
+var Root FS = Dir("/")
+
+// SetRoot makes the runtime tuners use the /proc and /sys of dir, instead
+// of the ones of the running system when empty.
+func SetRoot(dir string) {
+	if dir == "" {
+		dir = "/"
+	}
+	Root = Dir(dir)
+}
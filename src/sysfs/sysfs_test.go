@@ -0,0 +1,61 @@
+package sysfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	root := t.TempDir()
+	irq := filepath.Join(root, "proc", "irq", "7")
+	if err := os.MkdirAll(irq, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	affinity := filepath.Join(irq, "smp_affinity_list")
+	if err := os.WriteFile(affinity, []byte("0-3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := Dir(root)
+	entries, err := fs.ReadDir("/proc/irq")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "7" {
+		t.Fatalf("expected the IRQ 7 directory, got %v", entries)
+	}
+
+	if err := fs.WriteFile("/proc/irq/7/smp_affinity_list", []byte("1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, err := fs.ReadFile("/proc/irq/7/smp_affinity_list")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content) != "1" {
+		t.Fatalf("expected %q, got %q", "1", content)
+	}
+
+	// The kernel interfaces can't be created
+	err = fs.WriteFile("/proc/irq/8/smp_affinity_list", []byte("1"))
+	if err == nil {
+		t.Fatal("expected error for a missing file, got nil")
+	}
+	if _, err := os.Stat(filepath.Join(root, "proc", "irq", "8")); err == nil {
+		t.Fatal("the missing file must not be created")
+	}
+}
+
+func TestSetRoot(t *testing.T) {
+	t.Cleanup(func() { Root = Dir("/") })
+
+	SetRoot("/tmp/capture")
+	if Root != Dir("/tmp/capture") {
+		t.Fatalf("expected root /tmp/capture, got %v", Root)
+	}
+	SetRoot("")
+	if Root != Dir("/") {
+		t.Fatalf("expected root /, got %v", Root)
+	}
+}
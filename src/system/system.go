@@ -1,7 +1,11 @@
 package system
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -13,33 +17,322 @@ const (
 	Rpi
 	Uboot
 	UbuntuCore
+	SystemdBoot
 )
 
-var baseDir = "" // baseDir is used to mock the file system in tests
+// systemNames are the names of the bootloaders, as set by --bootloader
+var systemNames = map[SystemType]string{
+	Unknown:     "unknown",
+	Grub:        "grub",
+	Rpi:         "rpi",
+	Uboot:       "uboot",
+	UbuntuCore:  "ubuntu-core",
+	SystemdBoot: "systemd-boot",
+}
+
+func (s SystemType) String() string {
+	if name, ok := systemNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("SystemType(%d)", int(s))
+}
+
+// ParseSystemType returns the bootloader named name, see SystemNames.
+func ParseSystemType(name string) (SystemType, error) {
+	for s, n := range systemNames {
+		if s != Unknown && n == name {
+			return s, nil
+		}
+	}
+	return Unknown, fmt.Errorf("unknown bootloader %q, expected one of %s",
+		name, strings.Join(SystemNames(), ", "))
+}
+
+// SystemNames returns the names of the supported bootloaders.
+func SystemNames() []string {
+	var names []string
+	for s, n := range systemNames {
+		if s != Unknown {
+			names = append(names, n)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Confidence is how sure a detector is that the system boots with its
+// bootloader
+type Confidence int
+
+const (
+	None Confidence = iota
+	Low
+	Medium
+	High
+)
+
+func (c Confidence) String() string {
+	switch c {
+	case None:
+		return "none"
+	case Low:
+		return "low"
+	case Medium:
+		return "medium"
+	case High:
+		return "high"
+	}
+	return fmt.Sprintf("Confidence(%d)", int(c))
+}
+
+// Detection is the result of a detector, along with the evidence it found
+type Detection struct {
+	System     SystemType
+	Confidence Confidence
+	Evidence   []string
+}
+
+// found records a piece of evidence, raising the confidence to c.
+func (d *Detection) found(c Confidence, format string, a ...any) {
+	d.Evidence = append(d.Evidence, fmt.Sprintf(format, a...))
+	d.Confidence = max(d.Confidence, c)
+}
+
+// Detector looks for the evidence of a bootloader
+type Detector func() (Detection, error)
+
+// Detectors are run in order by Detect. Their order doesn't decide between
+// bootloaders found with the same confidence, which are ambiguous, see
+// Ambiguous.
+var Detectors = []Detector{
+	detectUbuntuCore,
+	detectRpi,
+	detectUboot,
+	detectSystemdBoot,
+	detectGrub,
+}
+
+var baseDir = "" // baseDir is the root filesystem to inspect, see SetRoot
+
+// offline is set when inspecting a root filesystem other than the running
+// system's, whose environment is then irrelevant
+var offline bool
+
+// SetRoot makes the detectors inspect the root filesystem mounted on root,
+// e.g. an image, instead of the running system, when not empty.
+func SetRoot(root string) {
+	baseDir = root
+	offline = root != ""
+}
+
+// U-Boot reads either a generic distro configuration file or a boot script.
+// See: https://docs.u-boot.org/en/latest/develop/distro.html
+var ubootFiles = []string{
+	"/boot/extlinux/extlinux.conf",
+	"/boot/boot.scr",
+	"/boot/firmware/boot.scr",
+}
+
+// systemd-boot keeps its configuration and Boot Loader Specification entries
+// in the ESP or XBOOTLDR partition, usually mounted on one of these. Only
+// systemd-boot reads loader.conf.
+// See: https://uapi-group.org/specifications/specs/boot_loader_specification/
+var sdbootFiles = []string{
+	"/efi/loader/loader.conf",
+	"/boot/loader/loader.conf",
+	"/boot/efi/loader/loader.conf",
+}
+
+// sdbootEntries are only an indication: GRUB reads BLS entries too, e.g. on
+// Fedora and RHEL
+var sdbootEntries = []string{
+	"/efi/loader/entries",
+	"/boot/loader/entries",
+	"/boot/efi/loader/entries",
+}
+
+// sdbootLoaderInfo is the EFI variable set by systemd-boot when booting
+// See: https://systemd.io/BOOT_LOADER_INTERFACE/
+const sdbootLoaderInfo = "/sys/firmware/efi/efivars/LoaderInfo-4a67b082-0a4c-41cf-b6c7-440b29bb8c4f"
+
+// RpiCmdlineFiles are the locations of cmdline.txt, where the Raspberry Pi
+// firmware reads the kernel command line from. The boot partition is mounted
+// on /boot/firmware on current images and on /boot on old style ones.
+var RpiCmdlineFiles = []string{
+	"/boot/firmware/cmdline.txt",
+	"/boot/cmdline.txt",
+}
 
+// Kernel parameters set by the Ubuntu Core bootloaders
+var ucCmdlineParams = []string{
+	"snapd_recovery_mode=", // Ubuntu Core 20 and later
+	"snap_kernel=",         // Ubuntu Core 16 and 18
+}
+
+// DetectSystem returns the bootloader found with the highest confidence by
+// the Detectors, Unknown if none was found. It fails when several of them
+// were found with the highest confidence.
 var DetectSystem = func() (SystemType, error) {
-	// Verify if SNAP_SAVE_DATA is present, indicating the system is Ubuntu Core
-	// see: https://snapcraft.io/docs/environment-variables#heading--snap-save-data
-	_, isUC := os.LookupEnv("SNAP_SAVE_DATA")
-	if isUC {
-		return UbuntuCore, nil
+	detections, err := Detect()
+	if err != nil {
+		return Unknown, err
+	}
+	if len(detections) == 0 {
+		return Unknown, nil
 	}
+	if tied := Ambiguous(detections); tied != nil {
+		return Unknown, fmt.Errorf("%s were found with %s confidence, set the bootloader to use",
+			JoinSystems(tied), detections[0].Confidence)
+	}
+	return detections[0].System, nil
+}
+
+// Ambiguous returns the bootloaders found with the highest confidence, as
+// sorted by Detect, when there are several of them, nil otherwise.
+func Ambiguous(detections []Detection) []SystemType {
+	var tied []SystemType
+	for _, d := range detections {
+		if d.Confidence == detections[0].Confidence {
+			tied = append(tied, d.System)
+		}
+	}
+	if len(tied) < 2 {
+		return nil
+	}
+	return tied
+}
+
+// JoinSystems lists bootloaders, e.g. "grub and systemd-boot".
+func JoinSystems(systems []SystemType) string {
+	names := make([]string, len(systems))
+	for i, sys := range systems {
+		names[i] = sys.String()
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
 
-	if _, err := os.Stat(baseDir + "/proc/device-tree/model"); err == nil {
-		content, err := os.ReadFile("/proc/device-tree/model")
+// Detect runs the Detectors, and returns the bootloaders they found, from
+// the highest confidence to the lowest.
+func Detect() ([]Detection, error) {
+	var detections []Detection
+	for _, detect := range Detectors {
+		d, err := detect()
 		if err != nil {
-			return Unknown, err
+			return nil, err
 		}
+		if d.Confidence > None {
+			detections = append(detections, d)
+		}
+	}
+	slices.SortStableFunc(detections, func(a, b Detection) int {
+		return int(b.Confidence) - int(a.Confidence)
+	})
+	return detections, nil
+}
 
-		if strings.Contains(string(content), "Raspberry Pi") {
-			return Rpi, nil
+func exists(path string) bool {
+	_, err := os.Stat(baseDir + path)
+	return err == nil
+}
+
+// readFile returns the content of path, empty if it doesn't exist.
+func readFile(path string) (string, error) {
+	content, err := os.ReadFile(baseDir + path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return string(content), err
+}
+
+func detectUbuntuCore() (Detection, error) {
+	d := Detection{System: UbuntuCore}
+	cmdline, err := readFile("/proc/cmdline")
+	if err != nil {
+		return d, err
+	}
+	for _, p := range strings.Fields(cmdline) {
+		for _, uc := range ucCmdlineParams {
+			if strings.HasPrefix(p, uc) {
+				d.found(High, "the kernel command line contains %s", p)
+			}
 		}
-		return Unknown, nil
 	}
+	// Only an indication, SNAP_SAVE_DATA isn't specific to Ubuntu Core
+	// see: https://snapcraft.io/docs/environment-variables#heading--snap-save-data
+	if _, ok := os.LookupEnv("SNAP_SAVE_DATA"); ok && !offline {
+		d.found(Low, "SNAP_SAVE_DATA is set")
+	}
+	return d, nil
+}
 
-	if _, err := os.Stat(baseDir + "/etc/default/grub"); err == nil {
-		return Grub, nil
+func detectRpi() (Detection, error) {
+	d := Detection{System: Rpi}
+	model, err := readFile("/proc/device-tree/model")
+	if err != nil {
+		return d, err
+	}
+	isRpi := strings.Contains(model, "Raspberry Pi")
+	if isRpi {
+		d.found(Medium, "the device tree model is %s", strings.TrimRight(model, "\x00\n"))
+	}
+	for _, f := range RpiCmdlineFiles {
+		if !exists(f) {
+			continue
+		}
+		switch {
+		case isRpi:
+			d.found(High, "%s exists", f)
+		case exists(filepath.Join(filepath.Dir(f), "config.txt")):
+			// No device tree in an image, but the firmware files
+			d.found(Medium, "%s and config.txt exist", f)
+		}
 	}
+	return d, nil
+}
 
-	return Unknown, nil
+func detectUboot() (Detection, error) {
+	d := Detection{System: Uboot}
+	// U-Boot mostly runs on device tree machines
+	confidence := Low
+	if exists("/proc/device-tree/model") {
+		confidence = Medium
+	}
+	for _, f := range ubootFiles {
+		if exists(f) {
+			d.found(confidence, "%s exists", f)
+		}
+	}
+	return d, nil
+}
+
+func detectSystemdBoot() (Detection, error) {
+	d := Detection{System: SystemdBoot}
+	for _, f := range sdbootFiles {
+		if exists(f) {
+			d.found(High, "%s exists", f)
+		}
+	}
+	for _, f := range sdbootEntries {
+		if exists(f) {
+			d.found(Low, "%s exists", f)
+		}
+	}
+	if exists(sdbootLoaderInfo) {
+		d.found(High, "the LoaderInfo EFI variable is set")
+	}
+	return d, nil
+}
+
+func detectGrub() (Detection, error) {
+	d := Detection{System: Grub}
+	if exists("/etc/default/grub") {
+		d.found(Medium, "/etc/default/grub exists")
+	}
+	if exists("/boot/grub/grub.cfg") {
+		d.found(Low, "/boot/grub/grub.cfg exists")
+	}
+	return d, nil
 }
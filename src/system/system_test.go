@@ -2,6 +2,9 @@ package system
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -62,3 +65,306 @@ func TestDetectSystemUnknown(t *testing.T) {
 		t.Fatalf("expected Unknown, got %v", sys)
 	}
 }
+
+func TestDetectSystemUboot(t *testing.T) {
+	if err := os.Unsetenv("SNAP_SAVE_DATA"); err != nil {
+		t.Fatalf("failed to unset env: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		model     string
+		files     []string
+		expected  SystemType
+		ambiguous string
+	}{
+		{
+			name:     "Raspberry Pi",
+			model:    "Raspberry Pi 4 Model B Rev 1.4",
+			files:    []string{"/boot/firmware/cmdline.txt", "/boot/firmware/boot.scr"},
+			expected: Rpi,
+		},
+		{
+			// Both the firmware and U-Boot may read the command line
+			name:      "Raspberry Pi without cmdline.txt",
+			model:     "Raspberry Pi 4 Model B Rev 1.4",
+			files:     []string{"/boot/firmware/boot.scr"},
+			expected:  Unknown,
+			ambiguous: "rpi and uboot were found with medium confidence",
+		},
+		{
+			name:     "extlinux.conf",
+			model:    "Toradex Verdin iMX8M Plus",
+			files:    []string{"/boot/extlinux/extlinux.conf"},
+			expected: Uboot,
+		},
+		{
+			name:     "boot.scr",
+			model:    "Toradex Verdin iMX8M Plus",
+			files:    []string{"/boot/boot.scr"},
+			expected: Uboot,
+		},
+		{
+			name:     "No bootloader files",
+			model:    "Toradex Verdin iMX8M Plus",
+			expected: Unknown,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			baseDir = t.TempDir()
+			t.Cleanup(func() { baseDir = "" })
+
+			files := append([]string{"/proc/device-tree/model"}, tc.files...)
+			for _, f := range files {
+				path := filepath.Join(baseDir, f)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatalf("failed to mkdir: %v", err)
+				}
+				if err := os.WriteFile(path, []byte(tc.model), 0o644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+			}
+
+			sys, err := DetectSystem()
+			if tc.ambiguous != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.ambiguous) {
+					t.Fatalf("expected an error containing %q, got %v", tc.ambiguous, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sys != tc.expected {
+				t.Fatalf("expected %v, got %v", tc.expected, sys)
+			}
+		})
+	}
+}
+
+func TestDetectSystemSystemdBoot(t *testing.T) {
+	if err := os.Unsetenv("SNAP_SAVE_DATA"); err != nil {
+		t.Fatalf("failed to unset env: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		files    []string
+		dirs     []string
+		expected SystemType
+	}{
+		{
+			name:     "loader.conf on the ESP",
+			files:    []string{"/boot/efi/loader/loader.conf"},
+			expected: SystemdBoot,
+		},
+		{
+			name:     "Boot Loader Specification entries",
+			dirs:     []string{"/efi/loader/entries"},
+			expected: SystemdBoot,
+		},
+		{
+			name: "Preferred over GRUB",
+			files: []string{
+				"/boot/loader/loader.conf",
+				"/etc/default/grub",
+			},
+			expected: SystemdBoot,
+		},
+		{
+			name:     "GRUB only",
+			files:    []string{"/etc/default/grub"},
+			expected: Grub,
+		},
+		{
+			name:     "GRUB with Boot Loader Specification entries",
+			files:    []string{"/etc/default/grub"},
+			dirs:     []string{"/boot/loader/entries"},
+			expected: Grub,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			baseDir = t.TempDir()
+			t.Cleanup(func() { baseDir = "" })
+
+			for _, d := range tc.dirs {
+				if err := os.MkdirAll(filepath.Join(baseDir, d), 0o755); err != nil {
+					t.Fatalf("failed to mkdir: %v", err)
+				}
+			}
+			for _, f := range tc.files {
+				path := filepath.Join(baseDir, f)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatalf("failed to mkdir: %v", err)
+				}
+				if err := os.WriteFile(path, nil, 0o644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+			}
+
+			sys, err := DetectSystem()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sys != tc.expected {
+				t.Fatalf("expected %v, got %v", tc.expected, sys)
+			}
+		})
+	}
+}
+
+func TestSystemTypeNames(t *testing.T) {
+	for _, name := range SystemNames() {
+		sys, err := ParseSystemType(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if sys.String() != name {
+			t.Errorf("expected %q, got %q", name, sys.String())
+		}
+	}
+
+	for _, name := range []string{"unknown", "lilo", ""} {
+		if _, err := ParseSystemType(name); err == nil {
+			t.Errorf("expected an error for %q", name)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		env      bool
+		expected []Detection
+		tied     []SystemType
+	}{
+		{
+			name: "Device tree machine booting with GRUB",
+			files: map[string]string{
+				"/proc/device-tree/model": "Toradex Verdin iMX8M Plus\x00",
+				"/etc/default/grub":       "",
+				"/boot/grub/grub.cfg":     "",
+			},
+			expected: []Detection{{
+				System:     Grub,
+				Confidence: Medium,
+				Evidence:   []string{"/etc/default/grub exists", "/boot/grub/grub.cfg exists"},
+			}},
+		},
+		{
+			name: "SNAP_SAVE_DATA on a classic system",
+			env:  true,
+			files: map[string]string{
+				"/etc/default/grub": "",
+			},
+			expected: []Detection{
+				{System: Grub, Confidence: Medium, Evidence: []string{"/etc/default/grub exists"}},
+				{System: UbuntuCore, Confidence: Low, Evidence: []string{"SNAP_SAVE_DATA is set"}},
+			},
+		},
+		{
+			name: "Ubuntu Core",
+			env:  true,
+			files: map[string]string{
+				"/proc/cmdline":       "snapd_recovery_mode=run console=ttyS0 panic=-1\n",
+				"/boot/grub/grub.cfg": "",
+			},
+			expected: []Detection{
+				{
+					System:     UbuntuCore,
+					Confidence: High,
+					Evidence: []string{
+						"the kernel command line contains snapd_recovery_mode=run",
+						"SNAP_SAVE_DATA is set",
+					},
+				},
+				{System: Grub, Confidence: Low, Evidence: []string{"/boot/grub/grub.cfg exists"}},
+			},
+		},
+		{
+			name: "Raspberry Pi",
+			files: map[string]string{
+				"/proc/device-tree/model":    "Raspberry Pi 4 Model B Rev 1.4\x00",
+				"/boot/firmware/cmdline.txt": "",
+				"/boot/firmware/boot.scr":    "",
+			},
+			expected: []Detection{
+				{
+					System:     Rpi,
+					Confidence: High,
+					Evidence: []string{
+						"the device tree model is Raspberry Pi 4 Model B Rev 1.4",
+						"/boot/firmware/cmdline.txt exists",
+					},
+				},
+				{System: Uboot, Confidence: Medium, Evidence: []string{"/boot/firmware/boot.scr exists"}},
+			},
+		},
+		{
+			name: "systemd-boot",
+			files: map[string]string{
+				sdbootLoaderInfo:    "",
+				"/etc/default/grub": "",
+			},
+			expected: []Detection{
+				{System: SystemdBoot, Confidence: High, Evidence: []string{"the LoaderInfo EFI variable is set"}},
+				{System: Grub, Confidence: Medium, Evidence: []string{"/etc/default/grub exists"}},
+			},
+		},
+		{
+			name: "U-Boot and GRUB on a device tree machine",
+			files: map[string]string{
+				"/proc/device-tree/model":      "Toradex Verdin iMX8M Plus\x00",
+				"/boot/extlinux/extlinux.conf": "",
+				"/etc/default/grub":            "",
+			},
+			expected: []Detection{
+				{System: Uboot, Confidence: Medium, Evidence: []string{"/boot/extlinux/extlinux.conf exists"}},
+				{System: Grub, Confidence: Medium, Evidence: []string{"/etc/default/grub exists"}},
+			},
+			tied: []SystemType{Uboot, Grub},
+		},
+		{
+			name: "Nothing found",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			baseDir = t.TempDir()
+			t.Cleanup(func() { baseDir = "" })
+			if tc.env {
+				t.Setenv("SNAP_SAVE_DATA", "/some/uc/path")
+			} else {
+				t.Setenv("SNAP_SAVE_DATA", "")
+				if err := os.Unsetenv("SNAP_SAVE_DATA"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for f, content := range tc.files {
+				path := filepath.Join(baseDir, f)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatalf("failed to mkdir: %v", err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+			}
+
+			detections, err := Detect()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(detections, tc.expected) {
+				t.Fatalf("expected %+v, got %+v", tc.expected, detections)
+			}
+			if tied := Ambiguous(detections); !reflect.DeepEqual(tied, tc.tied) {
+				t.Errorf("expected the tied bootloaders %v, got %v", tc.tied, tied)
+			}
+		})
+	}
+}
@@ -44,3 +44,9 @@ func LogTreeStyle(entries []string) {
 	}
 	log.Println()
 }
+
+// LogPlannedWrite reports a write that is skipped in dry-run mode,
+// along with the value currently in place.
+func LogPlannedWrite(path, current, next string) {
+	log.Printf("Would write %q to %s (current: %q)\n", next, path, current)
+}
@@ -69,3 +69,12 @@ func TestLogTreeStyle(t *testing.T) {
 		assertContains(t, output, line)
 	}
 }
+
+func TestLogPlannedWrite(t *testing.T) {
+	output := captureLogOutput(func() {
+		LogPlannedWrite("/proc/irq/10/smp_affinity_list", "0-3", "0")
+	})
+
+	assertContains(t, output,
+		`Would write "0" to /proc/irq/10/smp_affinity_list (current: "0-3")`)
+}